@@ -640,6 +640,8 @@ type DesugaredObjectField struct {
 	LocRange  LocationRange
 	Hide      ObjectFieldHide
 	PlusSuper bool
+	// The comments on their own lines preceding the field, if any.
+	Comment []string
 }
 
 // DesugaredObjectFields represents a DesugaredObjectField slice.
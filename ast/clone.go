@@ -242,6 +242,14 @@ func clone(astPtr *Node) {
 		for i := range r.Fields {
 			cloneDesugaredField(&r.Fields[i])
 		}
+		r.Asserts = append(make(Nodes, 0), r.Asserts...)
+		for i := range r.Asserts {
+			clone(&r.Asserts[i])
+		}
+		r.Locals = append(make(LocalBinds, 0), r.Locals...)
+		for i := range r.Locals {
+			clone(&r.Locals[i].Body)
+		}
 
 	case *ObjectComp:
 		r := new(ObjectComp)
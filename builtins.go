@@ -19,20 +19,71 @@ package jsonnet
 import (
 	"bytes"
 	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base32"
 	"encoding/base64"
+	"encoding/csv"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"math"
+	"math/big"
+	"path"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/google/go-jsonnet/ast"
 )
 
+var paramOrdinals = []string{"first", "second", "third", "fourth", "fifth"}
+
+// paramTypeError reports a parameter of a builtin function with an unexpected type.
+// The position of the parameter starts from 0.
+func (i *interpreter) paramTypeError(builtinName string, position int, bad value, expected string) error {
+	return i.Error(fmt.Sprintf("%s %s param must be %s, got %s", builtinName, paramOrdinals[position], expected, bad.getType().name))
+}
+
+func (i *interpreter) getArrayParam(builtinName string, position int, val value) (*valueArray, error) {
+	if arr, ok := val.(*valueArray); ok {
+		return arr, nil
+	}
+	return nil, i.paramTypeError(builtinName, position, val, "array")
+}
+
+func (i *interpreter) getObjectParam(builtinName string, position int, val value) (*valueObject, error) {
+	if obj, ok := val.(*valueObject); ok {
+		return obj, nil
+	}
+	return nil, i.paramTypeError(builtinName, position, val, "object")
+}
+
+func (i *interpreter) getFunctionParam(builtinName string, position int, val value) (*valueFunction, error) {
+	if fun, ok := val.(*valueFunction); ok {
+		return fun, nil
+	}
+	return nil, i.paramTypeError(builtinName, position, val, "function")
+}
+
+func (i *interpreter) getStringParam(builtinName string, position int, val value) (valueString, error) {
+	if str, ok := val.(valueString); ok {
+		return str, nil
+	}
+	return nil, i.paramTypeError(builtinName, position, val, "string")
+}
+
+func (i *interpreter) getBooleanParam(builtinName string, position int, val value) (*valueBoolean, error) {
+	if b, ok := val.(*valueBoolean); ok {
+		return b, nil
+	}
+	return nil, i.paramTypeError(builtinName, position, val, "boolean")
+}
+
 func builtinPlus(i *interpreter, x, y value) (value, error) {
 	// TODO(sbarzowski) perhaps a more elegant way to dispatch
 	switch right := y.(type) {
@@ -208,6 +259,30 @@ func builtinLessEq(i *interpreter, x, y value) (value, error) {
 	return makeValueBoolean(r <= 0), nil
 }
 
+// builtinCmp is the comparison of the < operator as a function returning -1, 0 or 1,
+// which also orders the booleans, false before true.
+func builtinCmp(i *interpreter, x, y value) (value, error) {
+	if left, ok := x.(*valueBoolean); ok {
+		right, err := i.getBoolean(y)
+		if err != nil {
+			return nil, err
+		}
+		return makeValueNumber(float64(intCmp(boolToInt(left.value), boolToInt(right.value)))), nil
+	}
+	r, err := valueCmp(i, x, y)
+	if err != nil {
+		return nil, err
+	}
+	return makeValueNumber(float64(r)), nil
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
 func builtinLength(i *interpreter, x value) (value, error) {
 	var num int
 	switch x := x.(type) {
@@ -224,7 +299,7 @@ func builtinLength(i *interpreter, x value) (value, error) {
 			}
 		}
 	default:
-		return nil, i.typeErrorGeneral(x)
+		return nil, i.paramTypeError("std.length", 0, x, "array, string, object or function")
 	}
 	return makeValueNumber(float64(num)), nil
 }
@@ -242,6 +317,44 @@ func builtinToString(i *interpreter, x value) (value, error) {
 	return makeValueString(buf.String()), nil
 }
 
+var xmlEscaper = strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;", "\"", "&quot;", "'", "&apos;")
+
+// builtinEscapeStringXml escapes the characters with a special meaning in XML text
+// and attribute values. Values other than strings are converted with std.toString.
+func builtinEscapeStringXml(i *interpreter, x value) (value, error) {
+	str, err := builtinToString(i, x)
+	if err != nil {
+		return nil, err
+	}
+	return makeValueString(xmlEscaper.Replace(str.(valueString).getGoString())), nil
+}
+
+// builtinEscapeStringUrl percent-encodes all the bytes of the UTF-8 encoding of a string
+// except the unreserved characters of RFC 3986, i.e. letters, digits, '-', '.', '_' and '~'.
+// Values other than strings are converted with std.toString.
+func builtinEscapeStringUrl(i *interpreter, x value) (value, error) {
+	str, err := builtinToString(i, x)
+	if err != nil {
+		return nil, err
+	}
+	const hexDigits = "0123456789ABCDEF"
+	sStr := str.(valueString).getGoString()
+	var buf strings.Builder
+	buf.Grow(len(sStr))
+	for n := 0; n < len(sStr); n++ {
+		c := sStr[n]
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			buf.WriteByte(c)
+		default:
+			buf.WriteByte('%')
+			buf.WriteByte(hexDigits[c>>4])
+			buf.WriteByte(hexDigits[c&15])
+		}
+	}
+	return makeValueString(buf.String()), nil
+}
+
 func builtinTrace(i *interpreter, x value, y value) (value, error) {
 	xStr, err := i.getString(x)
 	if err != nil {
@@ -265,15 +378,30 @@ type astMakeArrayElement struct {
 	index int
 }
 
+// checkArrayLength fails when a std function would build an array or a string
+// longer than the limit set by VM.SetMaxArrayLength.
+func (i *interpreter) checkArrayLength(builtinName string, length int) error {
+	if i.maxArrayLength > 0 && length > i.maxArrayLength {
+		return i.Error(fmt.Sprintf("%s: result too large (%d elements, the maximum is %d)", builtinName, length, i.maxArrayLength))
+	}
+	return nil
+}
+
 func builtinMakeArray(i *interpreter, szv, funcv value) (value, error) {
+	if _, ok := szv.(*valueNumber); !ok {
+		return nil, i.paramTypeError("std.makeArray", 0, szv, "number")
+	}
 	sz, err := i.getInt(szv)
 	if err != nil {
 		return nil, err
 	}
-	fun, err := i.getFunction(funcv)
+	fun, err := i.getFunctionParam("std.makeArray", 1, funcv)
 	if err != nil {
 		return nil, err
 	}
+	if err := i.checkArrayLength("std.makeArray", sz); err != nil {
+		return nil, err
+	}
 	var elems []*cachedThunk
 	for i := 0; i < sz; i++ {
 		elem := &cachedThunk{
@@ -311,10 +439,15 @@ func builtinFlatMap(i *interpreter, funcv, arrv value) (value, error) {
 				return nil, err
 			}
 			elems = append(elems, returned.elements...)
+			// The object comprehensions are built on the array ones, so they are limited here as well.
+			if err := i.checkComprehensionSize(len(elems)); err != nil {
+				return nil, err
+			}
 		}
 		return makeValueArray(elems), nil
 	case valueString:
 		var str strings.Builder
+		size := 0
 		for _, elem := range arrv.getRunes() {
 			returnedValue, err := fun.call(i, args(readyThunk(makeValueString(string(elem)))))
 			if err != nil {
@@ -324,6 +457,10 @@ func builtinFlatMap(i *interpreter, funcv, arrv value) (value, error) {
 			if err != nil {
 				return nil, err
 			}
+			size += returned.length()
+			if err := i.checkComprehensionSize(size); err != nil {
+				return nil, err
+			}
 			str.WriteString(returned.getGoString())
 		}
 		return makeValueString(str.String()), nil
@@ -332,6 +469,15 @@ func builtinFlatMap(i *interpreter, funcv, arrv value) (value, error) {
 	}
 }
 
+// checkComprehensionSize returns an error if the size of a comprehension or of
+// the result of std.flatMap exceeds the limit.
+func (i *interpreter) checkComprehensionSize(size int) error {
+	if i.maxComprehensionSize > 0 && size > i.maxComprehensionSize {
+		return i.Error(fmt.Sprintf("comprehension or std.flatMap result exceeded the maximum size of %d", i.maxComprehensionSize))
+	}
+	return nil
+}
+
 func joinArrays(i *interpreter, sep *valueArray, arr *valueArray) (value, error) {
 	result := make([]*cachedThunk, 0, arr.length())
 	first := true
@@ -382,7 +528,7 @@ func joinStrings(i *interpreter, sep valueString, arr *valueArray) (value, error
 }
 
 func builtinJoin(i *interpreter, sep, arrv value) (value, error) {
-	arr, err := i.getArray(arrv)
+	arr, err := i.getArrayParam("std.join", 1, arrv)
 	if err != nil {
 		return nil, err
 	}
@@ -392,8 +538,56 @@ func builtinJoin(i *interpreter, sep, arrv value) (value, error) {
 	case *valueArray:
 		return joinArrays(i, sep, arr)
 	default:
-		return nil, i.Error("join first parameter should be string or array, got " + sep.getType().name)
+		return nil, i.paramTypeError("std.join", 0, sep, "string or array")
+	}
+}
+
+// builtinLines splits a string into lines, or, for compatibility, joins an array of lines
+// into a string, ending each line with a newline. The lines may end with "\r\n",
+// the "\r" is removed. A single trailing newline doesn't start another, empty line.
+func builtinLines(i *interpreter, x value) (value, error) {
+	switch x := x.(type) {
+	case valueString:
+		str := x.getGoString()
+		if str == "" {
+			return makeValueArray(nil), nil
+		}
+		lines := strings.Split(strings.TrimSuffix(str, "\n"), "\n")
+		elems := make([]*cachedThunk, len(lines))
+		for counter, line := range lines {
+			elems[counter] = readyThunk(makeValueString(strings.TrimSuffix(line, "\r")))
+		}
+		return makeValueArray(elems), nil
+	case *valueArray:
+		elems := make([]*cachedThunk, 0, len(x.elements)+1)
+		elems = append(elems, x.elements...)
+		elems = append(elems, readyThunk(makeValueString("")))
+		return joinStrings(i, makeValueString("\n"), makeValueArray(elems))
+	default:
+		return nil, i.Error("std.lines first param must be string or array, got " + x.getType().name)
+	}
+}
+
+func builtinUnlines(i *interpreter, arrv value) (value, error) {
+	arr, err := i.getArrayParam("std.unlines", 0, arrv)
+	if err != nil {
+		return nil, err
+	}
+	return joinStrings(i, makeValueString("\n"), arr)
+}
+
+// builtinWords splits a string on the runs of Unicode whitespace.
+func builtinWords(i *interpreter, strv value) (value, error) {
+	str, err := i.getStringParam("std.words", 0, strv)
+	if err != nil {
+		return nil, err
+	}
+	words := strings.Fields(str.getGoString())
+	elems := make([]*cachedThunk, len(words))
+	for counter, word := range words {
+		elems[counter] = readyThunk(makeValueString(word))
 	}
+	return makeValueArray(elems), nil
 }
 
 func builtinFoldl(i *interpreter, funcv, arrv, initv value) (value, error) {
@@ -427,6 +621,32 @@ func builtinFoldl(i *interpreter, funcv, arrv, initv value) (value, error) {
 	return accValue, nil
 }
 
+// builtinFoldlObject folds the visible fields of an object in the sorted order of their names,
+// i.e. func(...func(func(init, k1, obj[k1]), k2, obj[k2])..., kn, obj[kn]).
+// The values are passed lazily, so the ones which func doesn't use are never evaluated.
+func builtinFoldlObject(i *interpreter, funcv, objv, initv value) (value, error) {
+	fun, err := i.getFunctionParam("std.foldlObject", 0, funcv)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := i.getObjectParam("std.foldlObject", 1, objv)
+	if err != nil {
+		return nil, err
+	}
+	accValue := initv
+	for _, fieldName := range objectFields(obj, withoutHidden) {
+		accValue, err = fun.call(i, args(readyThunk(accValue), readyThunk(makeValueString(fieldName)), objectFieldThunk(obj, fieldName)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return accValue, nil
+}
+
+// builtinFoldr folds the array from the right, i.e. func(arr[0], func(arr[1], ... func(arr[n-1], init))).
+// The calls are evaluated strictly from the last element to the first one, so func cannot
+// short-circuit the fold and the depth of the stack doesn't grow with the length of the array.
+// The elements are passed lazily, so the ones which func doesn't use are never evaluated.
 func builtinFoldr(i *interpreter, funcv, arrv, initv value) (value, error) {
 	fun, err := i.getFunction(funcv)
 	if err != nil {
@@ -459,7 +679,7 @@ func builtinFoldr(i *interpreter, funcv, arrv, initv value) (value, error) {
 }
 
 func builtinReverse(i *interpreter, arrv value) (value, error) {
-	arr, err := i.getArray(arrv)
+	arr, err := i.getArrayParam("std.reverse", 0, arrv)
 	if err != nil {
 		return nil, err
 	}
@@ -475,12 +695,61 @@ func builtinReverse(i *interpreter, arrv value) (value, error) {
 	return makeValueArray(reversedArray), nil
 }
 
+// builtinZip combines the arrays in arrs into an array of tuples, where the n-th tuple
+// holds the n-th elements of the arrays. The result is as long as the shortest array.
+func builtinZip(i *interpreter, arrsv value) (value, error) {
+	arrs, err := i.getArrayParam("std.zip", 0, arrsv)
+	if err != nil {
+		return nil, err
+	}
+	if arrs.length() == 0 {
+		return makeValueArray(nil), nil
+	}
+	inputs := make([]*valueArray, arrs.length())
+	length := -1
+	for index := range arrs.elements {
+		v, err := arrs.index(i, index)
+		if err != nil {
+			return nil, err
+		}
+		arr, ok := v.(*valueArray)
+		if !ok {
+			return nil, i.Error(fmt.Sprintf("std.zip element %d must be an array, got %s", index, v.getType().name))
+		}
+		inputs[index] = arr
+		if length < 0 || arr.length() < length {
+			length = arr.length()
+		}
+	}
+	tuples := make([]*cachedThunk, length)
+	for n := range tuples {
+		tuple := make([]*cachedThunk, len(inputs))
+		for index, arr := range inputs {
+			tuple[index] = arr.elements[n]
+		}
+		tuples[n] = readyThunk(makeValueArray(tuple))
+	}
+	return makeValueArray(tuples), nil
+}
+
+func builtinZipWithIndex(i *interpreter, arrv value) (value, error) {
+	arr, err := i.getArrayParam("std.zipWithIndex", 0, arrv)
+	if err != nil {
+		return nil, err
+	}
+	tuples := make([]*cachedThunk, arr.length())
+	for n, element := range arr.elements {
+		tuples[n] = readyThunk(makeValueArray([]*cachedThunk{readyThunk(intToValue(n)), element}))
+	}
+	return makeValueArray(tuples), nil
+}
+
 func builtinFilter(i *interpreter, funcv, arrv value) (value, error) {
-	arr, err := i.getArray(arrv)
+	fun, err := i.getFunctionParam("std.filter", 0, funcv)
 	if err != nil {
 		return nil, err
 	}
-	fun, err := i.getFunction(funcv)
+	arr, err := i.getArrayParam("std.filter", 1, arrv)
 	if err != nil {
 		return nil, err
 	}
@@ -504,6 +773,122 @@ func builtinFilter(i *interpreter, funcv, arrv value) (value, error) {
 	}
 	return makeValueArray(elems), nil
 }
+
+// findIndexBy returns the index of the first element of the array for which the function
+// returns true, or -1. The elements after it are not evaluated.
+func findIndexBy(i *interpreter, builtinName string, funcv, arrv value) (int, *valueArray, error) {
+	fun, err := i.getFunctionParam(builtinName, 0, funcv)
+	if err != nil {
+		return 0, nil, err
+	}
+	arr, err := i.getArrayParam(builtinName, 1, arrv)
+	if err != nil {
+		return 0, nil, err
+	}
+	for index, elem := range arr.elements {
+		foundValue, err := fun.call(i, args(elem))
+		if err != nil {
+			return 0, nil, err
+		}
+		found, err := i.getBoolean(foundValue)
+		if err != nil {
+			return 0, nil, err
+		}
+		if found.value {
+			return index, arr, nil
+		}
+	}
+	return -1, arr, nil
+}
+
+func builtinFindIndex(i *interpreter, funcv, arrv value) (value, error) {
+	index, _, err := findIndexBy(i, "std.findIndex", funcv, arrv)
+	if err != nil {
+		return nil, err
+	}
+	return intToValue(index), nil
+}
+
+// builtinFindValue returns the first element for which the function returns true, or null.
+func builtinFindValue(i *interpreter, funcv, arrv value) (value, error) {
+	index, arr, err := findIndexBy(i, "std.findValue", funcv, arrv)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 {
+		return &nullValue, nil
+	}
+	return arr.elements[index].getValue(i)
+}
+
+// builtinDeepFilter removes the object fields and the array elements for which the function
+// returns false, at any depth. The containers are filtered before the function is called
+// with them, so an object or an array which became empty can still be removed by the
+// function, e.g. std.deepFilter(function(v) v != {}, {a: {b: null}}) removes b first
+// and then a. The hidden fields are dropped, like in std.prune. The value passed to
+// std.deepFilter itself is filtered, but never removed.
+func builtinDeepFilter(i *interpreter, funcv, v value) (value, error) {
+	fun, err := i.getFunctionParam("std.deepFilter", 0, funcv)
+	if err != nil {
+		return nil, err
+	}
+	return deepFilter(i, fun, v)
+}
+
+func deepFilter(i *interpreter, fun *valueFunction, v value) (value, error) {
+	// keep filters the value and returns whether the function keeps it.
+	keep := func(v value) (value, bool, error) {
+		filtered, err := deepFilter(i, fun, v)
+		if err != nil {
+			return nil, false, err
+		}
+		keptValue, err := fun.call(i, args(readyThunk(filtered)))
+		if err != nil {
+			return nil, false, err
+		}
+		kept, err := i.getBoolean(keptValue)
+		if err != nil {
+			return nil, false, err
+		}
+		return filtered, kept.value, nil
+	}
+	switch v := v.(type) {
+	case *valueArray:
+		elems := make([]*cachedThunk, 0, v.length())
+		for _, elem := range v.elements {
+			elemValue, err := elem.getValue(i)
+			if err != nil {
+				return nil, err
+			}
+			filtered, kept, err := keep(elemValue)
+			if err != nil {
+				return nil, err
+			}
+			if kept {
+				elems = append(elems, readyThunk(filtered))
+			}
+		}
+		return makeValueArray(elems), nil
+	case *valueObject:
+		fields := make(simpleObjectFieldMap)
+		for _, fieldName := range objectFields(v, withoutHidden) {
+			fieldValue, err := objectIndex(i, objectBinding(v), fieldName)
+			if err != nil {
+				return nil, err
+			}
+			filtered, kept, err := keep(fieldValue)
+			if err != nil {
+				return nil, err
+			}
+			if kept {
+				fields[fieldName] = simpleObjectField{hide: ast.ObjectFieldInherit, field: &readyValue{filtered}}
+			}
+		}
+		return makeValueSimpleObject(nil, fields, nil, nil), nil
+	}
+	return v, nil
+}
+
 func builtinLstripChars(i *interpreter, str, chars value) (value, error) {
 	switch strType := str.(type) {
 	case valueString:
@@ -568,6 +953,38 @@ func builtinStripChars(i *interpreter, str, chars value) (value, error) {
 	return rstripChars, nil
 }
 
+// builtinDirname returns all but the last element of the path, using the POSIX
+// semantics regardless of the OS, e.g. trailing slashes are ignored.
+func builtinDirname(i *interpreter, pathv value) (value, error) {
+	p, err := i.getStringParam("std.dirname", 0, pathv)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := strings.TrimRight(p.getGoString(), "/")
+	if trimmed == "" && p.length() > 0 {
+		return makeValueString("/"), nil
+	}
+	return makeValueString(path.Dir(trimmed)), nil
+}
+
+// builtinBasename returns the last element of the path, using the POSIX
+// semantics regardless of the OS, e.g. trailing slashes are ignored.
+func builtinBasename(i *interpreter, pathv value) (value, error) {
+	p, err := i.getStringParam("std.basename", 0, pathv)
+	if err != nil {
+		return nil, err
+	}
+	return makeValueString(path.Base(p.getGoString())), nil
+}
+
+func builtinTrim(i *interpreter, strv value) (value, error) {
+	str, err := i.getStringParam("std.trim", 0, strv)
+	if err != nil {
+		return nil, err
+	}
+	return makeValueString(strings.TrimFunc(str.getGoString(), unicode.IsSpace)), nil
+}
+
 func rawMember(i *interpreter, arrv, value value) (bool, error) {
 	switch arrType := arrv.(type) {
 	case valueString:
@@ -610,6 +1027,62 @@ func builtinMember(i *interpreter, arrv, value value) (value, error) {
 	return makeValueBoolean(eq), nil
 }
 
+func rawIndexOf(i *interpreter, builtinName string, arrv, elemv value, fromEnd bool) (int, error) {
+	arr, err := i.getArrayParam(builtinName, 0, arrv)
+	if err != nil {
+		return 0, err
+	}
+	if elemv.getType() == functionType {
+		return 0, i.Error(fmt.Sprintf("%s cannot compare functions", builtinName))
+	}
+	num := arr.length()
+	for counter := 0; counter < num; counter++ {
+		index := counter
+		if fromEnd {
+			index = num - counter - 1
+		}
+		elem, err := arr.elements[index].getValue(i)
+		if err != nil {
+			return 0, err
+		}
+		if elem.getType() == functionType {
+			return 0, i.Error(fmt.Sprintf("%s cannot compare functions, found one at index %d", builtinName, index))
+		}
+		equal, err := rawEquals(i, elem, elemv)
+		if err != nil {
+			return 0, err
+		}
+		if equal {
+			return index, nil
+		}
+	}
+	return -1, nil
+}
+
+func builtinContains(i *interpreter, arrv, elemv value) (value, error) {
+	index, err := rawIndexOf(i, "std.contains", arrv, elemv, false)
+	if err != nil {
+		return nil, err
+	}
+	return makeValueBoolean(index >= 0), nil
+}
+
+func builtinIndexOf(i *interpreter, arrv, elemv value) (value, error) {
+	index, err := rawIndexOf(i, "std.indexOf", arrv, elemv, false)
+	if err != nil {
+		return nil, err
+	}
+	return intToValue(index), nil
+}
+
+func builtinLastIndexOf(i *interpreter, arrv, elemv value) (value, error) {
+	index, err := rawIndexOf(i, "std.lastIndexOf", arrv, elemv, true)
+	if err != nil {
+		return nil, err
+	}
+	return intToValue(index), nil
+}
+
 type sortData struct {
 	err    error
 	i      *interpreter
@@ -651,11 +1124,11 @@ func builtinSort(i *interpreter, arguments []value) (value, error) {
 	arrv := arguments[0]
 	keyFv := arguments[1]
 
-	arr, err := i.getArray(arrv)
+	arr, err := i.getArrayParam("std.sort", 0, arrv)
 	if err != nil {
 		return nil, err
 	}
-	keyF, err := i.getFunction(keyFv)
+	keyF, err := i.getFunctionParam("std.sort", 1, keyFv)
 	if err != nil {
 		return nil, err
 	}
@@ -680,44 +1153,303 @@ func builtinSort(i *interpreter, arguments []value) (value, error) {
 	return makeValueArray(data.thunks), nil
 }
 
-func builtinRange(i *interpreter, fromv, tov value) (value, error) {
-	from, err := i.getInt(fromv)
+// builtinSortObjectByValue returns the [key, value] pairs of the visible fields of the object,
+// sorted by keyF(value) like std.sort. The sort is stable and the fields start in the order
+// of their names, so the fields with equal keys stay ordered by their names.
+func builtinSortObjectByValue(i *interpreter, arguments []value) (value, error) {
+	obj, err := i.getObjectParam("std.sortObjectByValue", 0, arguments[0])
 	if err != nil {
 		return nil, err
 	}
-	to, err := i.getInt(tov)
+	keyF, err := i.getFunctionParam("std.sortObjectByValue", 1, arguments[1])
 	if err != nil {
 		return nil, err
 	}
-	elems := make([]*cachedThunk, to-from+1)
-	for i := from; i <= to; i++ {
-		elems[i-from] = readyThunk(intToValue(i))
+	fieldNames := objectFields(obj, withoutHidden)
+	sort.Strings(fieldNames)
+
+	data := sortData{i: i, thunks: make([]*cachedThunk, len(fieldNames)), keys: make([]value, len(fieldNames))}
+	for counter, fieldName := range fieldNames {
+		fieldValue := objectFieldThunk(obj, fieldName)
+		data.thunks[counter] = readyThunk(makeValueArray([]*cachedThunk{readyThunk(makeValueString(fieldName)), fieldValue}))
+		data.keys[counter], err = keyF.call(i, args(fieldValue))
+		if err != nil {
+			return nil, err
+		}
 	}
-	return makeValueArray(elems), nil
-}
 
-func builtinNegation(i *interpreter, x value) (value, error) {
-	b, err := i.getBoolean(x)
+	err = data.Sort()
 	if err != nil {
 		return nil, err
 	}
-	return makeValueBoolean(!b.value), nil
+
+	return makeValueArray(data.thunks), nil
 }
 
-func builtinXnor(i *interpreter, xv, yv value) (value, error) {
-	p, err := i.getBoolean(xv)
+// onEmptyErrorValue is the type of the default value of the onEmpty parameter of
+// std.minArray and std.maxArray. It is recognized by its type rather than by the pointer,
+// so it cannot be confused with a value passed by the user.
+type onEmptyErrorValue struct {
+	valueNull
+}
+
+var onEmptyError = &onEmptyErrorValue{}
+
+// arrayExtremum returns the element of the array with the smallest key (or the largest one
+// if sign is 1). In case of ties the first such element is returned.
+func arrayExtremum(i *interpreter, builtinName string, arguments []value, sign int) (value, error) {
+	arrv := arguments[0]
+	keyFv := arguments[1]
+	onEmpty := arguments[2]
+
+	arr, err := i.getArrayParam(builtinName, 0, arrv)
 	if err != nil {
 		return nil, err
 	}
-	q, err := i.getBoolean(yv)
+	keyF, err := i.getFunctionParam(builtinName, 1, keyFv)
 	if err != nil {
 		return nil, err
 	}
-	return makeValueBoolean(p.value == q.value), nil
-}
+	num := arr.length()
+	if num == 0 {
+		if _, isDefault := onEmpty.(*onEmptyErrorValue); isDefault {
+			return nil, i.Error(fmt.Sprintf("%s: Expected at least one element in array. Got none", builtinName))
+		}
+		return onEmpty, nil
+	}
 
-func builtinXor(i *interpreter, xv, yv value) (value, error) {
-	p, err := i.getBoolean(xv)
+	best := 0
+	bestKey, err := keyF.call(i, args(arr.elements[0]))
+	if err != nil {
+		return nil, err
+	}
+	for counter := 1; counter < num; counter++ {
+		key, err := keyF.call(i, args(arr.elements[counter]))
+		if err != nil {
+			return nil, err
+		}
+		cmp, err := valueCmp(i, key, bestKey)
+		if err != nil {
+			return nil, err
+		}
+		if cmp == sign {
+			best, bestKey = counter, key
+		}
+	}
+	return arr.elements[best].getValue(i)
+}
+
+func builtinMinArray(i *interpreter, arguments []value) (value, error) {
+	return arrayExtremum(i, "std.minArray", arguments, -1)
+}
+
+func builtinMaxArray(i *interpreter, arguments []value) (value, error) {
+	return arrayExtremum(i, "std.maxArray", arguments, 1)
+}
+
+// rawSum adds up the numbers of the array from left to right, so that the result
+// of floating-point summation is deterministic.
+func rawSum(i *interpreter, builtinName string, arrv value) (float64, int, error) {
+	arr, err := i.getArrayParam(builtinName, 0, arrv)
+	if err != nil {
+		return 0, 0, err
+	}
+	sum := 0.0
+	for counter, elemThunk := range arr.elements {
+		elem, err := elemThunk.getValue(i)
+		if err != nil {
+			return 0, 0, err
+		}
+		num, ok := elem.(*valueNumber)
+		if !ok {
+			return 0, 0, i.Error(fmt.Sprintf("%s: Expected an array of numbers, got %s at index %d", builtinName, elem.getType().name, counter))
+		}
+		sum += num.value
+	}
+	return sum, arr.length(), nil
+}
+
+func builtinSum(i *interpreter, arrv value) (value, error) {
+	sum, _, err := rawSum(i, "std.sum", arrv)
+	if err != nil {
+		return nil, err
+	}
+	return makeDoubleCheck(i, sum)
+}
+
+func builtinAvg(i *interpreter, arrv value) (value, error) {
+	sum, num, err := rawSum(i, "std.avg", arrv)
+	if err != nil {
+		return nil, err
+	}
+	if num == 0 {
+		return nil, i.Error("std.avg: Expected at least one element in array. Got none")
+	}
+	return makeDoubleCheck(i, sum/float64(num))
+}
+
+// rawGetPath follows the path (an array of field names and array indices) starting
+// from root. If any segment is missing, including indexing a scalar, an out-of-range
+// index or a segment of the wrong type, it returns false.
+func rawGetPath(i *interpreter, builtinName string, root value, pathv value) (value, bool, error) {
+	path, err := i.getArrayParam(builtinName, 1, pathv)
+	if err != nil {
+		return nil, false, err
+	}
+	current := root
+	for counter, segmentThunk := range path.elements {
+		segment, err := segmentThunk.getValue(i)
+		if err != nil {
+			return nil, false, err
+		}
+		switch segment := segment.(type) {
+		case valueString:
+			obj, ok := current.(*valueObject)
+			if !ok {
+				return nil, false, nil
+			}
+			field := segment.getGoString()
+			if !objectHasField(objectBinding(obj), field, withHidden) {
+				return nil, false, nil
+			}
+			current, err = obj.index(i, field)
+			if err != nil {
+				return nil, false, err
+			}
+		case *valueNumber:
+			arr, ok := current.(*valueArray)
+			if !ok {
+				return nil, false, nil
+			}
+			index := int(segment.value)
+			if float64(index) != segment.value || index < 0 || index >= arr.length() {
+				return nil, false, nil
+			}
+			current, err = arr.index(i, index)
+			if err != nil {
+				return nil, false, err
+			}
+		default:
+			return nil, false, i.Error(fmt.Sprintf("%s: path segments must be strings or numbers, got %s at index %d", builtinName, segment.getType().name, counter))
+		}
+	}
+	return current, true, nil
+}
+
+func builtinGetPath(i *interpreter, arguments []value) (value, error) {
+	v, found, err := rawGetPath(i, "std.getPath", arguments[0], arguments[1])
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return arguments[2], nil
+	}
+	return v, nil
+}
+
+func builtinObjectHasPath(i *interpreter, objv value, pathv value) (value, error) {
+	_, found, err := rawGetPath(i, "std.objectHasPath", objv, pathv)
+	if err != nil {
+		return nil, err
+	}
+	return makeValueBoolean(found), nil
+}
+
+// builtinRange returns the numbers from `from` to `to` (inclusive), going by `step`.
+// A negative step produces a descending range. The array is built iteratively.
+func builtinRange(i *interpreter, arguments []value) (value, error) {
+	return makeRange(i, "std.range", arguments[0], arguments[1], arguments[2])
+}
+
+// builtinRangeStep is std.range with a required step.
+func builtinRangeStep(i *interpreter, fromv, tov, stepv value) (value, error) {
+	return makeRange(i, "std.rangeStep", fromv, tov, stepv)
+}
+
+func makeRange(i *interpreter, builtinName string, fromv, tov, stepv value) (value, error) {
+	from, err := i.getInt(fromv)
+	if err != nil {
+		return nil, err
+	}
+	to, err := i.getInt(tov)
+	if err != nil {
+		return nil, err
+	}
+	step, err := i.getInt(stepv)
+	if err != nil {
+		return nil, err
+	}
+	if step == 0 {
+		return nil, i.Error(builtinName + ": step must not be 0")
+	}
+	num := 0
+	if step > 0 && from <= to {
+		num = (to-from)/step + 1
+	} else if step < 0 && from >= to {
+		num = (from-to)/(-step) + 1
+	}
+	if err := i.checkArrayLength(builtinName, num); err != nil {
+		return nil, err
+	}
+	elems := make([]*cachedThunk, num)
+	for counter := range elems {
+		elems[counter] = readyThunk(intToValue(from + counter*step))
+	}
+	return makeValueArray(elems), nil
+}
+
+// builtinRepeat concatenates count copies of a string or an array. The size of the result
+// is checked before it is built.
+func builtinRepeat(i *interpreter, what, countv value) (value, error) {
+	count, err := i.getInt(countv)
+	if err != nil {
+		return nil, err
+	}
+	if count < 0 {
+		count = 0
+	}
+	switch what := what.(type) {
+	case valueString:
+		if err := i.checkArrayLength("std.repeat", len(what.getRunes())*count); err != nil {
+			return nil, err
+		}
+		return makeValueString(strings.Repeat(what.getGoString(), count)), nil
+	case *valueArray:
+		if err := i.checkArrayLength("std.repeat", len(what.elements)*count); err != nil {
+			return nil, err
+		}
+		elems := make([]*cachedThunk, 0, len(what.elements)*count)
+		for n := 0; n < count; n++ {
+			elems = append(elems, what.elements...)
+		}
+		return makeValueArray(elems), nil
+	default:
+		return nil, i.Error("std.repeat first argument must be an array or a string")
+	}
+}
+
+func builtinNegation(i *interpreter, x value) (value, error) {
+	b, err := i.getBoolean(x)
+	if err != nil {
+		return nil, err
+	}
+	return makeValueBoolean(!b.value), nil
+}
+
+func builtinXnor(i *interpreter, xv, yv value) (value, error) {
+	p, err := i.getBoolean(xv)
+	if err != nil {
+		return nil, err
+	}
+	q, err := i.getBoolean(yv)
+	if err != nil {
+		return nil, err
+	}
+	return makeValueBoolean(p.value == q.value), nil
+}
+
+func builtinXor(i *interpreter, xv, yv value) (value, error) {
+	p, err := i.getBoolean(xv)
 	if err != nil {
 		return nil, err
 	}
@@ -852,8 +1584,6 @@ func rawEquals(i *interpreter, x, y value) (bool, error) {
 		}
 		leftFields := objectFields(left, withoutHidden)
 		rightFields := objectFields(right, withoutHidden)
-		sort.Strings(leftFields)
-		sort.Strings(rightFields)
 		if len(leftFields) != len(rightFields) {
 			return false, nil
 		}
@@ -907,6 +1637,10 @@ func builtinType(i *interpreter, x value) (value, error) {
 	return makeValueString(x.getType().name), nil
 }
 
+func builtinIsNull(i *interpreter, x value) (value, error) {
+	return makeValueBoolean(x.getType() == nullType), nil
+}
+
 func builtinMd5(i *interpreter, x value) (value, error) {
 	str, err := i.getString(x)
 	if err != nil {
@@ -916,12 +1650,28 @@ func builtinMd5(i *interpreter, x value) (value, error) {
 	return makeValueString(hex.EncodeToString(hash[:])), nil
 }
 
-func builtinBase64(i *interpreter, input value) (value, error) {
+// builtinValueHash returns the hex encoded SHA-256 digest of the value manifested as JSON
+// with sorted keys, so the hash does not depend on the order in which the fields are declared.
+// Like in the output, the hidden fields are not included and functions cannot be hashed.
+func builtinValueHash(i *interpreter, x value) (value, error) {
+	json, err := i.manifestJSON(x)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	serializeJSON(json, false, "", outputStyle{}, &buf)
+	hash := sha256.Sum256(buf.Bytes())
+	return makeValueString(hex.EncodeToString(hash[:])), nil
+}
+
+// encodingInputBytes returns the bytes of the input of an encoding function like std.base64,
+// which is either a string of codepoints up to 255 or an array of bytes.
+func encodingInputBytes(i *interpreter, name string, input value) ([]byte, error) {
 	var byteArr []byte
 
 	var sanityCheck = func(v int) (string, bool) {
 		if v < 0 || 255 < v {
-			msg := fmt.Sprintf("base64 encountered invalid codepoint value in the array (must be 0 <= X <= 255), got %d", v)
+			msg := fmt.Sprintf("%s encountered invalid codepoint value in the array (must be 0 <= X <= 255), got %d", name, v)
 			return msg, false
 		}
 
@@ -959,7 +1709,7 @@ func builtinBase64(i *interpreter, input value) (value, error) {
 
 			vInt, err := i.getInt(cTv)
 			if err != nil {
-				msg := fmt.Sprintf("base64 encountered a non-integer value in the array, got %s", cTv.getType().name)
+				msg := fmt.Sprintf("%s encountered a non-integer value in the array, got %s", name, cTv.getType().name)
 				return nil, makeRuntimeError(msg, i.getCurrentStackTrace())
 			}
 
@@ -971,14 +1721,30 @@ func builtinBase64(i *interpreter, input value) (value, error) {
 			byteArr = append(byteArr, byte(vInt))
 		}
 	default:
-		msg := fmt.Sprintf("base64 can only base64 encode strings / arrays of single bytes, got %s", input.getType().name)
+		msg := fmt.Sprintf("%s can only %s encode strings / arrays of single bytes, got %s", name, name, input.getType().name)
 		return nil, makeRuntimeError(msg, i.getCurrentStackTrace())
 	}
 
+	return byteArr, nil
+}
+
+func builtinBase64(i *interpreter, input value) (value, error) {
+	byteArr, err := encodingInputBytes(i, "base64", input)
+	if err != nil {
+		return nil, err
+	}
 	sEnc := base64.StdEncoding.EncodeToString(byteArr)
 	return makeValueString(sEnc), nil
 }
 
+func builtinBase32(i *interpreter, input value) (value, error) {
+	byteArr, err := encodingInputBytes(i, "base32", input)
+	if err != nil {
+		return nil, err
+	}
+	return makeValueString(base32.StdEncoding.EncodeToString(byteArr)), nil
+}
+
 func builtinEncodeUTF8(i *interpreter, x value) (value, error) {
 	str, err := i.getString(x)
 	if err != nil {
@@ -993,7 +1759,7 @@ func builtinEncodeUTF8(i *interpreter, x value) (value, error) {
 }
 
 func builtinDecodeUTF8(i *interpreter, x value) (value, error) {
-	arr, err := i.getArray(x)
+	arr, err := i.getArrayParam("std.decodeUTF8", 0, x)
 	if err != nil {
 		return nil, err
 	}
@@ -1008,6 +1774,13 @@ func builtinDecodeUTF8(i *interpreter, x value) (value, error) {
 		}
 		bs[pos] = byte(v)
 	}
+	for pos := 0; pos < len(bs); {
+		r, size := utf8.DecodeRune(bs[pos:])
+		if r == utf8.RuneError && size <= 1 {
+			return nil, i.Error(fmt.Sprintf("std.decodeUTF8: Invalid UTF-8 sequence at byte index %d", pos))
+		}
+		pos += size
+	}
 	return makeValueString(string(bs)), nil
 }
 
@@ -1117,42 +1890,353 @@ var builtinBitwiseAnd = liftBitwise(func(x, y int64) int64 { return x & y }, fal
 var builtinBitwiseOr = liftBitwise(func(x, y int64) int64 { return x | y }, false)
 var builtinBitwiseXor = liftBitwise(func(x, y int64) int64 { return x ^ y }, false)
 
+func objectFieldsArray(obj *valueObject, h hidden) value {
+	fields := objectFields(obj, h)
+	elems := []*cachedThunk{}
+	for _, fieldname := range fields {
+		elems = append(elems, readyThunk(makeValueString(fieldname)))
+	}
+	return makeValueArray(elems)
+}
+
 func builtinObjectFieldsEx(i *interpreter, objv, includeHiddenV value) (value, error) {
-	obj, err := i.getObject(objv)
+	obj, err := i.getObjectParam("std.objectFieldsEx", 0, objv)
 	if err != nil {
 		return nil, err
 	}
-	includeHidden, err := i.getBoolean(includeHiddenV)
+	includeHidden, err := i.getBooleanParam("std.objectFieldsEx", 1, includeHiddenV)
 	if err != nil {
 		return nil, err
 	}
-	fields := objectFields(obj, withHiddenFromBool(includeHidden.value))
-	sort.Strings(fields)
-	elems := []*cachedThunk{}
-	for _, fieldname := range fields {
-		elems = append(elems, readyThunk(makeValueString(fieldname)))
+	return objectFieldsArray(obj, withHiddenFromBool(includeHidden.value)), nil
+}
+
+func builtinObjectFields(i *interpreter, objv value) (value, error) {
+	obj, err := i.getObjectParam("std.objectFields", 0, objv)
+	if err != nil {
+		return nil, err
 	}
-	return makeValueArray(elems), nil
+	return objectFieldsArray(obj, withoutHidden), nil
 }
 
-func builtinObjectHasEx(i *interpreter, objv value, fnamev value, includeHiddenV value) (value, error) {
-	obj, err := i.getObject(objv)
+func builtinObjectFieldsAll(i *interpreter, objv value) (value, error) {
+	obj, err := i.getObjectParam("std.objectFieldsAll", 0, objv)
 	if err != nil {
 		return nil, err
 	}
-	fname, err := i.getString(fnamev)
+	return objectFieldsArray(obj, withHidden), nil
+}
+
+// objectFieldThunk returns a thunk of the value of the field, which is evaluated
+// only when needed. It is equivalent to `obj[fieldName]`.
+func objectFieldThunk(obj *valueObject, fieldName string) *cachedThunk {
+	return &cachedThunk{
+		env: &environment{upValues: bindingFrame{"obj": readyThunk(obj)}},
+		body: &ast.Index{
+			Target: &ast.Var{Id: "obj"},
+			Index:  &ast.LiteralString{Value: fieldName},
+		},
+	}
+}
+
+// objectValuesArray returns the values of the object fields as lazy thunks,
+// so that the values which are not used are never evaluated.
+func objectValuesArray(obj *valueObject, h hidden) value {
+	fields := objectFields(obj, h)
+	elems := make([]*cachedThunk, len(fields))
+	for counter, fieldName := range fields {
+		elems[counter] = objectFieldThunk(obj, fieldName)
+	}
+	return makeValueArray(elems)
+}
+
+func builtinObjectValues(i *interpreter, objv value) (value, error) {
+	obj, err := i.getObjectParam("std.objectValues", 0, objv)
 	if err != nil {
 		return nil, err
 	}
-	includeHidden, err := i.getBoolean(includeHiddenV)
+	return objectValuesArray(obj, withoutHidden), nil
+}
+
+func builtinObjectValuesAll(i *interpreter, objv value) (value, error) {
+	obj, err := i.getObjectParam("std.objectValuesAll", 0, objv)
+	if err != nil {
+		return nil, err
+	}
+	return objectValuesArray(obj, withHidden), nil
+}
+
+func objectPairsArray(obj *valueObject, h hidden) value {
+	fields := objectFields(obj, h)
+	elems := make([]*cachedThunk, len(fields))
+	for counter, fieldName := range fields {
+		pair := []*cachedThunk{readyThunk(makeValueString(fieldName)), objectFieldThunk(obj, fieldName)}
+		elems[counter] = readyThunk(makeValueArray(pair))
+	}
+	return makeValueArray(elems)
+}
+
+func builtinObjectPairs(i *interpreter, objv value) (value, error) {
+	obj, err := i.getObjectParam("std.objectPairs", 0, objv)
+	if err != nil {
+		return nil, err
+	}
+	return objectPairsArray(obj, withoutHidden), nil
+}
+
+func builtinObjectPairsAll(i *interpreter, objv value) (value, error) {
+	obj, err := i.getObjectParam("std.objectPairsAll", 0, objv)
+	if err != nil {
+		return nil, err
+	}
+	return objectPairsArray(obj, withHidden), nil
+}
+
+// pairUnboundField is a field of the object returned by std.objectFromPairs.
+// It is equivalent to `pair[1]`.
+type pairUnboundField struct {
+	value *cachedThunk
+}
+
+func (f *pairUnboundField) evaluate(i *interpreter, sb selfBinding, origBindings bindingFrame, fieldName string) (value, error) {
+	return i.evaluatePV(f.value)
+}
+
+func (f *pairUnboundField) loc() *ast.LocationRange {
+	return &ast.LocationRange{}
+}
+
+// builtinObjectFromPairs is the inverse of std.objectPairs, the values are evaluated lazily.
+// A duplicate key is an error, unless lastWins is true.
+func builtinObjectFromPairs(i *interpreter, arguments []value) (value, error) {
+	pairs, err := i.getArrayParam("std.objectFromPairs", 0, arguments[0])
+	if err != nil {
+		return nil, err
+	}
+	lastWins, err := i.getBooleanParam("std.objectFromPairs", 1, arguments[1])
+	if err != nil {
+		return nil, err
+	}
+	fields := make(simpleObjectFieldMap, len(pairs.elements))
+	for index := range pairs.elements {
+		pairv, err := pairs.index(i, index)
+		if err != nil {
+			return nil, err
+		}
+		pair, ok := pairv.(*valueArray)
+		if !ok {
+			return nil, i.Error(fmt.Sprintf("std.objectFromPairs element %d must be a [key, value] array, got %s",
+				index, pairv.getType().name))
+		}
+		if pair.length() != 2 {
+			return nil, i.Error(fmt.Sprintf("std.objectFromPairs element %d must be a [key, value] array, got an array of length %d",
+				index, pair.length()))
+		}
+		keyv, err := pair.index(i, 0)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := keyv.(valueString)
+		if !ok {
+			return nil, i.Error(fmt.Sprintf("std.objectFromPairs key of element %d must be a string, got %s",
+				index, keyv.getType().name))
+		}
+		keyStr := key.getGoString()
+		if _, exists := fields[keyStr]; exists && !lastWins.value {
+			return nil, i.Error(fmt.Sprintf("std.objectFromPairs: duplicate key %s", unparseString(keyStr)))
+		}
+		fields[keyStr] = simpleObjectField{
+			hide:  ast.ObjectFieldInherit,
+			field: &pairUnboundField{value: pair.elements[1]},
+		}
+	}
+	return makeValueSimpleObject(nil, fields, nil, nil), nil
+}
+
+func rawObjectHas(i *interpreter, builtinName string, objv value, fnamev value, h hidden) (value, error) {
+	obj, err := i.getObjectParam(builtinName, 0, objv)
+	if err != nil {
+		return nil, err
+	}
+	fname, err := i.getStringParam(builtinName, 1, fnamev)
 	if err != nil {
 		return nil, err
 	}
-	h := withHiddenFromBool(includeHidden.value)
 	hasField := objectHasField(objectBinding(obj), string(fname.getRunes()), h)
 	return makeValueBoolean(hasField), nil
 }
 
+func builtinObjectHasEx(i *interpreter, objv value, fnamev value, includeHiddenV value) (value, error) {
+	includeHidden, err := i.getBooleanParam("std.objectHasEx", 2, includeHiddenV)
+	if err != nil {
+		return nil, err
+	}
+	return rawObjectHas(i, "std.objectHasEx", objv, fnamev, withHiddenFromBool(includeHidden.value))
+}
+
+func builtinObjectHas(i *interpreter, objv value, fnamev value) (value, error) {
+	return rawObjectHas(i, "std.objectHas", objv, fnamev, withoutHidden)
+}
+
+func builtinObjectHasAll(i *interpreter, objv value, fnamev value) (value, error) {
+	return rawObjectHas(i, "std.objectHasAll", objv, fnamev, withHidden)
+}
+
+func builtinObjectHasNonNull(i *interpreter, objv value, fnamev value) (value, error) {
+	obj, err := i.getObjectParam("std.objectHasNonNull", 0, objv)
+	if err != nil {
+		return nil, err
+	}
+	fname, err := i.getStringParam("std.objectHasNonNull", 1, fnamev)
+	if err != nil {
+		return nil, err
+	}
+	fieldName := string(fname.getRunes())
+	if !objectHasField(objectBinding(obj), fieldName, withoutHidden) {
+		return makeValueBoolean(false), nil
+	}
+	v, err := objectIndex(i, objectBinding(obj), fieldName)
+	if err != nil {
+		return nil, err
+	}
+	_, isNull := v.(*valueNull)
+	return makeValueBoolean(!isNull), nil
+}
+
+// mapWithKeyUnboundField is a field of the object returned by std.mapWithKey or std.mapObject.
+// The function is called only when the field is accessed, and the value
+// of the original field is passed to it lazily.
+// It is equivalent to `func(fieldName, obj[fieldName])`.
+type mapWithKeyUnboundField struct {
+	function *valueFunction
+	obj      *valueObject
+}
+
+func (f *mapWithKeyUnboundField) evaluate(i *interpreter, sb selfBinding, origBindings bindingFrame, fieldName string) (value, error) {
+	key := readyThunk(makeValueString(fieldName))
+	return f.function.call(i, args(key, objectFieldThunk(f.obj, fieldName)))
+}
+
+func (f *mapWithKeyUnboundField) loc() *ast.LocationRange {
+	return &ast.LocationRange{}
+}
+
+func builtinMapWithKey(i *interpreter, funcv, objv value) (value, error) {
+	return mapObjectValues(i, "std.mapWithKey", funcv, objv)
+}
+
+func builtinMapObject(i *interpreter, funcv, objv value) (value, error) {
+	return mapObjectValues(i, "std.mapObject", funcv, objv)
+}
+
+func mapObjectValues(i *interpreter, name string, funcv, objv value) (value, error) {
+	fun, err := i.getFunctionParam(name, 0, funcv)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := i.getObjectParam(name, 1, objv)
+	if err != nil {
+		return nil, err
+	}
+	// The hidden fields stay hidden in the result.
+	fields := make(simpleObjectFieldMap)
+	for fieldName, hide := range objectFieldsVisibility(obj) {
+		fields[fieldName] = simpleObjectField{
+			hide:  hide,
+			field: &mapWithKeyUnboundField{function: fun, obj: obj},
+		}
+	}
+	return makeValueSimpleObject(nil, fields, nil, nil), nil
+}
+
+// renamedUnboundField is a field of the object returned by std.mapObjectKeys
+// or std.objectRemoveKeys. It is equivalent to `obj[originalName]`.
+type renamedUnboundField struct {
+	obj          *valueObject
+	originalName string
+}
+
+func (f *renamedUnboundField) evaluate(i *interpreter, sb selfBinding, origBindings bindingFrame, fieldName string) (value, error) {
+	return objectIndex(i, objectBinding(f.obj), f.originalName)
+}
+
+func (f *renamedUnboundField) loc() *ast.LocationRange {
+	return &ast.LocationRange{}
+}
+
+func builtinMapObjectKeys(i *interpreter, funcv, objv value) (value, error) {
+	fun, err := i.getFunctionParam("std.mapObjectKeys", 0, funcv)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := i.getObjectParam("std.mapObjectKeys", 1, objv)
+	if err != nil {
+		return nil, err
+	}
+	visibility := objectFieldsVisibility(obj)
+	fields := make(simpleObjectFieldMap)
+	originalNames := make(map[string]string)
+	// The fields are renamed in the sorted order, so that the collision errors are deterministic.
+	for _, fieldName := range objectFields(obj, withHidden) {
+		newNameValue, err := fun.call(i, args(readyThunk(makeValueString(fieldName))))
+		if err != nil {
+			return nil, err
+		}
+		newName, ok := newNameValue.(valueString)
+		if !ok {
+			return nil, i.Error(fmt.Sprintf("std.mapObjectKeys function must return a string, got %s for key %s",
+				newNameValue.getType().name, unparseString(fieldName)))
+		}
+		newNameStr := newName.getGoString()
+		if other, exists := originalNames[newNameStr]; exists {
+			return nil, i.Error(fmt.Sprintf("std.mapObjectKeys: keys %s and %s are both mapped to %s",
+				unparseString(other), unparseString(fieldName), unparseString(newNameStr)))
+		}
+		originalNames[newNameStr] = fieldName
+		fields[newNameStr] = simpleObjectField{
+			hide:  visibility[fieldName],
+			field: &renamedUnboundField{obj: obj, originalName: fieldName},
+		}
+	}
+	return makeValueSimpleObject(nil, fields, nil, nil), nil
+}
+
+func builtinObjectRemoveKeys(i *interpreter, objv, keysv value) (value, error) {
+	obj, err := i.getObjectParam("std.objectRemoveKeys", 0, objv)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := i.getArrayParam("std.objectRemoveKeys", 1, keysv)
+	if err != nil {
+		return nil, err
+	}
+	removed := make(map[string]bool, len(keys.elements))
+	for index, elem := range keys.elements {
+		keyValue, err := i.evaluatePV(elem)
+		if err != nil {
+			return nil, err
+		}
+		key, ok := keyValue.(valueString)
+		if !ok {
+			return nil, i.Error(fmt.Sprintf("std.objectRemoveKeys keys must be strings, got %s at index %d",
+				keyValue.getType().name, index))
+		}
+		removed[key.getGoString()] = true
+	}
+	// The keys which are not in the object are ignored, the rest of the fields keep their visibility.
+	fields := make(simpleObjectFieldMap)
+	for fieldName, hide := range objectFieldsVisibility(obj) {
+		if removed[fieldName] {
+			continue
+		}
+		fields[fieldName] = simpleObjectField{
+			hide:  hide,
+			field: &renamedUnboundField{obj: obj, originalName: fieldName},
+		}
+	}
+	return makeValueSimpleObject(nil, fields, nil, nil), nil
+}
+
 func builtinPow(i *interpreter, basev value, expv value) (value, error) {
 	base, err := i.getNumber(basev)
 	if err != nil {
@@ -1280,6 +2364,94 @@ func builtinStrReplace(i *interpreter, strv, fromv, tov value) (value, error) {
 	return makeValueString(strings.Replace(sStr, sFrom, sTo, -1)), nil
 }
 
+func builtinStrReplaceFirst(i *interpreter, strv, fromv, tov value) (value, error) {
+	str, err := i.getStringParam("std.strReplaceFirst", 0, strv)
+	if err != nil {
+		return nil, err
+	}
+	from, err := i.getStringParam("std.strReplaceFirst", 1, fromv)
+	if err != nil {
+		return nil, err
+	}
+	to, err := i.getStringParam("std.strReplaceFirst", 2, tov)
+	if err != nil {
+		return nil, err
+	}
+	sFrom := from.getGoString()
+	if len(sFrom) == 0 {
+		return nil, i.Error("'from' string must not be zero length.")
+	}
+	return makeValueString(strings.Replace(str.getGoString(), sFrom, to.getGoString(), 1)), nil
+}
+
+// builtinIndent prefixes every line of a string, e.g. to embed a manifested value
+// in a template. The empty lines are not prefixed, so that they don't end with whitespace,
+// and neither is the end of a string ending with a newline.
+func builtinIndent(i *interpreter, strv, prefixv value) (value, error) {
+	str, err := i.getStringParam("std.indent", 0, strv)
+	if err != nil {
+		return nil, err
+	}
+	prefix, err := i.getStringParam("std.indent", 1, prefixv)
+	if err != nil {
+		return nil, err
+	}
+	sPrefix := prefix.getGoString()
+	lines := strings.Split(str.getGoString(), "\n")
+	var buf strings.Builder
+	for counter, line := range lines {
+		if counter > 0 {
+			buf.WriteByte('\n')
+		}
+		if line != "" && line != "\r" {
+			buf.WriteString(sPrefix)
+		}
+		buf.WriteString(line)
+	}
+	return makeValueString(buf.String()), nil
+}
+
+// getRegexpParam compiles a pattern in the RE2 syntax accepted by the regexp package.
+func (i *interpreter) getRegexpParam(builtinName string, pos int, v value) (*regexp.Regexp, error) {
+	pattern, err := i.getStringParam(builtinName, pos, v)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(pattern.getGoString())
+	if err != nil {
+		return nil, i.Error(fmt.Sprintf("%s: invalid pattern %s: %v", builtinName, unparseString(pattern.getGoString()), err))
+	}
+	return re, nil
+}
+
+func builtinRegexMatch(i *interpreter, strv, patternv value) (value, error) {
+	str, err := i.getStringParam("std.regexMatch", 0, strv)
+	if err != nil {
+		return nil, err
+	}
+	re, err := i.getRegexpParam("std.regexMatch", 1, patternv)
+	if err != nil {
+		return nil, err
+	}
+	return makeValueBoolean(re.MatchString(str.getGoString())), nil
+}
+
+func builtinRegexReplace(i *interpreter, strv, patternv, replacementv value) (value, error) {
+	str, err := i.getStringParam("std.regexReplace", 0, strv)
+	if err != nil {
+		return nil, err
+	}
+	re, err := i.getRegexpParam("std.regexReplace", 1, patternv)
+	if err != nil {
+		return nil, err
+	}
+	replacement, err := i.getStringParam("std.regexReplace", 2, replacementv)
+	if err != nil {
+		return nil, err
+	}
+	return makeValueString(re.ReplaceAllString(str.getGoString(), replacement.getGoString())), nil
+}
+
 func builtinIsEmpty(i *interpreter, strv value) (value, error) {
 	str, err := i.getString(strv)
 	if err != nil {
@@ -1339,6 +2511,40 @@ func builtinBase64Decode(i *interpreter, input value) (value, error) {
 	return makeValueString(string(decodedBytes)), nil
 }
 
+// base32DecodeGoBytes decodes a base32 string as defined in RFC 4648. The padding
+// may be omitted, as it often is, e.g. in TOTP secrets.
+func base32DecodeGoBytes(i *interpreter, str string) ([]byte, error) {
+	padded := str
+	if !strings.Contains(str, "=") && len(str)%8 != 0 {
+		padded += strings.Repeat("=", 8-len(str)%8)
+	}
+
+	decodedBytes, err := base32.StdEncoding.DecodeString(padded)
+	if err != nil {
+		if pos, ok := err.(base32.CorruptInputError); ok && int(pos) < len(str) && str[pos] != '=' {
+			return nil, i.Error(fmt.Sprintf("base32Decode: invalid character %q at position %d", str[pos], pos))
+		}
+		return nil, i.Error(fmt.Sprintf("base32Decode: invalid padding or length (%d)", len(str)))
+	}
+
+	return decodedBytes, nil
+}
+
+func builtinBase32Decode(i *interpreter, input value) (value, error) {
+	vStr, err := i.getString(input)
+	if err != nil {
+		msg := fmt.Sprintf("base32Decode requires a string, got %s", input.getType().name)
+		return nil, makeRuntimeError(msg, i.getCurrentStackTrace())
+	}
+
+	decodedBytes, err := base32DecodeGoBytes(i, vStr.getGoString())
+	if err != nil {
+		return nil, err
+	}
+
+	return makeValueString(string(decodedBytes)), nil
+}
+
 func builtinUglyObjectFlatMerge(i *interpreter, x value) (value, error) {
 	// TODO(sbarzowski) consider keeping comprehensions in AST
 	// It will probably be way less hacky, with better error messages and better performance
@@ -1390,24 +2596,22 @@ func builtinParseJSON(i *interpreter, str value) (value, error) {
 	if err != nil {
 		return nil, err
 	}
-	s := sval.getGoString()
-	var parsedJSON interface{}
-	err = json.Unmarshal([]byte(s), &parsedJSON)
+	parsed, err := parseJSON(sval.getGoString())
 	if err != nil {
 		return nil, i.Error(fmt.Sprintf("failed to parse JSON: %v", err.Error()))
 	}
-	return jsonToValue(i, parsedJSON)
+	return parsed, nil
 }
 
-func builtinParseYAML(i *interpreter, str value) (value, error) {
+// parseYAMLDocuments parses all documents of a YAML stream. The empty documents
+// are skipped, e.g. a trailing `---` does not add a document.
+func parseYAMLDocuments(i *interpreter, str value) ([]interface{}, error) {
 	sval, err := i.getString(str)
 	if err != nil {
 		return nil, err
 	}
 	s := sval.getGoString()
 
-	isYamlStream := strings.Contains(s, "---")
-
 	elems := []interface{}{}
 	d := NewYAMLToJSONDecoder(strings.NewReader(s))
 	for {
@@ -1420,11 +2624,174 @@ func builtinParseYAML(i *interpreter, str value) (value, error) {
 		}
 		elems = append(elems, elem)
 	}
+	return elems, nil
+}
 
-	if isYamlStream {
+// builtinParseYAML returns the value of the only document, or an array of the values
+// if there are multiple documents. So the result of a single document which is an array
+// cannot be distinguished from a stream by the result alone, std.parseYamlStream
+// should be used when the input may be a stream.
+// An input without any documents results in null.
+func builtinParseYAML(i *interpreter, str value) (value, error) {
+	elems, err := parseYAMLDocuments(i, str)
+	if err != nil {
+		return nil, err
+	}
+	switch len(elems) {
+	case 0:
+		return &nullValue, nil
+	case 1:
+		return jsonToValue(i, elems[0])
+	default:
 		return jsonToValue(i, elems)
 	}
-	return jsonToValue(i, elems[0])
+}
+
+// builtinParseYAMLStream returns an array of the values of all documents,
+// even if there is just one.
+func builtinParseYAMLStream(i *interpreter, str value) (value, error) {
+	elems, err := parseYAMLDocuments(i, str)
+	if err != nil {
+		return nil, err
+	}
+	return jsonToValue(i, elems)
+}
+
+// builtinParseCsv parses a CSV document as defined in RFC 4180. With a header, the rows
+// are returned as objects with the fields named by the header, otherwise as arrays.
+// All the values are strings and all the rows must have the same number of fields.
+func builtinParseCsv(i *interpreter, arguments []value) (value, error) {
+	str, err := i.getStringParam("std.parseCsv", 0, arguments[0])
+	if err != nil {
+		return nil, err
+	}
+	hasHeader, err := i.getBooleanParam("std.parseCsv", 1, arguments[1])
+	if err != nil {
+		return nil, err
+	}
+	records, err := csv.NewReader(strings.NewReader(str.getGoString())).ReadAll()
+	if err != nil {
+		return nil, i.Error(fmt.Sprintf("std.parseCsv: %v", err))
+	}
+	var header []string
+	if hasHeader.value && len(records) > 0 {
+		header, records = records[0], records[1:]
+		seen := make(map[string]bool, len(header))
+		for _, name := range header {
+			if seen[name] {
+				return nil, i.Error(fmt.Sprintf("std.parseCsv: duplicate column %q in the header", name))
+			}
+			seen[name] = true
+		}
+	}
+	rows := make([]*cachedThunk, len(records))
+	for n, record := range records {
+		if header != nil {
+			fields := make(simpleObjectFieldMap, len(record))
+			for col, field := range record {
+				fields[header[col]] = simpleObjectField{hide: ast.ObjectFieldInherit, field: &readyValue{makeValueString(field)}}
+			}
+			rows[n] = readyThunk(makeValueSimpleObject(nil, fields, nil, nil))
+		} else {
+			elems := make([]*cachedThunk, len(record))
+			for col, field := range record {
+				elems[col] = readyThunk(makeValueString(field))
+			}
+			rows[n] = readyThunk(makeValueArray(elems))
+		}
+	}
+	return makeValueArray(rows), nil
+}
+
+// builtinManifestCsv produces a CSV document with a header of the given columns
+// and a line for each row, in the same order. The rows are either objects, whose
+// fields are taken in the order of the columns, or arrays of the values in that order.
+// A missing field or a null is an empty value, the other values are converted with
+// std.toString, except for arrays and objects, which are not allowed. The values are
+// quoted as defined in RFC 4180, but the lines end with "\n".
+func builtinManifestCsv(i *interpreter, arrv, columnsv value) (value, error) {
+	arr, err := i.getArrayParam("std.manifestCsv", 0, arrv)
+	if err != nil {
+		return nil, err
+	}
+	columnsArr, err := i.getArrayParam("std.manifestCsv", 1, columnsv)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, len(columnsArr.elements))
+	for n, th := range columnsArr.elements {
+		v, err := i.evaluatePV(th)
+		if err != nil {
+			return nil, err
+		}
+		column, err := i.getString(v)
+		if err != nil {
+			return nil, err
+		}
+		columns[n] = column.getGoString()
+	}
+
+	csvValue := func(v value) (string, error) {
+		switch v := v.(type) {
+		case *valueNull:
+			return "", nil
+		case *valueArray, *valueObject:
+			return "", i.Error(fmt.Sprintf("std.manifestCsv: a value must not be %s", v.getType().name))
+		}
+		str, err := builtinToString(i, v)
+		if err != nil {
+			return "", err
+		}
+		return str.(valueString).getGoString(), nil
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.Write(columns); err != nil {
+		return nil, i.Error(fmt.Sprintf("std.manifestCsv: %v", err))
+	}
+	for n, th := range arr.elements {
+		row, err := i.evaluatePV(th)
+		if err != nil {
+			return nil, err
+		}
+		record := make([]string, len(columns))
+		switch row := row.(type) {
+		case *valueObject:
+			for col, name := range columns {
+				if !objectHasField(objectBinding(row), name, withoutHidden) {
+					continue
+				}
+				v, err := row.index(i, name)
+				if err != nil {
+					return nil, err
+				}
+				if record[col], err = csvValue(v); err != nil {
+					return nil, err
+				}
+			}
+		case *valueArray:
+			if row.length() != len(columns) {
+				return nil, i.Error(fmt.Sprintf("std.manifestCsv: row %d has %d values, but there are %d columns", n, row.length(), len(columns)))
+			}
+			for col, elem := range row.elements {
+				v, err := i.evaluatePV(elem)
+				if err != nil {
+					return nil, err
+				}
+				if record[col], err = csvValue(v); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, i.Error(fmt.Sprintf("std.manifestCsv: row %d must be an object or an array, got %s", n, row.getType().name))
+		}
+		if err := w.Write(record); err != nil {
+			return nil, i.Error(fmt.Sprintf("std.manifestCsv: %v", err))
+		}
+	}
+	w.Flush()
+	return makeValueString(buf.String()), nil
 }
 
 func jsonEncode(v interface{}) (string, error) {
@@ -1599,7 +2966,6 @@ func tomlRenderValue(i *interpreter, val value, sindent string, indexedPath []st
 		res := ""
 
 		fields := objectFields(v, withoutHidden)
-		sort.Strings(fields)
 
 		// iterate over sorted field keys and render their values
 		for j, fieldName := range fields {
@@ -1703,7 +3069,6 @@ func tomlTableInternal(i *interpreter, v *valueObject, sindent string, path []st
 	resFields := []string{}
 	resSections := []string{""}
 	fields := objectFields(v, withoutHidden)
-	sort.Strings(fields)
 
 	// iterate over non-section items
 	for _, fieldName := range fields {
@@ -1781,6 +3146,323 @@ func builtinManifestTomlEx(i *interpreter, arguments []value) (value, error) {
 	}
 }
 
+// builtinManifestYamlDoc is std.manifestYamlDoc, it writes the YAML by hand like the stdlib
+// implementation it replaces, so that the output stays the same.
+func builtinManifestYamlDoc(i *interpreter, arguments []value) (value, error) {
+	indentArrayInObject, err := i.getBooleanParam("std.manifestYamlDoc", 1, arguments[1])
+	if err != nil {
+		return nil, err
+	}
+	quoteKeys, err := i.getBooleanParam("std.manifestYamlDoc", 2, arguments[2])
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := manifestYamlDoc(i, arguments[0], nil, "", indentArrayInObject.value, quoteKeys.value, &buf); err != nil {
+		return nil, err
+	}
+	return makeValueString(buf.String()), nil
+}
+
+// manifestYamlDoc writes the YAML representation of the value, indented by cindent
+// after every newline. The strings are always quoted, so that they are not read back
+// as other types, e.g. "yes" as a boolean. The keys are quoted with quoteKeys,
+// otherwise only the ones which are not yamlBareSafe.
+func manifestYamlDoc(i *interpreter, v value, path []interface{}, cindent string, indentArrayInObject, quoteKeys bool, buf *bytes.Buffer) error {
+	switch v := v.(type) {
+	case *valueNull:
+		buf.WriteString("null")
+	case *valueBoolean:
+		if v.value {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+	case *valueNumber:
+		buf.WriteString(unparseNumber(v.value))
+	case valueString:
+		str := v.getGoString()
+		if str == "" {
+			buf.WriteString(`""`)
+		} else if strings.HasSuffix(str, "\n") {
+			buf.WriteString("|")
+			for _, line := range strings.Split(str[:len(str)-1], "\n") {
+				buf.WriteString("\n" + cindent + "  ")
+				buf.WriteString(line)
+			}
+		} else {
+			buf.WriteString(unparseString(str))
+		}
+	case *valueFunction:
+		return i.Error(fmt.Sprintf("Tried to manifest function at %s", yamlPathString(path)))
+	case *valueArray:
+		if err := i.checkManifestDepth(len(path) + 1); err != nil {
+			return err
+		}
+		if v.length() == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		for index, elem := range v.elements {
+			elemValue, err := elem.getValue(i)
+			if err != nil {
+				return err
+			}
+			if index > 0 {
+				buf.WriteString("\n" + cindent)
+			}
+			buf.WriteString("-")
+			newIndent := cindent
+			switch {
+			case yamlNonEmptyArray(elemValue):
+				// While we could avoid the new line, it yields YAML that is hard to read.
+				newIndent = cindent + "  "
+				buf.WriteString("\n" + newIndent)
+			case yamlNonEmptyObject(elemValue):
+				// The fields can start on the same line as the -, because the indentation matches up.
+				newIndent = cindent + "  "
+				buf.WriteString(" ")
+			default:
+				// The new indentation is only used by the multi-line strings.
+				buf.WriteString(" ")
+			}
+			if err := manifestYamlDoc(i, elemValue, append(path, float64(index)), newIndent, indentArrayInObject, quoteKeys, buf); err != nil {
+				return err
+			}
+		}
+	case *valueObject:
+		if err := i.checkManifestDepth(len(path) + 1); err != nil {
+			return err
+		}
+		fieldNames := objectFields(v, withoutHidden)
+		if len(fieldNames) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		sort.Strings(fieldNames)
+		for index, fieldName := range fieldNames {
+			fieldValue, err := v.index(i, fieldName)
+			if err != nil {
+				return err
+			}
+			if index > 0 {
+				buf.WriteString("\n" + cindent)
+			}
+			if quoteKeys || !yamlBareSafe(fieldName) {
+				buf.WriteString(unparseString(fieldName))
+			} else {
+				buf.WriteString(fieldName)
+			}
+			buf.WriteString(":")
+			newIndent := cindent
+			switch {
+			case yamlNonEmptyArray(fieldValue):
+				// Not indenting allows e.g. "ports:\n- 80" instead of "ports:\n  - 80".
+				if indentArrayInObject {
+					newIndent = cindent + "  "
+				}
+				buf.WriteString("\n" + newIndent)
+			case yamlNonEmptyObject(fieldValue):
+				newIndent = cindent + "  "
+				buf.WriteString("\n" + newIndent)
+			default:
+				// The new indentation is only used by the multi-line strings.
+				buf.WriteString(" ")
+			}
+			if err := manifestYamlDoc(i, fieldValue, append(path, fieldName), newIndent, indentArrayInObject, quoteKeys, buf); err != nil {
+				return err
+			}
+		}
+	default:
+		return i.Error(fmt.Sprintf("Tried to manifest %s at %s", v.getType().name, yamlPathString(path)))
+	}
+	return nil
+}
+
+// yamlPathString formats the path of the field names and the array indices
+// as a Jsonnet array, like std.toString does.
+func yamlPathString(path []interface{}) string {
+	var buf bytes.Buffer
+	serializeJSON(append([]interface{}{}, path...), false, "", outputStyle{}, &buf)
+	return buf.String()
+}
+
+func yamlNonEmptyArray(v value) bool {
+	arr, ok := v.(*valueArray)
+	return ok && arr.length() > 0
+}
+
+func yamlNonEmptyObject(v value) bool {
+	obj, ok := v.(*valueObject)
+	return ok && len(objectFields(obj, withoutHidden)) > 0
+}
+
+// yamlReservedWords are the keys which would be read as other types than strings,
+// compared case insensitively. Some of them are not ambiguous in every case,
+// e.g. "Yes" is only a boolean in YAML 1.1, but it's safer to quote them all.
+var yamlReservedWords = map[string]bool{
+	// Booleans, https://yaml.org/type/bool.html
+	"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true, "y": true, "n": true,
+	// Numerical words, https://yaml.org/type/float.html
+	".nan": true, "-.inf": true, "+.inf": true, ".inf": true,
+	// Null, https://yaml.org/type/null.html
+	"null": true,
+	// Invalid keys that contain no invalid characters
+	"-": true, "---": true, "": true,
+}
+
+// yamlOnlyChars returns whether all the characters of s are in chars.
+func yamlOnlyChars(s, chars string) bool {
+	return strings.Trim(s, chars) == ""
+}
+
+// yamlTypeMatch returns whether s starts with the prefix of a number type, optionally negative.
+func yamlTypeMatch(s, prefix string) bool {
+	return strings.HasPrefix(s, prefix) || strings.HasPrefix(s, "-"+prefix)
+}
+
+// yamlBareSafe returns whether the key can be written without quotes. It can when it only
+// consists of the characters [a-zA-Z0-9_/.-] and it doesn't match any of the integer,
+// float, timestamp, boolean or null formats of YAML 1.1 (https://yaml.org/type/)
+// and of the YAML 1.2 core schema, or the reserved words. The checks are conservative,
+// some of the keys are quoted even when it's not necessary. The other YAML types require
+// characters outside of the safe set, so they are always quoted.
+func yamlBareSafe(key string) bool {
+	const (
+		digits     = "0123456789"
+		intChars   = digits + "_-"
+		binChars   = intChars + "b"
+		octChars   = intChars + "o"
+		hexChars   = digits + "abcdefx_-"
+		floatChars = digits + "e._-"
+		dateChars  = digits + "-"
+		safeChars  = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz/" + floatChars
+	)
+	keyLc := strings.ToLower(key)
+	dashes := strings.Count(key, "-")
+	switch {
+	case !yamlOnlyChars(key, safeChars):
+		return false
+	case yamlReservedWords[keyLc]:
+		return false
+	// Timestamps, e.g. 2001-12-14. Spaces and colons are already forbidden.
+	case yamlOnlyChars(key, dateChars) && dashes == 2:
+		return false
+	// Integers, e.g. -1_000.
+	case yamlOnlyChars(keyLc, intChars) && dashes < 2:
+		return false
+	// Binary integers, e.g. 0b101.
+	case yamlOnlyChars(keyLc, binChars) && len(key) > 2 && yamlTypeMatch(keyLc, "0b"):
+		return false
+	// Octal integers of YAML 1.2, e.g. 0o17.
+	case yamlOnlyChars(keyLc, octChars) && len(key) > 2 && yamlTypeMatch(keyLc, "0o"):
+		return false
+	// Floats, e.g. 1.5e3, and the floats without the period of YAML 1.2, e.g. 1e3.
+	case yamlOnlyChars(keyLc, floatChars) && strings.ContainsAny(key, digits) && dashes < 3 &&
+		(strings.Count(key, ".") == 1 && strings.Count(keyLc, "e") < 2 ||
+			strings.Count(key, ".") == 0 && strings.Count(keyLc, "e") == 1):
+		return false
+	// Hexadecimals, e.g. 0x1F.
+	case yamlOnlyChars(keyLc, hexChars) && dashes < 2 && len(key) > 2 && yamlTypeMatch(keyLc, "0x"):
+		return false
+	}
+	return true
+}
+
+// builtinManifestXmlJsonmlEx is std.manifestXmlJsonml with the elements on separate lines,
+// indented by indent for every level of nesting. The elements with any text content
+// are written inline, as it is in std.manifestXmlJsonml, because the added whitespace
+// would change the text.
+func builtinManifestXmlJsonmlEx(i *interpreter, value, indentv value) (value, error) {
+	indent, err := i.getStringParam("std.manifestXmlJsonmlEx", 1, indentv)
+	if err != nil {
+		return nil, err
+	}
+	if value.getType() != arrayType {
+		return nil, i.Error(fmt.Sprintf("Expected a JSONML value (an array), got %s", value.getType().name))
+	}
+	var buf bytes.Buffer
+	if err := manifestXmlJsonml(i, value, true, indent.getGoString(), "", &buf); err != nil {
+		return nil, err
+	}
+	return makeValueString(buf.String()), nil
+}
+
+// manifestXmlJsonml writes a JSONML value, a string or an element, to buf. The children
+// of the element are written on separate lines indented by cindent + indent if pretty is true
+// and the element has no text content, otherwise the element is written on a single line.
+func manifestXmlJsonml(i *interpreter, v value, pretty bool, indent string, cindent string, buf *bytes.Buffer) error {
+	switch v := v.(type) {
+	case valueString:
+		buf.WriteString(v.getGoString())
+		return nil
+	case *valueArray:
+		if v.length() == 0 {
+			return i.Error("Expected a JSONML element, got an empty array")
+		}
+		elements := make([]value, v.length())
+		for index, elem := range v.elements {
+			elemValue, err := elem.getValue(i)
+			if err != nil {
+				return err
+			}
+			elements[index] = elemValue
+		}
+		tag, ok := elements[0].(valueString)
+		if !ok {
+			return i.Error(fmt.Sprintf("Expected the tag of a JSONML element to be a string, got %s", elements[0].getType().name))
+		}
+		children := elements[1:]
+		buf.WriteString("<")
+		buf.WriteString(tag.getGoString())
+		if len(children) > 0 {
+			if attrs, ok := children[0].(*valueObject); ok {
+				children = children[1:]
+				fieldNames := objectFields(attrs, withoutHidden)
+				sort.Strings(fieldNames)
+				for _, fieldName := range fieldNames {
+					attrValue, err := attrs.index(i, fieldName)
+					if err != nil {
+						return err
+					}
+					attrString, err := builtinToString(i, attrValue)
+					if err != nil {
+						return err
+					}
+					buf.WriteString(fmt.Sprintf(" %s=\"%s\"", fieldName, attrString.(valueString).getGoString()))
+				}
+			}
+		}
+		buf.WriteString(">")
+		// The text content would change with the added whitespace.
+		for _, child := range children {
+			if child.getType() == stringType {
+				pretty = false
+			}
+		}
+		childIndent := cindent + indent
+		for _, child := range children {
+			if pretty {
+				buf.WriteString("\n")
+				buf.WriteString(childIndent)
+			}
+			if err := manifestXmlJsonml(i, child, pretty, indent, childIndent, buf); err != nil {
+				return err
+			}
+		}
+		if pretty && len(children) > 0 {
+			buf.WriteString("\n")
+			buf.WriteString(cindent)
+		}
+		buf.WriteString("</")
+		buf.WriteString(tag.getGoString())
+		buf.WriteString(">")
+		return nil
+	default:
+		return i.Error(fmt.Sprintf("Expected a JSONML value (an array or a string), got %s", v.getType().name))
+	}
+}
+
 // We have a very similar logic here /interpreter.go@v0.16.0#L695 and here: /interpreter.go@v0.16.0#L627
 // These should ideally be unified
 // For backwards compatibility reasons, we are manually marshalling to json so we can control formatting
@@ -1803,10 +3485,20 @@ func builtinManifestJSONEx(i *interpreter, arguments []value) (value, error) {
 		return nil, err
 	}
 
-	sindent := vindent.getGoString()
-	newline := vnewline.getGoString()
-	kvSep := vkvSep.getGoString()
+	return manifestJSONEx(i, val, vindent.getGoString(), vnewline.getGoString(), vkvSep.getGoString(), true)
+}
+
+func builtinManifestJSON(i *interpreter, arguments []value) (value, error) {
+	sortKeys, err := i.getBooleanParam("std.manifestJson", 1, arguments[1])
+	if err != nil {
+		return nil, err
+	}
+	return manifestJSONEx(i, arguments[0], "    ", "\n", ": ", sortKeys.value)
+}
 
+// manifestJSONEx implements std.manifestJsonEx. Without sortKeys, the object fields
+// are in the order of their declaration.
+func manifestJSONEx(i *interpreter, val value, sindent, newline, kvSep string, sortKeys bool) (value, error) {
 	var path []string
 
 	var aux func(ov value, path []string, cindent string) (string, error)
@@ -1832,6 +3524,9 @@ func builtinManifestJSONEx(i *interpreter, arguments []value) (value, error) {
 		case *valueFunction:
 			return "", i.Error(fmt.Sprintf("tried to manifest function at %s", path))
 		case *valueArray:
+			if err := i.checkManifestDepth(len(path) + 1); err != nil {
+				return "", err
+			}
 			newIndent := cindent + sindent
 			lines := []string{"[" + newline}
 
@@ -1853,11 +3548,16 @@ func builtinManifestJSONEx(i *interpreter, arguments []value) (value, error) {
 			lines = append(lines, newline+cindent+"]")
 			return strings.Join(lines, ""), nil
 		case *valueObject:
+			if err := i.checkManifestDepth(len(path) + 1); err != nil {
+				return "", err
+			}
 			newIndent := cindent + sindent
 			lines := []string{"{" + newline}
 
 			fields := objectFields(v, withoutHidden)
-			sort.Strings(fields)
+			if !sortKeys {
+				fields = objectFieldsInDeclarationOrder(v, withoutHidden)
+			}
 			var objectLines []string
 			for _, fieldName := range fields {
 				fieldValue, err := v.index(i, fieldName)
@@ -1904,6 +3604,9 @@ func builtinExtVar(i *interpreter, name value) (value, error) {
 	if pv, ok := i.extVars[index]; ok {
 		return i.evaluatePV(pv)
 	}
+	if i.missingExtVarPolicy == MissingExtVarNull {
+		return &nullValue, nil
+	}
 	return nil, i.Error("Undefined external variable: " + string(index))
 }
 
@@ -1916,25 +3619,50 @@ func builtinNative(i *interpreter, name value) (value, error) {
 	if f, exists := i.nativeFuncs[index]; exists {
 		return &valueFunction{ec: f}, nil
 	}
+	if f, resolved := i.resolvedNatives[index]; resolved {
+		return &valueFunction{ec: f}, nil
+	}
+	if i.nativeResolver != nil {
+		if f, found := i.nativeResolver(index); found && f != nil {
+			// The function is only kept by the interpreter, the VM's functions stay the same.
+			if i.resolvedNatives == nil {
+				i.resolvedNatives = make(map[string]*NativeFunction)
+			}
+			i.resolvedNatives[index] = f
+			return &valueFunction{ec: f}, nil
+		}
+	}
 	return &valueNull{}, nil
 }
 
-func builtinSum(i *interpreter, arrv value) (value, error) {
-	arr, err := i.getArray(arrv)
+// builtinAssertEqualCollect replaces std.assertEqual when the failures are collected
+// into a report instead of stopping the evaluation.
+func builtinAssertEqualCollect(i *interpreter, a, b value) (value, error) {
+	equal, err := rawEquals(i, a, b)
 	if err != nil {
 		return nil, err
 	}
-	sum := 0.0
-	for _, elem := range arr.elements {
-		elemValue, err := i.evaluateNumber(elem)
-		if err != nil {
-			return nil, err
-		}
-		sum += elemValue.value
+	if equal {
+		return makeValueBoolean(true), nil
+	}
+	actual, err := i.manifestJSON(a)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := i.manifestJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	failure := AssertEqualFailure{Actual: actual, Expected: expected}
+	if trace := i.getCurrentStackTrace(); len(trace) > 0 {
+		failure.Loc = trace[len(trace)-1].Loc
 	}
-	return makeValueNumber(sum), nil
+	i.assertEqualFailures = append(i.assertEqualFailures, failure)
+	return makeValueBoolean(true), nil
 }
 
+var assertEqualCollect = &binaryBuiltin{name: "assertEqual", function: builtinAssertEqualCollect, params: ast.Identifiers{"a", "b"}}
+
 // Utils for builtins - TODO(sbarzowski) move to a separate file in another commit
 
 type builtin interface {
@@ -2155,6 +3883,46 @@ var bopBuiltins = []*binaryBuiltin{
 	ast.BopBitwiseOr:  &binaryBuiltin{name: "operator|", function: builtinBitwiseOr, params: ast.Identifiers{"x", "y"}},
 }
 
+// bopVerbs describe what the binary operators do, for the error messages.
+var bopVerbs = []string{
+	ast.BopMult:       "multiply",
+	ast.BopDiv:        "divide",
+	ast.BopPlus:       "add",
+	ast.BopMinus:      "subtract",
+	ast.BopShiftL:     "shift",
+	ast.BopShiftR:     "shift",
+	ast.BopGreater:    "compare",
+	ast.BopGreaterEq:  "compare",
+	ast.BopLess:       "compare",
+	ast.BopLessEq:     "compare",
+	ast.BopBitwiseAnd: "bitwise and",
+	ast.BopBitwiseXor: "bitwise xor",
+	ast.BopBitwiseOr:  "bitwise or",
+}
+
+// checkBinaryOperands returns an error naming both operand types and the operator
+// if the operator cannot be applied to values of these types.
+func checkBinaryOperands(i *interpreter, op ast.BinaryOp, x, y value) error {
+	xType, yType := x.getType(), y.getType()
+	var ok bool
+	switch op {
+	case ast.BopPlus:
+		ok = xType == stringType || yType == stringType ||
+			(xType == yType && (xType == numberType || xType == objectType || xType == arrayType))
+	case ast.BopMult, ast.BopDiv, ast.BopMinus, ast.BopShiftL, ast.BopShiftR,
+		ast.BopBitwiseAnd, ast.BopBitwiseXor, ast.BopBitwiseOr:
+		ok = xType == numberType && yType == numberType
+	case ast.BopGreater, ast.BopGreaterEq, ast.BopLess, ast.BopLessEq:
+		ok = xType == yType && (xType == numberType || xType == stringType || xType == arrayType)
+	default:
+		ok = true
+	}
+	if ok {
+		return nil
+	}
+	return i.Error(fmt.Sprintf("cannot %s %s and %s with operator %s", bopVerbs[op], xType.name, yType.name, op))
+}
+
 var uopBuiltins = []*unaryBuiltin{
 	ast.UopNot:        &unaryBuiltin{name: "operator!", function: builtinNegation, params: ast.Identifiers{"x"}},
 	ast.UopBitwiseNot: &unaryBuiltin{name: "operator~", function: builtinBitNeg, params: ast.Identifiers{"x"}},
@@ -2182,26 +3950,104 @@ func builtinParseInt(i *interpreter, x value) (value, error) {
 	return makeValueNumber(float64(res)), nil
 }
 
+// getBigIntParam accepts a big integer encoded as a base 10 string, or a whole number.
+func (i *interpreter) getBigIntParam(builtinName string, position int, val value) (*big.Int, error) {
+	switch val := val.(type) {
+	case valueString:
+		n, ok := new(big.Int).SetString(val.getGoString(), 10)
+		if !ok {
+			return nil, i.Error(fmt.Sprintf("%s %s param must be a base 10 integer, got %q", builtinName, paramOrdinals[position], val.getGoString()))
+		}
+		return n, nil
+	case *valueNumber:
+		if val.value != math.Trunc(val.value) {
+			return nil, i.Error(fmt.Sprintf("%s %s param must be a whole number, got %v", builtinName, paramOrdinals[position], val.value))
+		}
+		n, _ := big.NewFloat(val.value).Int(nil)
+		return n, nil
+	}
+	return nil, i.paramTypeError(builtinName, position, val, "string or number")
+}
+
+func builtinParseBigInt(i *interpreter, x value) (value, error) {
+	n, err := i.getBigIntParam("std.parseBigInt", 0, x)
+	if err != nil {
+		return nil, err
+	}
+	return makeValueString(n.String()), nil
+}
+
+// bigIntBuiltin makes a builtin function computing with two big integers, which returns
+// the result as a base 10 string.
+func bigIntBuiltin(name string, op func(z, x, y *big.Int) *big.Int) func(*interpreter, value, value) (value, error) {
+	builtinName := "std." + name
+	return func(i *interpreter, xv, yv value) (value, error) {
+		x, err := i.getBigIntParam(builtinName, 0, xv)
+		if err != nil {
+			return nil, err
+		}
+		y, err := i.getBigIntParam(builtinName, 1, yv)
+		if err != nil {
+			return nil, err
+		}
+		return makeValueString(op(new(big.Int), x, y).String()), nil
+	}
+}
+
 var funcBuiltins = buildBuiltinMap([]builtin{
 	builtinID,
 	&unaryBuiltin{name: "extVar", function: builtinExtVar, params: ast.Identifiers{"x"}},
 	&unaryBuiltin{name: "length", function: builtinLength, params: ast.Identifiers{"x"}},
 	&unaryBuiltin{name: "toString", function: builtinToString, params: ast.Identifiers{"a"}},
+	&unaryBuiltin{name: "escapeStringXml", function: builtinEscapeStringXml, params: ast.Identifiers{"str"}},
+	&unaryBuiltin{name: "escapeStringUrl", function: builtinEscapeStringUrl, params: ast.Identifiers{"str"}},
 	&binaryBuiltin{name: "trace", function: builtinTrace, params: ast.Identifiers{"str", "rest"}},
 	&binaryBuiltin{name: "makeArray", function: builtinMakeArray, params: ast.Identifiers{"sz", "func"}},
 	&binaryBuiltin{name: "flatMap", function: builtinFlatMap, params: ast.Identifiers{"func", "arr"}},
 	&binaryBuiltin{name: "join", function: builtinJoin, params: ast.Identifiers{"sep", "arr"}},
+	&unaryBuiltin{name: "lines", function: builtinLines, params: ast.Identifiers{"arr"}},
+	&unaryBuiltin{name: "unlines", function: builtinUnlines, params: ast.Identifiers{"arr"}},
+	&unaryBuiltin{name: "words", function: builtinWords, params: ast.Identifiers{"str"}},
 	&unaryBuiltin{name: "reverse", function: builtinReverse, params: ast.Identifiers{"arr"}},
+	&unaryBuiltin{name: "zip", function: builtinZip, params: ast.Identifiers{"arrs"}},
+	&unaryBuiltin{name: "zipWithIndex", function: builtinZipWithIndex, params: ast.Identifiers{"arr"}},
 	&binaryBuiltin{name: "filter", function: builtinFilter, params: ast.Identifiers{"func", "arr"}},
+	&binaryBuiltin{name: "findIndex", function: builtinFindIndex, params: ast.Identifiers{"func", "arr"}},
+	&binaryBuiltin{name: "findValue", function: builtinFindValue, params: ast.Identifiers{"func", "arr"}},
+	&binaryBuiltin{name: "deepFilter", function: builtinDeepFilter, params: ast.Identifiers{"func", "value"}},
 	&ternaryBuiltin{name: "foldl", function: builtinFoldl, params: ast.Identifiers{"func", "arr", "init"}},
 	&ternaryBuiltin{name: "foldr", function: builtinFoldr, params: ast.Identifiers{"func", "arr", "init"}},
+	&ternaryBuiltin{name: "foldlObject", function: builtinFoldlObject, params: ast.Identifiers{"func", "obj", "init"}},
 	&binaryBuiltin{name: "member", function: builtinMember, params: ast.Identifiers{"arr", "x"}},
-	&binaryBuiltin{name: "range", function: builtinRange, params: ast.Identifiers{"from", "to"}},
+	&binaryBuiltin{name: "contains", function: builtinContains, params: ast.Identifiers{"arr", "elem"}},
+	&binaryBuiltin{name: "indexOf", function: builtinIndexOf, params: ast.Identifiers{"arr", "elem"}},
+	&binaryBuiltin{name: "lastIndexOf", function: builtinLastIndexOf, params: ast.Identifiers{"arr", "elem"}},
+	&generalBuiltin{name: "range", function: builtinRange, params: []generalBuiltinParameter{{name: "from"}, {name: "to"}, {name: "step", defaultValue: intToValue(1)}}},
+	&ternaryBuiltin{name: "rangeStep", function: builtinRangeStep, params: ast.Identifiers{"from", "to", "step"}},
 	&binaryBuiltin{name: "primitiveEquals", function: primitiveEquals, params: ast.Identifiers{"x", "y"}},
+	&binaryBuiltin{name: "cmp", function: builtinCmp, params: ast.Identifiers{"a", "b"}},
 	&binaryBuiltin{name: "equals", function: builtinEquals, params: ast.Identifiers{"x", "y"}},
 	&binaryBuiltin{name: "objectFieldsEx", function: builtinObjectFieldsEx, params: ast.Identifiers{"obj", "hidden"}},
+	&unaryBuiltin{name: "objectFields", function: builtinObjectFields, params: ast.Identifiers{"o"}},
+	&unaryBuiltin{name: "objectFieldsAll", function: builtinObjectFieldsAll, params: ast.Identifiers{"o"}},
+	&unaryBuiltin{name: "objectValues", function: builtinObjectValues, params: ast.Identifiers{"o"}},
+	&unaryBuiltin{name: "objectValuesAll", function: builtinObjectValuesAll, params: ast.Identifiers{"o"}},
+	&unaryBuiltin{name: "objectPairs", function: builtinObjectPairs, params: ast.Identifiers{"o"}},
+	&unaryBuiltin{name: "objectPairsAll", function: builtinObjectPairsAll, params: ast.Identifiers{"o"}},
+	&generalBuiltin{name: "objectFromPairs", function: builtinObjectFromPairs, params: []generalBuiltinParameter{{name: "pairs"}, {name: "lastWins", defaultValue: makeValueBoolean(false)}}},
+	&binaryBuiltin{name: "mapWithKey", function: builtinMapWithKey, params: ast.Identifiers{"func", "obj"}},
+	&binaryBuiltin{name: "mapObject", function: builtinMapObject, params: ast.Identifiers{"func", "obj"}},
+	&binaryBuiltin{name: "mapObjectKeys", function: builtinMapObjectKeys, params: ast.Identifiers{"func", "obj"}},
+	&binaryBuiltin{name: "objectRemoveKeys", function: builtinObjectRemoveKeys, params: ast.Identifiers{"obj", "keys"}},
 	&ternaryBuiltin{name: "objectHasEx", function: builtinObjectHasEx, params: ast.Identifiers{"obj", "fname", "hidden"}},
+	&binaryBuiltin{name: "objectHas", function: builtinObjectHas, params: ast.Identifiers{"o", "f"}},
+	&binaryBuiltin{name: "objectHasAll", function: builtinObjectHasAll, params: ast.Identifiers{"o", "f"}},
+	&binaryBuiltin{name: "objectHasNonNull", function: builtinObjectHasNonNull, params: ast.Identifiers{"o", "f"}},
+	&binaryBuiltin{name: "objectHasPath", function: builtinObjectHasPath, params: ast.Identifiers{"obj", "path"}},
+	&generalBuiltin{name: "getPath", function: builtinGetPath, params: []generalBuiltinParameter{{name: "obj"}, {name: "path"}, {name: "default", defaultValue: &nullValue}}},
 	&unaryBuiltin{name: "type", function: builtinType, params: ast.Identifiers{"x"}},
+	&binaryBuiltin{name: "repeat", function: builtinRepeat, params: ast.Identifiers{"what", "count"}},
+	&unaryBuiltin{name: "isNull", function: builtinIsNull, params: ast.Identifiers{"v"}},
 	&unaryBuiltin{name: "char", function: builtinChar, params: ast.Identifiers{"n"}},
 	&unaryBuiltin{name: "codepoint", function: builtinCodepoint, params: ast.Identifiers{"str"}},
 	&unaryBuiltin{name: "ceil", function: builtinCeil, params: ast.Identifiers{"x"}},
@@ -2220,31 +4066,58 @@ var funcBuiltins = buildBuiltinMap([]builtin{
 	&unaryBuiltin{name: "round", function: builtinRound, params: ast.Identifiers{"x"}},
 	&binaryBuiltin{name: "pow", function: builtinPow, params: ast.Identifiers{"x", "n"}},
 	&binaryBuiltin{name: "modulo", function: builtinModulo, params: ast.Identifiers{"x", "y"}},
+	&binaryBuiltin{name: "format", function: builtinFormat, params: ast.Identifiers{"str", "vals"}},
+	&unaryBuiltin{name: "parseBigInt", function: builtinParseBigInt, params: ast.Identifiers{"str"}},
+	&binaryBuiltin{name: "bigAdd", function: bigIntBuiltin("bigAdd", (*big.Int).Add), params: ast.Identifiers{"a", "b"}},
+	&binaryBuiltin{name: "bigSub", function: bigIntBuiltin("bigSub", (*big.Int).Sub), params: ast.Identifiers{"a", "b"}},
+	&binaryBuiltin{name: "bigMul", function: bigIntBuiltin("bigMul", (*big.Int).Mul), params: ast.Identifiers{"a", "b"}},
 	&unaryBuiltin{name: "md5", function: builtinMd5, params: ast.Identifiers{"s"}},
+	&unaryBuiltin{name: "valueHash", function: builtinValueHash, params: ast.Identifiers{"value"}},
 	&binaryBuiltin{name: "xnor", function: builtinXnor, params: ast.Identifiers{"x", "y"}},
 	&binaryBuiltin{name: "xor", function: builtinXor, params: ast.Identifiers{"x", "y"}},
 	&binaryBuiltin{name: "lstripChars", function: builtinLstripChars, params: ast.Identifiers{"str", "chars"}},
 	&binaryBuiltin{name: "rstripChars", function: builtinRstripChars, params: ast.Identifiers{"str", "chars"}},
 	&binaryBuiltin{name: "stripChars", function: builtinStripChars, params: ast.Identifiers{"str", "chars"}},
+	&unaryBuiltin{name: "trim", function: builtinTrim, params: ast.Identifiers{"str"}},
+	&unaryBuiltin{name: "dirname", function: builtinDirname, params: ast.Identifiers{"path"}},
+	&unaryBuiltin{name: "basename", function: builtinBasename, params: ast.Identifiers{"path"}},
 	&ternaryBuiltin{name: "substr", function: builtinSubstr, params: ast.Identifiers{"str", "from", "len"}},
 	&ternaryBuiltin{name: "splitLimit", function: builtinSplitLimit, params: ast.Identifiers{"str", "c", "maxsplits"}},
 	&ternaryBuiltin{name: "strReplace", function: builtinStrReplace, params: ast.Identifiers{"str", "from", "to"}},
+	&ternaryBuiltin{name: "strReplaceFirst", function: builtinStrReplaceFirst, params: ast.Identifiers{"str", "from", "to"}},
+	&binaryBuiltin{name: "indent", function: builtinIndent, params: ast.Identifiers{"str", "prefix"}},
+	&binaryBuiltin{name: "regexMatch", function: builtinRegexMatch, params: ast.Identifiers{"str", "pattern"}},
+	&ternaryBuiltin{name: "regexReplace", function: builtinRegexReplace, params: ast.Identifiers{"str", "pattern", "replacement"}},
 	&unaryBuiltin{name: "isEmpty", function: builtinIsEmpty, params: ast.Identifiers{"str"}},
 	&unaryBuiltin{name: "base64Decode", function: builtinBase64Decode, params: ast.Identifiers{"str"}},
 	&unaryBuiltin{name: "base64DecodeBytes", function: builtinBase64DecodeBytes, params: ast.Identifiers{"str"}},
 	&unaryBuiltin{name: "parseInt", function: builtinParseInt, params: ast.Identifiers{"str"}},
 	&unaryBuiltin{name: "parseJson", function: builtinParseJSON, params: ast.Identifiers{"str"}},
 	&unaryBuiltin{name: "parseYaml", function: builtinParseYAML, params: ast.Identifiers{"str"}},
+	&unaryBuiltin{name: "parseYamlStream", function: builtinParseYAMLStream, params: ast.Identifiers{"str"}},
 	&generalBuiltin{name: "manifestJsonEx", function: builtinManifestJSONEx, params: []generalBuiltinParameter{{name: "value"}, {name: "indent"},
 		{name: "newline", defaultValue: &valueFlatString{value: []rune("\n")}},
 		{name: "key_val_sep", defaultValue: &valueFlatString{value: []rune(": ")}}}},
+	&generalBuiltin{name: "parseCsv", function: builtinParseCsv, params: []generalBuiltinParameter{{name: "str"}, {name: "hasHeader", defaultValue: makeValueBoolean(true)}}},
+	&binaryBuiltin{name: "manifestCsv", function: builtinManifestCsv, params: ast.Identifiers{"arr", "columns"}},
+	&generalBuiltin{name: "manifestJson", function: builtinManifestJSON, params: []generalBuiltinParameter{{name: "value"}, {name: "sortKeys", defaultValue: makeValueBoolean(true)}}},
+	&generalBuiltin{name: "manifestYamlDoc", function: builtinManifestYamlDoc, params: []generalBuiltinParameter{{name: "value"},
+		{name: "indent_array_in_object", defaultValue: makeValueBoolean(false)},
+		{name: "quote_keys", defaultValue: makeValueBoolean(true)}}},
+	&binaryBuiltin{name: "manifestXmlJsonmlEx", function: builtinManifestXmlJsonmlEx, params: ast.Identifiers{"value", "indent"}},
 	&generalBuiltin{name: "manifestTomlEx", function: builtinManifestTomlEx, params: []generalBuiltinParameter{{name: "value"}, {name: "indent"}}},
 	&unaryBuiltin{name: "base64", function: builtinBase64, params: ast.Identifiers{"input"}},
+	&unaryBuiltin{name: "base32", function: builtinBase32, params: ast.Identifiers{"input"}},
+	&unaryBuiltin{name: "base32Decode", function: builtinBase32Decode, params: ast.Identifiers{"str"}},
 	&unaryBuiltin{name: "encodeUTF8", function: builtinEncodeUTF8, params: ast.Identifiers{"str"}},
 	&unaryBuiltin{name: "decodeUTF8", function: builtinDecodeUTF8, params: ast.Identifiers{"arr"}},
 	&generalBuiltin{name: "sort", function: builtinSort, params: []generalBuiltinParameter{{name: "arr"}, {name: "keyF", defaultValue: functionID}}},
+	&generalBuiltin{name: "sortObjectByValue", function: builtinSortObjectByValue, params: []generalBuiltinParameter{{name: "obj"}, {name: "keyF", defaultValue: functionID}}},
+	&generalBuiltin{name: "minArray", function: builtinMinArray, params: []generalBuiltinParameter{{name: "arr"}, {name: "keyF", defaultValue: functionID}, {name: "onEmpty", defaultValue: onEmptyError}}},
+	&generalBuiltin{name: "maxArray", function: builtinMaxArray, params: []generalBuiltinParameter{{name: "arr"}, {name: "keyF", defaultValue: functionID}, {name: "onEmpty", defaultValue: onEmptyError}}},
 	&unaryBuiltin{name: "native", function: builtinNative, params: ast.Identifiers{"x"}},
 	&unaryBuiltin{name: "sum", function: builtinSum, params: ast.Identifiers{"arr"}},
+	&unaryBuiltin{name: "avg", function: builtinAvg, params: ast.Identifiers{"arr"}},
 
 	// internal
 	&unaryBuiltin{name: "$objectFlatMerge", function: builtinUglyObjectFlatMerge, params: ast.Identifiers{"x"}},
@@ -1,6 +1,7 @@
 package jsonnet
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"os"
@@ -91,3 +92,45 @@ func Benchmark_Builtin_rstripChars(b *testing.B) {
 func Benchmark_Builtin_stripChars(b *testing.B) {
 	RunBenchmark(b, "stripChars")
 }
+
+func Benchmark_Builtin_range(b *testing.B) {
+	RunBenchmark(b, "range")
+}
+
+// Benchmark_Builtin_parseJson parses a multi-megabyte JSON array in-process,
+// so that the allocations of std.parseJson are reported.
+func Benchmark_Builtin_parseJson(b *testing.B) {
+	var buf bytes.Buffer
+	buf.WriteString("[")
+	for n := 0; n < 20000; n++ {
+		if n > 0 {
+			buf.WriteString(",")
+		}
+		fmt.Fprintf(&buf, `{"id": %d, "name": "item \"%d\"", "tags": ["a", "bé"], "price": %d.25, "active": true, "parent": null}`, n, n, n)
+	}
+	buf.WriteString("]")
+	vm := MakeVM()
+	vm.ExtVar("data", buf.String())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		vm.flushValueCache()
+		_, err := vm.EvaluateAnonymousSnippet("bench.jsonnet", "std.length(std.parseJson(std.extVar('data')))")
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Benchmark_MakeVM measures the cost of a short-lived VM, including building
+// the interpreter with the standard library.
+func Benchmark_MakeVM(b *testing.B) {
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		vm := MakeVM()
+		_, err := vm.EvaluateAnonymousSnippet("bench.jsonnet", "std.length([1, 2, 3])")
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
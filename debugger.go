@@ -0,0 +1,133 @@
+/*
+Copyright 2024 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"github.com/google/go-jsonnet/ast"
+)
+
+// StackFrame describes the expression about to be evaluated
+// when the evaluation is paused, see VM.SetStepHook.
+type StackFrame struct {
+	// Node is the expression about to be evaluated.
+	Node ast.Node
+	// Loc is the location of the expression.
+	Loc ast.LocationRange
+	// Depth is the nesting of the expression, each subexpression being evaluated adds one.
+	Depth int
+	// Callers are the locations of the function calls in progress, the innermost last.
+	Callers []ast.LocationRange
+}
+
+// StepAction tells the evaluation where to pause next.
+type StepAction int
+
+const (
+	// StepContinue continues until the next breakpoint.
+	StepContinue StepAction = iota
+	// StepOver pauses at the next expression which is not a part of the current one.
+	StepOver
+	// StepInto pauses at the next expression, which is usually a part of the current one.
+	StepInto
+)
+
+// StepHook is called whenever the evaluation is paused, and returns where to pause next.
+type StepHook func(frame StackFrame) StepAction
+
+// debugger pauses the evaluation at breakpoints and when stepping.
+type debugger struct {
+	breakpoints map[ast.Location]struct{}
+	hook        StepHook
+
+	// The action returned by the hook when the evaluation was paused last time.
+	action StepAction
+	// The nesting of the expression being evaluated and of the one where the evaluation was paused.
+	depth       int
+	pausedDepth int
+	// The file and the line where the evaluation was paused last time, empty if it was not paused yet.
+	pausedFile string
+	pausedLine int
+}
+
+func (d *debugger) reset() {
+	d.action = StepContinue
+	d.depth = 0
+	d.pausedDepth = 0
+	d.pausedFile = ""
+	d.pausedLine = 0
+}
+
+// atBreakpoint checks if there is a breakpoint at the beginning of loc. Only the outermost
+// of the nested expressions matches, e.g. a breakpoint without a column matches the enclosing
+// expression beginning on its line but not its subexpressions on the same line.
+// A breakpoint without a column doesn't match again until the evaluation is paused elsewhere,
+// e.g. when the lazily evaluated expressions on the line are evaluated later.
+// The breakpoints never match the standard library.
+func (d *debugger) atBreakpoint(loc *ast.LocationRange, parent *ast.LocationRange) bool {
+	if loc.File != nil && loc.File.DiagnosticFileName == "<std>" {
+		return false
+	}
+	sameFile := parent != nil && parent.FileName == loc.FileName
+	if _, ok := d.breakpoints[loc.Begin]; ok {
+		return !sameFile || parent.Begin != loc.Begin
+	}
+	if _, ok := d.breakpoints[ast.Location{Line: loc.Begin.Line}]; ok {
+		if d.pausedFile == loc.FileName && d.pausedLine == loc.Begin.Line {
+			return false
+		}
+		return !sameFile || parent.Begin.Line != loc.Begin.Line
+	}
+	return false
+}
+
+func (d *debugger) shouldPause(loc *ast.LocationRange, parent *ast.LocationRange) bool {
+	if loc == nil || !loc.IsSet() {
+		// Expressions added by desugaring have no location to show.
+		return false
+	}
+	switch d.action {
+	case StepInto:
+		return true
+	case StepOver:
+		if d.depth <= d.pausedDepth {
+			return true
+		}
+	}
+	return d.atBreakpoint(loc, parent)
+}
+
+// step is called before evaluating a node, parent is the location of the enclosing expression.
+func (d *debugger) step(i *interpreter, node ast.Node, parent *ast.LocationRange) {
+	if !d.shouldPause(node.Loc(), parent) {
+		return
+	}
+	var callers []ast.LocationRange
+	for _, frame := range i.stack.stack {
+		if frame.cleanEnv && frame.trace.loc != nil && frame.trace.loc.IsSet() {
+			callers = append(callers, *frame.trace.loc)
+		}
+	}
+	d.action = d.hook(StackFrame{
+		Node:    node,
+		Loc:     *node.Loc(),
+		Depth:   d.depth,
+		Callers: callers,
+	})
+	d.pausedDepth = d.depth
+	d.pausedFile = node.Loc().FileName
+	d.pausedLine = node.Loc().Begin.Line
+}
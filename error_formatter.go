@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"strings"
 
 	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/internal/errors"
@@ -52,6 +53,9 @@ type termErrorFormatter struct {
 	// maxStackTraceSize  is the maximum length of stack trace before cropping
 	maxStackTraceSize int
 	pretty            bool
+	// tabSize is the width of a tab in the shown code, if it is positive, the tabs
+	// are expanded to spaces and the location is underlined.
+	tabSize int
 }
 
 func (ef *termErrorFormatter) SetMaxStackTraceSize(size int) {
@@ -67,7 +71,9 @@ func (ef *termErrorFormatter) Format(err error) string {
 	case RuntimeError:
 		return ef.formatRuntime(&err)
 	case errors.StaticError:
-		return ef.formatStatic(err)
+		return ef.formatStatic(err.Error(), err.Loc())
+	case StaticError:
+		return ef.formatStatic(err.Error(), err.Loc)
 	default:
 		return ef.formatInternal(err)
 	}
@@ -77,10 +83,10 @@ func (ef *termErrorFormatter) formatRuntime(err *RuntimeError) string {
 	return err.Error() + "\n" + ef.buildStackTrace(err.StackTrace)
 }
 
-func (ef *termErrorFormatter) formatStatic(err errors.StaticError) string {
+func (ef *termErrorFormatter) formatStatic(msg string, loc ast.LocationRange) string {
 	var buf bytes.Buffer
-	buf.WriteString(err.Error() + "\n")
-	ef.showCode(&buf, err.Loc())
+	buf.WriteString(msg + "\n")
+	ef.showCode(&buf, loc)
 	return buf.String()
 }
 
@@ -98,27 +104,68 @@ func (ef *termErrorFormatter) showCode(buf *bytes.Buffer, loc ast.LocationRange)
 	}
 	if loc.WithCode() {
 		// TODO(sbarzowski) include line numbers
-		// TODO(sbarzowski) underline errors instead of depending only on color
 		fmt.Fprintf(buf, "\n")
-		beginning := ast.LineBeginning(&loc)
-		ending := ast.LineEnding(&loc)
-		fmt.Fprintf(buf, "%v", ef.sp.GetSnippet(beginning))
-		errFprintf(buf, "%v", ef.sp.GetSnippet(loc)) //nolint:errcheck
-		fmt.Fprintf(buf, "%v", ef.sp.GetSnippet(ending))
-		buf.WriteByte('\n')
+		beginning := ef.sp.GetSnippet(ast.LineBeginning(&loc))
+		code := ef.sp.GetSnippet(loc)
+		ending := ef.sp.GetSnippet(ast.LineEnding(&loc))
+		if ef.tabSize <= 0 {
+			fmt.Fprintf(buf, "%v", beginning)
+			errFprintf(buf, "%v", code) //nolint:errcheck
+			fmt.Fprintf(buf, "%v", ending)
+			buf.WriteByte('\n')
+		} else {
+			beginning, column := expandTabs(beginning, 0, ef.tabSize)
+			code, codeEnd := expandTabs(code, column, ef.tabSize)
+			ending, _ = expandTabs(ending, codeEnd, ef.tabSize)
+			fmt.Fprintf(buf, "%v", beginning)
+			errFprintf(buf, "%v", code) //nolint:errcheck
+			fmt.Fprintf(buf, "%v", ending)
+			buf.WriteByte('\n')
+			// Only the locations within a line are underlined.
+			if loc.Begin.Line == loc.End.Line {
+				width := codeEnd - column
+				if width < 1 {
+					width = 1
+				}
+				buf.WriteString(strings.Repeat(" ", column))
+				buf.WriteString(strings.Repeat("^", width))
+				buf.WriteByte('\n')
+			}
+		}
 	}
 	fmt.Fprintf(buf, "\n")
 }
 
-func (ef *termErrorFormatter) frame(frame *traceFrame, buf *bytes.Buffer) {
+// expandTabs replaces the tabs in s with spaces up to the next multiple of tabSize.
+// The column is where s starts, counted in characters from 0. It returns the column where s ends.
+func expandTabs(s string, column int, tabSize int) (string, int) {
+	var buf strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\t':
+			width := tabSize - column%tabSize
+			buf.WriteString(strings.Repeat(" ", width))
+			column += width
+		case '\n':
+			buf.WriteRune(r)
+			column = 0
+		default:
+			buf.WriteRune(r)
+			column++
+		}
+	}
+	return buf.String(), column
+}
+
+func (ef *termErrorFormatter) frame(frame *TraceFrame, buf *bytes.Buffer) {
 	// TODO(sbarzowski) tabs are probably a bad idea
-	fmt.Fprintf(buf, "\t%v\t%v\n", frame.Loc.String(), frame.Name)
+	fmt.Fprintf(buf, "\t%v\t%v\n", frame.Loc.String(), frame.Context)
 	if ef.pretty {
 		ef.showCode(buf, frame.Loc)
 	}
 }
 
-func (ef *termErrorFormatter) buildStackTrace(frames []traceFrame) string {
+func (ef *termErrorFormatter) buildStackTrace(frames []TraceFrame) string {
 	// https://github.com/google/jsonnet/blob/master/core/libjsonnet.cpp#L594
 	maxAbove := ef.maxStackTraceSize / 2
 	maxBelow := ef.maxStackTraceSize - maxAbove
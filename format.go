@@ -0,0 +1,590 @@
+/*
+Copyright 2024 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// formatCode is a parsed % tag of a format string, see std.format.
+type formatCode struct {
+	// The key in the (key) syntax, used when the values are an object.
+	mappingKey    *string
+	alt           bool
+	zero          bool
+	left          bool
+	blank         bool
+	plus          bool
+	fieldWidth    float64
+	fieldWidthArg bool
+	precision     *float64
+	precisionArg  bool
+	// One of d, o, x, e, f, g, c, s, j or %.
+	convType rune
+	caps     bool
+}
+
+// formatPart is either a literal text or a % tag of a format string.
+type formatPart struct {
+	text string
+	code *formatCode
+}
+
+// valuesNeeded returns how many values of an array the code consumes.
+func (code *formatCode) valuesNeeded() int {
+	n := 0
+	if code.fieldWidthArg {
+		n++
+	}
+	if code.precisionArg {
+		n++
+	}
+	if code.convType != '%' {
+		n++
+	}
+	return n
+}
+
+func errTruncatedFormatCode(i *interpreter) error {
+	return i.Error("Truncated format code.")
+}
+
+// maxFormatNumber is the largest field width or precision, the larger ones are rejected,
+// as they couldn't be rendered anyway.
+const maxFormatNumber = math.MaxInt32
+
+// checkFormatNumber checks that a field width or a precision, what says which one, can be used.
+func checkFormatNumber(i *interpreter, what string, v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) || math.Abs(v) > maxFormatNumber {
+		return i.Error(fmt.Sprintf("Format %s is too large: %v", what, v))
+	}
+	return nil
+}
+
+// parseFormatNumber parses a field width or a precision, * means it is taken from the values.
+func parseFormatNumber(i *interpreter, what string, str []rune, pos int) (int, float64, bool, error) {
+	if pos < len(str) && str[pos] == '*' {
+		return pos + 1, 0, true, nil
+	}
+	v := 0.0
+	for {
+		if pos >= len(str) {
+			return 0, 0, false, errTruncatedFormatCode(i)
+		}
+		c := str[pos]
+		if c < '0' || c > '9' {
+			return pos, v, false, checkFormatNumber(i, what, v)
+		}
+		v = v*10 + float64(c-'0')
+		pos++
+	}
+}
+
+// parseFormatCode parses a % tag starting after the %.
+func parseFormatCode(i *interpreter, str []rune, pos int) (int, *formatCode, error) {
+	code := &formatCode{}
+	if pos >= len(str) {
+		return 0, nil, errTruncatedFormatCode(i)
+	}
+
+	if str[pos] == '(' {
+		end := pos + 1
+		for end < len(str) && str[end] != ')' {
+			end++
+		}
+		if end >= len(str) {
+			return 0, nil, errTruncatedFormatCode(i)
+		}
+		key := string(str[pos+1 : end])
+		code.mappingKey = &key
+		pos = end + 1
+	}
+
+flags:
+	for ; ; pos++ {
+		if pos >= len(str) {
+			return 0, nil, errTruncatedFormatCode(i)
+		}
+		switch str[pos] {
+		case '#':
+			code.alt = true
+		case '0':
+			code.zero = true
+		case '-':
+			code.left = true
+		case ' ':
+			code.blank = true
+		case '+':
+			code.plus = true
+		default:
+			break flags
+		}
+	}
+
+	var err error
+	pos, code.fieldWidth, code.fieldWidthArg, err = parseFormatNumber(i, "field width", str, pos)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if pos >= len(str) {
+		return 0, nil, errTruncatedFormatCode(i)
+	}
+	if str[pos] == '.' {
+		var precision float64
+		pos, precision, code.precisionArg, err = parseFormatNumber(i, "precision", str, pos+1)
+		if err != nil {
+			return 0, nil, err
+		}
+		code.precision = &precision
+	}
+
+	// The length modifier is ignored, if it exists.
+	if pos >= len(str) {
+		return 0, nil, errTruncatedFormatCode(i)
+	}
+	if c := str[pos]; c == 'h' || c == 'l' || c == 'L' {
+		pos++
+	}
+
+	if pos >= len(str) {
+		return 0, nil, errTruncatedFormatCode(i)
+	}
+	c := str[pos]
+	switch c {
+	case 'd', 'i', 'u':
+		code.convType = 'd'
+	case 'o', 'x', 'e', 'f', 'g', 'c', 's', 'j', '%':
+		code.convType = c
+	case 'X', 'E', 'F', 'G':
+		code.convType = c - 'A' + 'a'
+		code.caps = true
+	default:
+		return 0, nil, i.Error(fmt.Sprintf("Unrecognised conversion type: %c", c))
+	}
+	return pos + 1, code, nil
+}
+
+// parseFormat splits a format string into literal texts and % tags.
+func parseFormat(i *interpreter, str []rune) ([]formatPart, error) {
+	var parts []formatPart
+	var text []rune
+	for pos := 0; pos < len(str); {
+		if str[pos] != '%' {
+			text = append(text, str[pos])
+			pos++
+			continue
+		}
+		end, code, err := parseFormatCode(i, str, pos+1)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, formatPart{text: string(text)}, formatPart{code: code})
+		text = nil
+		pos = end
+	}
+	return append(parts, formatPart{text: string(text)}), nil
+}
+
+// formatPadding returns s repeated to fill the width w.
+func formatPadding(w float64, s string) string {
+	if w <= 0 {
+		return ""
+	}
+	return strings.Repeat(s, int(math.Ceil(w)))
+}
+
+// formatPadLeft adds s to the left of str so that its length is at least w.
+func formatPadLeft(str string, w float64, s string) string {
+	return formatPadding(w-float64(len([]rune(str))), s) + str
+}
+
+// formatPadRight adds s to the right of str so that its length is at least w.
+func formatPadRight(str string, w float64, s string) string {
+	return str + formatPadding(w-float64(len([]rune(str))), s)
+}
+
+func formatSign(neg, blank, plus bool) string {
+	switch {
+	case neg:
+		return "-"
+	case plus:
+		return "+"
+	case blank:
+		return " "
+	}
+	return ""
+}
+
+// formatDigits renders a whole number mag >= 0 in the radix, with the numerals of the digits.
+func formatDigits(mag float64, radix float64, numerals string) string {
+	var digits []byte
+	for n := mag; n != 0; n = math.Floor(n / radix) {
+		digits = append(digits, numerals[int(math.Mod(n, radix))])
+	}
+	for l, r := 0, len(digits)-1; l < r; l, r = l+1, r-1 {
+		digits[l], digits[r] = digits[r], digits[l]
+	}
+	return string(digits)
+}
+
+// formatInt renders a sign and magnitude integer, mag must be a whole number >= 0.
+// The result is at least minChars long and has at least minDigits digits.
+// The zeroPrefix is prefixed before the digits of all numbers that are not 0.
+func formatInt(neg bool, mag float64, minChars, minDigits float64, blank, plus bool, radix float64, zeroPrefix string) string {
+	dec := "0"
+	if mag != 0 {
+		dec = zeroPrefix + formatDigits(mag, radix, "0123456789")
+	}
+	zp := minChars
+	if neg || blank || plus {
+		zp--
+	}
+	zp = math.Max(zp, minDigits)
+	return formatSign(neg, blank, plus) + formatPadLeft(dec, zp, "0")
+}
+
+// formatHex renders the whole part of n in hexadecimal.
+func formatHex(n float64, minChars, minDigits float64, blank, plus, addZerox, caps bool) string {
+	numerals := "0123456789abcdef"
+	prefix := "0x"
+	if caps {
+		numerals = "0123456789ABCDEF"
+		prefix = "0X"
+	}
+	hex := "0"
+	if mag := math.Floor(math.Abs(n)); mag != 0 {
+		hex = formatDigits(mag, 16, numerals)
+	}
+	neg := n < 0
+	zp := minChars
+	if neg || blank || plus {
+		zp--
+	}
+	if addZerox {
+		zp -= 2
+	} else {
+		prefix = ""
+	}
+	zp = math.Max(zp, minDigits)
+	return formatSign(neg, blank, plus) + prefix + formatPadLeft(hex, zp, "0")
+}
+
+// formatRound returns the whole and the fractional part of |n| rounded to prec decimal places,
+// the fractional part as a whole number of 1/10^prec.
+func formatRound(i *interpreter, n float64, prec float64) (float64, float64, error) {
+	denominator := math.Pow(10, prec)
+	if math.IsInf(denominator, 0) || denominator == 0 {
+		return 0, 0, i.Error("Overflow")
+	}
+	numerator := math.Abs(n)*denominator + 0.5
+	return math.Floor(numerator / denominator), math.Mod(math.Floor(numerator), denominator), nil
+}
+
+// formatFloatDec renders n in the decimal form, with prec decimal places. Unless trailing is set,
+// the trailing zeros of the decimal places are removed. The result is at least zeroPad long.
+func formatFloatDec(i *interpreter, n float64, zeroPad float64, blank, plus, ensurePt, trailing bool, prec float64) (string, error) {
+	whole, frac, err := formatRound(i, n, prec)
+	if err != nil {
+		return "", err
+	}
+	dotSize := 1.0
+	if prec == 0 && !ensurePt {
+		dotSize = 0
+	}
+	str := formatInt(n < 0, whole, zeroPad-prec-dotSize, 0, blank, plus, 10, "")
+	if prec == 0 {
+		if ensurePt {
+			return str + ".", nil
+		}
+		return str, nil
+	}
+	if !trailing && frac == 0 {
+		return str, nil
+	}
+	fracStr := formatInt(false, frac, prec, 0, false, false, 10, "")
+	if !trailing {
+		fracStr = strings.TrimRight(fracStr, "0")
+	}
+	return str + "." + fracStr, nil
+}
+
+// formatFloatSci renders n in the scientific form, with prec decimal places of the mantissa.
+func formatFloatSci(i *interpreter, n float64, zeroPad float64, blank, plus, ensurePt, trailing, caps bool, prec float64) (string, error) {
+	exponent := formatExponent(n)
+	mantissa := formatMantissa(n, exponent)
+	whole, _, err := formatRound(i, mantissa, prec)
+	if err != nil {
+		return "", err
+	}
+	if whole >= 10 {
+		// The mantissa is rounded up to 10, e.g. 9.96 with one decimal place.
+		exponent++
+		mantissa = formatMantissa(n, exponent)
+	}
+	e := "e"
+	if caps {
+		e = "E"
+	}
+	suffix := e + formatInt(exponent < 0, math.Abs(exponent), 3, 0, false, true, 10, "")
+	str, err := formatFloatDec(i, mantissa, zeroPad-float64(len(suffix)), blank, plus, ensurePt, trailing, prec)
+	if err != nil {
+		return "", err
+	}
+	return str + suffix, nil
+}
+
+func formatExponent(n float64) float64 {
+	if n == 0 {
+		return 0
+	}
+	return math.Floor(math.Log(math.Abs(n)) / math.Log(10))
+}
+
+func formatMantissa(n float64, exponent float64) float64 {
+	if exponent == -324 {
+		// Avoid a rounding error where 10^-324 is 0, -324 is the smallest exponent possible.
+		return n * 10 / math.Pow(10, exponent+1)
+	}
+	return n / math.Pow(10, exponent)
+}
+
+// formatValue renders a value with a code, at is the index or the key of the value.
+func formatValue(i *interpreter, val value, code *formatCode, fieldWidth float64, precision *float64, at interface{}) (string, error) {
+	fpprec := 6.0
+	iprec := 0.0
+	if precision != nil {
+		fpprec = *precision
+		iprec = *precision
+	}
+	zp := 0.0
+	if code.zero && !code.left {
+		zp = fieldWidth
+	}
+
+	switch code.convType {
+	case 's':
+		str, err := builtinToString(i, val)
+		if err != nil {
+			return "", err
+		}
+		s := str.(valueString).getGoString()
+		if precision != nil {
+			if runes := []rune(s); float64(len(runes)) > *precision {
+				s = string(runes[:int(math.Max(*precision, 0))])
+			}
+		}
+		return s, nil
+	case 'j':
+		var buf bytes.Buffer
+		if err := i.manifestAndSerializeJSON(&buf, val, false, ""); err != nil {
+			return "", err
+		}
+		return buf.String(), nil
+	case 'c':
+		switch val := val.(type) {
+		case *valueNumber:
+			str, err := builtinChar(i, val)
+			if err != nil {
+				return "", err
+			}
+			return str.(valueString).getGoString(), nil
+		case valueString:
+			if val.length() != 1 {
+				return "", i.Error(fmt.Sprintf("%%c expected 1-sized string got: %d", val.length()))
+			}
+			return val.getGoString(), nil
+		default:
+			return "", i.Error(fmt.Sprintf("%%c expected number / string, got: %s", val.getType().name))
+		}
+	}
+
+	num, ok := val.(*valueNumber)
+	if !ok {
+		return "", i.Error(fmt.Sprintf("Format required number at %v, got %s", at, val.getType().name))
+	}
+	n := num.value
+	switch code.convType {
+	case 'd':
+		return formatInt(n <= -1, math.Floor(math.Abs(n)), zp, iprec, code.blank, code.plus, 10, ""), nil
+	case 'o':
+		zeroPrefix := ""
+		if code.alt {
+			zeroPrefix = "0"
+		}
+		return formatInt(n <= -1, math.Floor(math.Abs(n)), zp, iprec, code.blank, code.plus, 8, zeroPrefix), nil
+	case 'x':
+		return formatHex(math.Floor(n), zp, iprec, code.blank, code.plus, code.alt, code.caps), nil
+	case 'f':
+		return formatFloatDec(i, n, zp, code.blank, code.plus, code.alt, true, fpprec)
+	case 'e':
+		return formatFloatSci(i, n, zp, code.blank, code.plus, code.alt, true, code.caps, fpprec)
+	case 'g':
+		if fpprec == 0 {
+			fpprec = 1
+		}
+		exponent := formatExponent(n)
+		if exponent < -4 || exponent >= fpprec {
+			return formatFloatSci(i, n, zp, code.blank, code.plus, code.alt, code.alt, code.caps, fpprec-1)
+		}
+		digitsBeforePt := math.Max(1, exponent+1)
+		return formatFloatDec(i, n, zp, code.blank, code.plus, code.alt, code.alt, fpprec-digitsBeforePt)
+	}
+	return "", i.Error(fmt.Sprintf("Unknown code: %c", code.convType))
+}
+
+func formatPadded(s string, code *formatCode, fieldWidth float64) string {
+	if code.left {
+		return formatPadRight(s, fieldWidth, " ")
+	}
+	return formatPadLeft(s, fieldWidth, " ")
+}
+
+// formatArray renders the parsed format string with the values of an array, each % tag
+// consumes one value, and the field width and the precision given as * one more each.
+func formatArray(i *interpreter, parts []formatPart, arr *valueArray) (string, error) {
+	needed := 0
+	for _, part := range parts {
+		if part.code != nil {
+			needed += part.code.valuesNeeded()
+		}
+	}
+	if needed > arr.length() {
+		return "", i.Error(fmt.Sprintf("Not enough values to format, expected %d, got %d", needed, arr.length()))
+	}
+	if needed < arr.length() {
+		return "", i.Error(fmt.Sprintf("Too many values to format, expected %d, got %d", needed, arr.length()))
+	}
+
+	var buf bytes.Buffer
+	j := 0
+	nextNumber := func(what string) (float64, error) {
+		v, err := arr.index(i, j)
+		if err != nil {
+			return 0, err
+		}
+		j++
+		num, err := i.getNumber(v)
+		if err != nil {
+			return 0, err
+		}
+		return num.value, checkFormatNumber(i, what, num.value)
+	}
+	for _, part := range parts {
+		code := part.code
+		if code == nil {
+			buf.WriteString(part.text)
+			continue
+		}
+		fieldWidth := code.fieldWidth
+		if code.fieldWidthArg {
+			w, err := nextNumber("field width")
+			if err != nil {
+				return "", err
+			}
+			fieldWidth = w
+		}
+		precision := code.precision
+		if code.precisionArg {
+			p, err := nextNumber("precision")
+			if err != nil {
+				return "", err
+			}
+			precision = &p
+		}
+		s := "%"
+		if code.convType != '%' {
+			val, err := arr.index(i, j)
+			if err != nil {
+				return "", err
+			}
+			s, err = formatValue(i, val, code, fieldWidth, precision, j)
+			if err != nil {
+				return "", err
+			}
+			j++
+		}
+		buf.WriteString(formatPadded(s, code, fieldWidth))
+	}
+	return buf.String(), nil
+}
+
+// formatObject renders the parsed format string with the fields of an object,
+// each % tag names the field with the (key) syntax.
+func formatObject(i *interpreter, parts []formatPart, obj *valueObject) (string, error) {
+	var buf bytes.Buffer
+	for _, part := range parts {
+		code := part.code
+		if code == nil {
+			buf.WriteString(part.text)
+			continue
+		}
+		if code.fieldWidthArg {
+			return "", i.Error("Cannot use * field width with object.")
+		}
+		if code.precisionArg {
+			return "", i.Error("Cannot use * precision with object.")
+		}
+		s := "%"
+		if code.convType != '%' {
+			if code.mappingKey == nil {
+				return "", i.Error("Mapping keys required.")
+			}
+			key := *code.mappingKey
+			if !objectHasField(objectBinding(obj), key, withHidden) {
+				return "", i.Error(fmt.Sprintf("No such field: %s", key))
+			}
+			val, err := obj.index(i, key)
+			if err != nil {
+				return "", err
+			}
+			s, err = formatValue(i, val, code, code.fieldWidth, code.precision, key)
+			if err != nil {
+				return "", err
+			}
+		}
+		buf.WriteString(formatPadded(s, code, code.fieldWidth))
+	}
+	return buf.String(), nil
+}
+
+func builtinFormat(i *interpreter, strv, valsv value) (value, error) {
+	str, err := i.getStringParam("std.format", 0, strv)
+	if err != nil {
+		return nil, err
+	}
+	parts, err := parseFormat(i, str.getRunes())
+	if err != nil {
+		return nil, err
+	}
+	var result string
+	switch vals := valsv.(type) {
+	case *valueArray:
+		result, err = formatArray(i, parts, vals)
+	case *valueObject:
+		result, err = formatObject(i, parts, vals)
+	default:
+		result, err = formatArray(i, parts, makeValueArray([]*cachedThunk{readyThunk(vals)}))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return makeValueString(result), nil
+}
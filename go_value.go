@@ -0,0 +1,647 @@
+/*
+Copyright 2017 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"encoding"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/internal/program"
+)
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+var textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+
+// goValueToNode converts a Go value into a (desugared) AST node.
+// It follows the rules of encoding/json: structs are converted to objects
+// with field names taken from the `json` struct tags, []byte is converted to
+// a base64 string, the map keys can be strings, integers or implement encoding.TextMarshaler,
+// nil pointers, slices, maps and interfaces are converted to null.
+// Values implementing json.Marshaler are converted through their JSON representation.
+// The numbers of Jsonnet are float64, so the integers which cannot be represented
+// exactly are rejected instead of being rounded. The cyclic values are rejected as well.
+func goValueToNode(v reflect.Value, path string) (ast.Node, error) {
+	return goValueToNodeAux(v, path, make(map[goValueRef]bool))
+}
+
+// goValueRef identifies a pointer, a map or a slice being converted, the cycles go through them.
+type goValueRef struct {
+	ptr    uintptr
+	length int
+	typ    reflect.Type
+}
+
+// visitGoValue marks a pointer, a map or a slice as being converted, until leave is called.
+// It returns an error if it is already being converted, as its conversion would never end.
+func visitGoValue(v reflect.Value, path string, visiting map[goValueRef]bool) (leave func(), err error) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			break
+		}
+		ref := goValueRef{ptr: v.Pointer(), typ: v.Type()}
+		if v.Kind() == reflect.Slice {
+			ref.length = v.Len()
+		}
+		if visiting[ref] {
+			return nil, fmt.Errorf("%s: encountered a cycle via %v", path, v.Type())
+		}
+		visiting[ref] = true
+		return func() { delete(visiting, ref) }, nil
+	}
+	return func() {}, nil
+}
+
+func goValueToNodeAux(v reflect.Value, path string, visiting map[goValueRef]bool) (ast.Node, error) {
+	if !v.IsValid() {
+		return &ast.LiteralNull{}, nil
+	}
+	if v.CanInterface() && v.Type().Implements(jsonMarshalerType) && !(v.Kind() == reflect.Ptr && v.IsNil()) {
+		data, err := v.Interface().(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return program.SnippetToAST(ast.DiagnosticFileName(path), "", string(data))
+	}
+
+	leave, err := visitGoValue(v, path, visiting)
+	if err != nil {
+		return nil, err
+	}
+	defer leave()
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return &ast.LiteralNull{}, nil
+		}
+		return goValueToNodeAux(v.Elem(), path, visiting)
+
+	case reflect.Bool:
+		return &ast.LiteralBoolean{Value: v.Bool()}, nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := v.Int()
+		// float64(math.MaxInt64) is out of the range of int64, so it is excluded before converting back.
+		if f := float64(n); f >= math.MaxInt64 || int64(f) != n {
+			return nil, fmt.Errorf("%s: integer %d cannot be represented exactly as a number", path, n)
+		}
+		return &ast.LiteralNumber{OriginalString: strconv.FormatInt(n, 10)}, nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := v.Uint()
+		if f := float64(n); f >= math.MaxUint64 || uint64(f) != n {
+			return nil, fmt.Errorf("%s: integer %d cannot be represented exactly as a number", path, n)
+		}
+		return &ast.LiteralNumber{OriginalString: strconv.FormatUint(n, 10)}, nil
+
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, fmt.Errorf("%s: unsupported number %v", path, f)
+		}
+		return &ast.LiteralNumber{OriginalString: strconv.FormatFloat(f, 'g', -1, 64)}, nil
+
+	case reflect.String:
+		return &ast.LiteralString{Value: v.String(), Kind: ast.StringDouble}, nil
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return &ast.LiteralNull{}, nil
+		}
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return &ast.LiteralString{Value: base64.StdEncoding.EncodeToString(v.Bytes()), Kind: ast.StringDouble}, nil
+		}
+		elements := make([]ast.CommaSeparatedExpr, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			element, err := goValueToNodeAux(v.Index(i), fmt.Sprintf("%s[%d]", path, i), visiting)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, ast.CommaSeparatedExpr{Expr: element})
+		}
+		return &ast.Array{Elements: elements}, nil
+
+	case reflect.Map:
+		if v.IsNil() {
+			return &ast.LiteralNull{}, nil
+		}
+		names := make(map[string]reflect.Value, v.Len())
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			name, err := goMapKeyName(key, path)
+			if err != nil {
+				return nil, err
+			}
+			names[name] = key
+			keys = append(keys, name)
+		}
+		sort.Strings(keys)
+		fields := make(ast.DesugaredObjectFields, 0, len(keys))
+		for _, name := range keys {
+			body, err := goValueToNodeAux(v.MapIndex(names[name]), path+"."+name, visiting)
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, makeGoValueField(name, body))
+		}
+		return &ast.DesugaredObject{Fields: fields}, nil
+
+	case reflect.Struct:
+		var collected []goStructField
+		if err := collectStructFields(v, path, 0, visiting, &collected); err != nil {
+			return nil, err
+		}
+		return &ast.DesugaredObject{Fields: dominantStructFields(collected)}, nil
+	}
+
+	return nil, fmt.Errorf("%s: unsupported type %v", path, v.Type())
+}
+
+// goMapKeyName converts a map key to the field name, like encoding/json does.
+func goMapKeyName(key reflect.Value, path string) (string, error) {
+	if key.Kind() == reflect.String {
+		return key.String(), nil
+	}
+	if key.Type().Implements(textMarshalerType) {
+		if key.Kind() == reflect.Ptr && key.IsNil() {
+			return "", nil
+		}
+		text, err := key.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", path, err)
+		}
+		return string(text), nil
+	}
+	switch key.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(key.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(key.Uint(), 10), nil
+	}
+	return "", fmt.Errorf("%s: unsupported map key type %v", path, key.Type())
+}
+
+type goStructField struct {
+	name  string
+	body  ast.Node
+	depth int
+}
+
+// collectStructFields collects the exported fields of the struct.
+// Embedded structs without a name in the tag are inlined, like in encoding/json.
+func collectStructFields(v reflect.Value, path string, depth int, visiting map[goValueRef]bool, fields *[]goStructField) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		tagParts := strings.Split(tag, ",")
+		name := tagParts[0]
+		fieldValue := v.Field(i)
+
+		if field.Anonymous && name == "" {
+			embedded := fieldValue
+			if embedded.Kind() == reflect.Ptr {
+				if embedded.IsNil() {
+					continue
+				}
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				leave, err := visitGoValue(fieldValue, path, visiting)
+				if err != nil {
+					return err
+				}
+				err = collectStructFields(embedded, path, depth+1, visiting, fields)
+				leave()
+				if err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if hasTagOption(tagParts[1:], "omitempty") && isEmptyGoValue(fieldValue) {
+			continue
+		}
+		body, err := goValueToNodeAux(fieldValue, path+"."+name, visiting)
+		if err != nil {
+			return err
+		}
+		*fields = append(*fields, goStructField{name: name, body: body, depth: depth})
+	}
+	return nil
+}
+
+// dominantStructFields resolves fields with the same name like encoding/json does:
+// the least nested one wins, if there are more of them, none is used.
+func dominantStructFields(collected []goStructField) ast.DesugaredObjectFields {
+	minDepth := make(map[string]int)
+	count := make(map[string]int)
+	for _, field := range collected {
+		depth, seen := minDepth[field.name]
+		switch {
+		case !seen || field.depth < depth:
+			minDepth[field.name] = field.depth
+			count[field.name] = 1
+		case field.depth == depth:
+			count[field.name]++
+		}
+	}
+	fields := make(ast.DesugaredObjectFields, 0, len(collected))
+	for _, field := range collected {
+		if field.depth == minDepth[field.name] && count[field.name] == 1 {
+			fields = append(fields, makeGoValueField(field.name, field.body))
+		}
+	}
+	return fields
+}
+
+func hasTagOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// isEmptyGoValue reports whether the value is omitted by the omitempty option.
+func isEmptyGoValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Ptr:
+		return v.IsZero()
+	}
+	return false
+}
+
+func makeGoValueField(name string, body ast.Node) ast.DesugaredObjectField {
+	return ast.DesugaredObjectField{
+		Name: &ast.LiteralString{Value: name, Kind: ast.StringDouble},
+		Body: body,
+		Hide: ast.ObjectFieldInherit,
+	}
+}
+
+// jsonToNode converts a manifested JSON value (as returned by manifestJSON)
+// into a literal AST, with the object fields sorted by name.
+func jsonToNode(v interface{}) ast.Node {
+	switch v := v.(type) {
+	case nil:
+		return &ast.LiteralNull{}
+	case bool:
+		return &ast.LiteralBoolean{Value: v}
+	case float64:
+		return &ast.LiteralNumber{OriginalString: unparseNumber(v)}
+	case string:
+		return &ast.LiteralString{Value: v, Kind: ast.StringDouble}
+	case []interface{}:
+		elements := make([]ast.CommaSeparatedExpr, 0, len(v))
+		for _, element := range v {
+			elements = append(elements, ast.CommaSeparatedExpr{Expr: jsonToNode(element)})
+		}
+		return &ast.Array{Elements: elements}
+	case map[string]interface{}:
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fields := make(ast.ObjectFields, 0, len(names))
+		for _, name := range names {
+			fields = append(fields, ast.ObjectField{
+				Kind:  ast.ObjectFieldStr,
+				Hide:  ast.ObjectFieldInherit,
+				Expr1: &ast.LiteralString{Value: name, Kind: ast.StringDouble},
+				Expr2: jsonToNode(v[name]),
+			})
+		}
+		return &ast.Object{Fields: fields}
+	}
+	panic(fmt.Sprintf("Unsupported manifested value %#+v", v))
+}
+
+var jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// jsonToGoValue stores a manifested JSON value (as returned by manifestJSON)
+// in the value pointed to by out. It follows the rules of encoding/json.Unmarshal.
+func jsonToGoValue(data interface{}, out interface{}) error {
+	v := reflect.ValueOf(out)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("expected a non-nil pointer, got %T", out)
+	}
+	return setGoValue(data, v.Elem(), "$")
+}
+
+func setGoValue(data interface{}, v reflect.Value, path string) error {
+	if v.CanAddr() && v.Addr().Type().Implements(jsonUnmarshalerType) {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		if err := v.Addr().Interface().(json.Unmarshaler).UnmarshalJSON(encoded); err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		return nil
+	}
+
+	if data == nil {
+		switch v.Kind() {
+		case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice:
+			v.Set(reflect.Zero(v.Type()))
+		}
+		return nil
+	}
+
+	mismatch := func() error {
+		return fmt.Errorf("%s: cannot store %s in Go value of type %v", path, jsonTypeName(data), v.Type())
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setGoValue(data, v.Elem(), path)
+
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return mismatch()
+		}
+		v.Set(reflect.ValueOf(data))
+		return nil
+
+	case reflect.Bool:
+		b, ok := data.(bool)
+		if !ok {
+			return mismatch()
+		}
+		v.SetBool(b)
+		return nil
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f, ok := data.(float64)
+		if !ok {
+			return mismatch()
+		}
+		n := int64(f)
+		if float64(n) != f || v.OverflowInt(n) {
+			return fmt.Errorf("%s: number %v does not fit in Go value of type %v", path, f, v.Type())
+		}
+		v.SetInt(n)
+		return nil
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		f, ok := data.(float64)
+		if !ok {
+			return mismatch()
+		}
+		n := uint64(f)
+		if f < 0 || float64(n) != f || v.OverflowUint(n) {
+			return fmt.Errorf("%s: number %v does not fit in Go value of type %v", path, f, v.Type())
+		}
+		v.SetUint(n)
+		return nil
+
+	case reflect.Float32, reflect.Float64:
+		f, ok := data.(float64)
+		if !ok {
+			return mismatch()
+		}
+		if v.OverflowFloat(f) {
+			return fmt.Errorf("%s: number %v does not fit in Go value of type %v", path, f, v.Type())
+		}
+		v.SetFloat(f)
+		return nil
+
+	case reflect.String:
+		s, ok := data.(string)
+		if !ok {
+			return mismatch()
+		}
+		v.SetString(s)
+		return nil
+
+	case reflect.Slice:
+		elements, ok := data.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		slice := reflect.MakeSlice(v.Type(), len(elements), len(elements))
+		for i, element := range elements {
+			if err := setGoValue(element, slice.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+
+	case reflect.Array:
+		elements, ok := data.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		if len(elements) != v.Len() {
+			return fmt.Errorf("%s: cannot store array of length %d in Go value of type %v", path, len(elements), v.Type())
+		}
+		for i, element := range elements {
+			if err := setGoValue(element, v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case reflect.Map:
+		fields, ok := data.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("%s: unsupported map key type %v", path, v.Type().Key())
+		}
+		if v.IsNil() {
+			v.Set(reflect.MakeMapWithSize(v.Type(), len(fields)))
+		}
+		for _, name := range sortedJSONFieldNames(fields) {
+			fieldData := fields[name]
+			element := reflect.New(v.Type().Elem()).Elem()
+			if err := setGoValue(fieldData, element, path+"."+name); err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(name).Convert(v.Type().Key()), element)
+		}
+		return nil
+
+	case reflect.Struct:
+		fields, ok := data.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for _, name := range sortedJSONFieldNames(fields) {
+			fieldData := fields[name]
+			field, found := findStructField(v, name)
+			if !found {
+				// Like encoding/json, unknown fields are ignored.
+				continue
+			}
+			if err := setGoValue(fieldData, field, path+"."+name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%s: unsupported type %v", path, v.Type())
+}
+
+// findStructField finds the field of the struct which corresponds to the given
+// JSON field name, allocating embedded struct pointers if needed.
+// Exact matches of names are preferred over case-insensitive ones, like in encoding/json.
+func findStructField(v reflect.Value, name string) (reflect.Value, bool) {
+	var index []int
+	t := v.Type()
+	for _, exact := range []bool{true, false} {
+		index = findStructFieldIndex(t, name, exact)
+		if index != nil {
+			break
+		}
+	}
+	if index == nil {
+		return reflect.Value{}, false
+	}
+	for n, i := range index {
+		if n > 0 {
+			if v.Kind() == reflect.Ptr {
+				if v.IsNil() {
+					if !v.CanSet() {
+						return reflect.Value{}, false
+					}
+					v.Set(reflect.New(v.Type().Elem()))
+				}
+				v = v.Elem()
+			}
+		}
+		v = v.Field(i)
+	}
+	return v, v.CanSet()
+}
+
+// findStructFieldIndex returns the index sequence of the least nested field
+// with the given JSON name, or nil if there is no such field.
+func findStructFieldIndex(t reflect.Type, name string, exact bool) []int {
+	current := []structLevel{{t: t}}
+	for len(current) > 0 {
+		var next []structLevel
+		var found []int
+		count := 0
+		for _, level := range current {
+			for i := 0; i < level.t.NumField(); i++ {
+				field := level.t.Field(i)
+				tag := field.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				fieldName := strings.Split(tag, ",")[0]
+				index := append(append([]int{}, level.index...), i)
+				if field.Anonymous && fieldName == "" {
+					embedded := field.Type
+					if embedded.Kind() == reflect.Ptr {
+						embedded = embedded.Elem()
+					}
+					if embedded.Kind() == reflect.Struct {
+						next = append(next, structLevel{t: embedded, index: index})
+						continue
+					}
+				}
+				if !field.IsExported() {
+					continue
+				}
+				if fieldName == "" {
+					fieldName = field.Name
+				}
+				if fieldName == name || (!exact && strings.EqualFold(fieldName, name)) {
+					found = index
+					count++
+				}
+			}
+		}
+		if count == 1 {
+			return found
+		}
+		if count > 1 {
+			// Ambiguous fields are ignored, like in encoding/json.
+			return nil
+		}
+		current = next
+	}
+	return nil
+}
+
+// sortedJSONFieldNames returns the field names in a deterministic order,
+// so that the reported error does not depend on the map iteration order.
+func sortedJSONFieldNames(fields map[string]interface{}) []string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+type structLevel struct {
+	t     reflect.Type
+	index []int
+}
+
+func jsonTypeName(data interface{}) string {
+	switch data.(type) {
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", data)
+}
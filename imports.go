@@ -17,10 +17,14 @@ limitations under the License.
 package jsonnet
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"unsafe"
 
 	"github.com/google/go-jsonnet/ast"
@@ -55,9 +59,75 @@ type Importer interface {
 	// Importing the same file multiple times must be a cheap operation
 	// and shouldn't involve copying the whole file - the same buffer
 	// should be returned.
+	//
+	// If the file does not exist, the returned error should match
+	// ErrImportNotFound, so that it can be told apart from other failures.
 	Import(importedFrom, importedPath string) (contents Contents, foundAt string, err error)
 }
 
+// ErrImportNotFound is matched by the errors of the importers when the imported file
+// does not exist, as opposed to the other failures, e.g. when the file cannot be read.
+// The custom importers can wrap it, e.g. fmt.Errorf("%s not found: %w", path, ErrImportNotFound).
+// The errors matching fs.ErrNotExist are treated as not found as well.
+var ErrImportNotFound = errors.New("import not found")
+
+// importNotFoundError is an error with its own message which matches ErrImportNotFound.
+type importNotFoundError struct {
+	msg string
+}
+
+func (e importNotFoundError) Error() string {
+	return e.msg
+}
+
+func (e importNotFoundError) Is(target error) bool {
+	return target == ErrImportNotFound
+}
+
+// isImportNotFound checks whether the importer failed because the file does not exist.
+func isImportNotFound(err error) bool {
+	return errors.Is(err, ErrImportNotFound) || errors.Is(err, fs.ErrNotExist)
+}
+
+// ImportPolicy controls which kinds of imports are allowed during evaluation.
+type ImportPolicy int
+
+const (
+	// ImportAllowAll allows import, importstr and importbin.
+	ImportAllowAll ImportPolicy = iota
+	// ImportCodeOnly allows only import of Jsonnet code, importstr and importbin are disabled.
+	ImportCodeOnly
+	// ImportDenyAll disables all kinds of imports.
+	ImportDenyAll
+)
+
+// allows checks whether the given kind of import ("import", "importstr" or "importbin")
+// is allowed by the policy.
+func (p ImportPolicy) allows(kind string) bool {
+	switch p {
+	case ImportAllowAll:
+		return true
+	case ImportCodeOnly:
+		return kind == "import"
+	default:
+		return false
+	}
+}
+
+// MissingImportPolicy controls what happens when an imported file cannot be found.
+type MissingImportPolicy int
+
+const (
+	// MissingImportError makes the import fail with a runtime error (the default).
+	MissingImportError MissingImportPolicy = iota
+	// MissingImportNull makes import, importstr and importbin of a file which
+	// cannot be found evaluate to null, so that a default can be used instead,
+	// e.g. `local site = import "site.libsonnet"; if site == null then {} else site`.
+	// Only the errors matching ErrImportNotFound are affected, other failures
+	// of the importer, e.g. when the file cannot be read, are still reported.
+	MissingImportNull
+)
+
 // Contents is a representation of imported data. It is a simple
 // byte wrapper, which makes it easier to enforce the caching policy.
 type Contents struct {
@@ -99,10 +169,37 @@ func MakeContentsRaw(bytes []byte) Contents {
 // It also verifies that the content pointer is the same for two foundAt values.
 type importCache struct {
 	foundAtVerification map[string]Contents
-	astCache            map[string]ast.Node
+	importedData        map[importRequest]importedData
+	astCache            map[string]*parsedFile
 	codeCache           map[string]potentialValue
 	importer            Importer
 	globalBinding       globalBindingMap
+
+	// The files parsed by the cache of the previous importer, reused when
+	// the new importer returns the same contents for them.
+	previousASTs map[string]*parsedFile
+
+	// Called with every successfully imported file, nil when not set
+	recordImport func(foundAt string)
+}
+
+// importRequest is a pair of arguments of Importer.Import.
+type importRequest struct {
+	importedFrom string
+	importedPath string
+}
+
+// importedData is a successful result of Importer.Import.
+type importedData struct {
+	contents Contents
+	foundAt  string
+}
+
+// parsedFile is the result of parsing an imported file with the given hash of the contents.
+type parsedFile struct {
+	node ast.Node
+	err  error
+	hash [sha256.Size]byte
 }
 
 // makeImportCache creates an importCache using an Importer.
@@ -110,7 +207,8 @@ func makeImportCache(importer Importer, globalBinding globalBindingMap) *importC
 	return &importCache{
 		importer:            importer,
 		foundAtVerification: make(map[string]Contents),
-		astCache:            make(map[string]ast.Node),
+		importedData:        make(map[importRequest]importedData),
+		astCache:            make(map[string]*parsedFile),
 		codeCache:           make(map[string]potentialValue),
 		globalBinding:       globalBinding,
 	}
@@ -120,17 +218,44 @@ func (cache *importCache) flushValueCache() {
 	cache.codeCache = make(map[string]potentialValue)
 }
 
+// flushASTCache drops the parsed files together with their values. This should be executed
+// when the global identifiers are added or removed, as the files are checked against them.
+func (cache *importCache) flushASTCache() {
+	cache.astCache = make(map[string]*parsedFile)
+	cache.previousASTs = nil
+	cache.flushValueCache()
+}
+
+// withOwnValueCache returns a cache which shares everything with this one,
+// except for the values of the imported files.
+func (cache *importCache) withOwnValueCache() *importCache {
+	copied := *cache
+	copied.codeCache = make(map[string]potentialValue)
+	return &copied
+}
+
+// importData calls the importer, only once for the given arguments, as the results
+// are required to be the same. The errors are not cached.
 func (cache *importCache) importData(importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
-	contents, foundAt, err = cache.importer.Import(importedFrom, importedPath)
-	if err != nil {
-		return Contents{}, "", err
-	}
-	if cached, importedBefore := cache.foundAtVerification[foundAt]; importedBefore {
-		if cached != contents {
-			panic(fmt.Sprintf("importer problem: a different instance of Contents returned when importing %#v again", foundAt))
-		}
+	request := importRequest{importedFrom: importedFrom, importedPath: importedPath}
+	if imported, isCached := cache.importedData[request]; isCached {
+		contents, foundAt = imported.contents, imported.foundAt
 	} else {
-		cache.foundAtVerification[foundAt] = contents
+		contents, foundAt, err = cache.importer.Import(importedFrom, importedPath)
+		if err != nil {
+			return Contents{}, "", err
+		}
+		if cached, importedBefore := cache.foundAtVerification[foundAt]; importedBefore {
+			if cached != contents {
+				panic(fmt.Sprintf("importer problem: a different instance of Contents returned when importing %#v again", foundAt))
+			}
+		} else {
+			cache.foundAtVerification[foundAt] = contents
+		}
+		cache.importedData[request] = importedData{contents: contents, foundAt: foundAt}
+	}
+	if cache.recordImport != nil {
+		cache.recordImport(foundAt)
 	}
 	return
 }
@@ -140,29 +265,53 @@ func (cache *importCache) importAST(importedFrom, importedPath string) (ast.Node
 	if err != nil {
 		return nil, "", err
 	}
-	if cachedNode, isCached := cache.astCache[foundAt]; isCached {
-		return cachedNode, foundAt, nil
-	}
-	node, err := program.SnippetToAST(ast.DiagnosticFileName(foundAt), foundAt, contents.String(), cache.globalBinding.Identifiers()...)
-	cache.astCache[foundAt] = node
+	node, err := cache.parseAST(contents, foundAt)
 	return node, foundAt, err
 }
 
+// parseAST parses the file once. The file parsed by the cache of the previous importer
+// is reused when its contents have the same hash, so only the changed files are parsed again.
+func (cache *importCache) parseAST(contents Contents, foundAt string) (ast.Node, error) {
+	if parsed, isCached := cache.astCache[foundAt]; isCached {
+		return parsed.node, parsed.err
+	}
+	hash := sha256.Sum256(contents.Data())
+	parsed, parsedBefore := cache.previousASTs[foundAt]
+	if !parsedBefore || parsed.hash != hash {
+		node, err := program.SnippetToAST(ast.DiagnosticFileName(foundAt), foundAt, contents.String(), cache.globalBinding.Identifiers()...)
+		parsed = &parsedFile{node: node, err: err, hash: hash}
+	}
+	cache.astCache[foundAt] = parsed
+	return parsed.node, parsed.err
+}
+
 // ImportString imports a string, caches it and then returns it.
-func (cache *importCache) importString(importedFrom, importedPath string, i *interpreter) (valueString, error) {
-	data, _, err := cache.importData(importedFrom, importedPath)
+func (cache *importCache) importString(importedFrom, importedPath string, i *interpreter) (value, error) {
+	data, foundAt, err := cache.importData(importedFrom, importedPath)
 	if err != nil {
+		if i.missingImportPolicy == MissingImportNull && isImportNotFound(err) {
+			return &nullValue, nil
+		}
 		return nil, i.Error(err.Error())
 	}
+	if err := i.countImport(foundAt); err != nil {
+		return nil, err
+	}
 	return makeValueString(data.String()), nil
 }
 
 // ImportString imports an array of bytes, caches it and then returns it.
-func (cache *importCache) importBinary(importedFrom, importedPath string, i *interpreter) (*valueArray, error) {
-	data, _, err := cache.importData(importedFrom, importedPath)
+func (cache *importCache) importBinary(importedFrom, importedPath string, i *interpreter) (value, error) {
+	data, foundAt, err := cache.importData(importedFrom, importedPath)
 	if err != nil {
+		if i.missingImportPolicy == MissingImportNull && isImportNotFound(err) {
+			return &nullValue, nil
+		}
 		return nil, i.Error(err.Error())
 	}
+	if err := i.countImport(foundAt); err != nil {
+		return nil, err
+	}
 	bytes := data.Data()
 	elements := make([]*cachedThunk, len(bytes))
 	for i := range bytes {
@@ -172,7 +321,7 @@ func (cache *importCache) importBinary(importedFrom, importedPath string, i *int
 }
 
 func nodeToPV(i *interpreter, filename string, node ast.Node) *cachedThunk {
-	env := makeInitialEnv(filename, i.baseStd, i.globalBinding)
+	env := makeInitialEnv(filename, i.baseStd, i.globalBinding, i.stdlibDisabled)
 	return &cachedThunk{
 		env:     &env,
 		body:    node,
@@ -195,14 +344,24 @@ func codeToPV(i *interpreter, filename string, code string) *cachedThunk {
 
 // ImportCode imports code from a path.
 func (cache *importCache) importCode(importedFrom, importedPath string, i *interpreter) (value, error) {
-	node, foundAt, err := cache.importAST(importedFrom, importedPath)
+	contents, foundAt, err := cache.importData(importedFrom, importedPath)
+	if err != nil {
+		if i.missingImportPolicy == MissingImportNull && isImportNotFound(err) {
+			return &nullValue, nil
+		}
+		return nil, i.Error(err.Error())
+	}
+	if err := i.countImport(foundAt); err != nil {
+		return nil, err
+	}
+	node, err := cache.parseAST(contents, foundAt)
 	if err != nil {
 		return nil, i.Error(err.Error())
 	}
 	var pv potentialValue
 	if cachedPV, isCached := cache.codeCache[foundAt]; !isCached {
 		// File hasn't been parsed and analyzed before, update the cache record.
-		env := makeInitialEnv(foundAt, i.baseStd, i.globalBinding)
+		env := makeInitialEnv(foundAt, i.baseStd, i.globalBinding, i.stdlibDisabled)
 		pv = &cachedThunk{
 			env:     &env,
 			body:    node,
@@ -284,7 +443,7 @@ func (importer *FileImporter) Import(importedFrom, importedPath string) (content
 	}
 
 	if !found {
-		return Contents{}, "", fmt.Errorf("couldn't open import %#v: no match locally or in the Jsonnet library paths", importedPath)
+		return Contents{}, "", importNotFoundError{fmt.Sprintf("couldn't open import %#v: no match locally or in the Jsonnet library paths", importedPath)}
 	}
 	return content, foundHere, nil
 }
@@ -300,5 +459,119 @@ func (importer *MemoryImporter) Import(importedFrom, importedPath string) (conte
 	if content, ok := importer.Data[importedPath]; ok {
 		return content, importedPath, nil
 	}
-	return Contents{}, "", fmt.Errorf("import not available %v", importedPath)
+	return Contents{}, "", importNotFoundError{fmt.Sprintf("import not available %v", importedPath)}
+}
+
+// ChainImporter imports data using an ordered list of importers.
+// Each importer is tried in turn and the first successful result is returned,
+// e.g. an in-memory overlay can be placed before a FileImporter.
+type ChainImporter struct {
+	Importers []Importer
+}
+
+// Import tries the importers in order and returns the result of the first one
+// which succeeds. The foundAt is the one reported by that importer.
+// The next importer is tried after any error, as the importers don't have to tell
+// a missing file apart from the other failures. If none of them succeeds, their errors
+// are combined, and the result matches ErrImportNotFound only if all of them do.
+func (importer *ChainImporter) Import(importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	if len(importer.Importers) == 0 {
+		return Contents{}, "", importNotFoundError{fmt.Sprintf("couldn't open import %#v: no importers configured", importedPath)}
+	}
+	errs := make([]string, 0, len(importer.Importers))
+	notFound := true
+	for _, i := range importer.Importers {
+		contents, foundAt, err := i.Import(importedFrom, importedPath)
+		if err == nil {
+			return contents, foundAt, nil
+		}
+		notFound = notFound && isImportNotFound(err)
+		errs = append(errs, err.Error())
+	}
+	if !notFound {
+		return Contents{}, "", errors.New(strings.Join(errs, "; "))
+	}
+	return Contents{}, "", importNotFoundError{strings.Join(errs, "; ")}
+}
+
+// snapshotImporter imports data from a copy of a directory taken in advance.
+type snapshotImporter struct {
+	root  string
+	files map[string]Contents
+}
+
+// NewSnapshotImporter reads all files in the root directory and its subdirectories
+// into memory and returns an Importer which imports from this snapshot,
+// so the evaluation is not affected by later changes of the files.
+//
+// The symbolic links to files are read as the files, while the symbolic links to
+// directories and the dangling ones are skipped, like the other special files.
+// The root itself can be a symbolic link to a directory.
+//
+// The paths are resolved like in FileImporter, except that the relative paths
+// of the files which are not imported from another file are relative to the root.
+func NewSnapshotImporter(root string) (Importer, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	// The root itself can be a symbolic link, e.g. to a vendored directory. Its target
+	// is walked, but the files are kept under the root, as they are imported by those paths.
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]Contents)
+	err = filepath.WalkDir(resolvedRoot, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type()&os.ModeSymlink != 0 {
+			// The links to files are followed, the links to directories, which could
+			// form cycles, and the dangling links are skipped.
+			info, err := os.Stat(path)
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+		} else if !entry.Type().IsRegular() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(resolvedRoot, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.Join(root, rel)] = MakeContentsRaw(data)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &snapshotImporter{root: root, files: files}, nil
+}
+
+// Import fetches data from the snapshot.
+func (importer *snapshotImporter) Import(importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	path := importedPath
+	if !filepath.IsAbs(path) {
+		dir, _ := filepath.Split(importedFrom)
+		path = filepath.Join(dir, importedPath)
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(importer.root, path)
+		}
+	}
+	path = filepath.Clean(path)
+	if content, ok := importer.files[path]; ok {
+		return content, path, nil
+	}
+	return Contents{}, "", importNotFoundError{fmt.Sprintf("couldn't open import %#v: no match in the snapshot of %s", importedPath, importer.root)}
 }
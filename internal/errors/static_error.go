@@ -33,6 +33,8 @@ type StaticError interface {
 	WithContext(string) StaticError
 	// Error returns the string representation of a StaticError.
 	Error() string
+	// Message returns the error message without the location.
+	Message() string
 	// Loc returns the place in the source code that triggerred the error.
 	Loc() ast.LocationRange
 }
@@ -57,6 +59,10 @@ func (err staticError) Error() string {
 	return fmt.Sprintf("%v %v", loc, err.msg)
 }
 
+func (err staticError) Message() string {
+	return err.msg
+}
+
 func (err staticError) Loc() ast.LocationRange {
 	return err.loc
 }
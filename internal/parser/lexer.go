@@ -974,3 +974,67 @@ func Lex(diagnosticFilename ast.DiagnosticFileName, importedFilename, input stri
 	l.emitToken(tokenEndOfFile)
 	return l.tokens, nil
 }
+
+// TokenKind is a coarse classification of the tokens, meant for tools such as
+// syntax highlighters.
+type TokenKind int
+
+const (
+	// TokenPunctuation is one of the symbols { } [ ] , $ . ( ) ;
+	TokenPunctuation TokenKind = iota
+	// TokenIdentifier is an identifier which is not a keyword.
+	TokenIdentifier
+	// TokenNumber is a number literal.
+	TokenNumber
+	// TokenOperator is an operator, e.g. + or ==.
+	TokenOperator
+	// TokenString is a string literal of any kind, including text blocks.
+	TokenString
+	// TokenKeyword is a reserved word, e.g. local or true.
+	TokenKeyword
+	// TokenEndOfFile marks the end of the input.
+	TokenEndOfFile
+)
+
+// Token is a single lexeme of the source code.
+type Token struct {
+	Kind TokenKind
+	// Value is the text of the token. For strings it is the raw content
+	// between the quotes, without processing the escape sequences.
+	Value string
+	// Loc is the location of the whole token, including any quotes.
+	Loc ast.LocationRange
+}
+
+func (tk tokenKind) publicKind() TokenKind {
+	switch {
+	case tk <= tokenSemicolon:
+		return TokenPunctuation
+	case tk == tokenIdentifier:
+		return TokenIdentifier
+	case tk == tokenNumber:
+		return TokenNumber
+	case tk == tokenOperator:
+		return TokenOperator
+	case tk <= tokenVerbatimStringSingle:
+		return TokenString
+	case tk <= tokenTrue:
+		return TokenKeyword
+	default:
+		return TokenEndOfFile
+	}
+}
+
+// LexTokens lexes the input like Lex and returns the tokens in their public form.
+// Comments and whitespace are not returned.
+func LexTokens(diagnosticFilename ast.DiagnosticFileName, importedFilename, input string) ([]Token, error) {
+	tokens, err := Lex(diagnosticFilename, importedFilename, input)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Token, len(tokens))
+	for i, t := range tokens {
+		result[i] = Token{Kind: t.kind.publicKind(), Value: t.data, Loc: t.loc}
+	}
+	return result, nil
+}
@@ -0,0 +1,359 @@
+/*
+Copyright 2024 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package program
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// foldEnv maps the variables in scope to their constant values.
+// Variables which are bound to something else than a constant map to nil.
+type foldEnv map[ast.Identifier]ast.Node
+
+func (env foldEnv) extend(names ...ast.Identifier) foldEnv {
+	newEnv := make(foldEnv, len(env)+len(names))
+	for name, value := range env {
+		newEnv[name] = value
+	}
+	for _, name := range names {
+		newEnv[name] = nil
+	}
+	return newEnv
+}
+
+// FoldConstants simplifies a desugared AST in place by evaluating the subexpressions
+// which only depend on literals: arithmetic, comparisons and string concatenation
+// of literals, conditionals with a literal condition, and references to locals bound to literals.
+//
+// Calls std.extVar with a name present in extVars are replaced by the given string,
+// the other calls are left for the evaluation. Expressions which would fail
+// (e.g. division by zero) are never folded, so the errors are still reported when evaluating.
+//
+// The free variables of the resulting AST are analyzed again.
+func FoldConstants(node *ast.Node, extVars map[string]string, globalVars ...ast.Identifier) error {
+	env := make(foldEnv)
+	for _, v := range globalVars {
+		env[v] = nil
+	}
+	fold(node, env, extVars)
+	return analyze(*node, globalVars...)
+}
+
+func foldLocals(binds ast.LocalBinds, env foldEnv, extVars map[string]string) foldEnv {
+	names := make([]ast.Identifier, len(binds))
+	for i, bind := range binds {
+		names[i] = bind.Variable
+	}
+	// Binds can be mutually recursive, so none of them is known inside of the binds.
+	newEnv := env.extend(names...)
+	for i := range binds {
+		fold(&binds[i].Body, newEnv, extVars)
+	}
+	bodyEnv := newEnv.extend()
+	for _, bind := range binds {
+		if isFoldedLiteral(bind.Body) {
+			bodyEnv[bind.Variable] = bind.Body
+		}
+	}
+	return bodyEnv
+}
+
+func fold(node *ast.Node, env foldEnv, extVars map[string]string) {
+	switch n := (*node).(type) {
+	case *ast.Apply:
+		fold(&n.Target, env, extVars)
+		for i := range n.Arguments.Positional {
+			fold(&n.Arguments.Positional[i].Expr, env, extVars)
+		}
+		for i := range n.Arguments.Named {
+			fold(&n.Arguments.Named[i].Arg, env, extVars)
+		}
+		if value, ok := foldExtVar(n, env, extVars); ok {
+			*node = value
+		}
+	case *ast.Array:
+		for i := range n.Elements {
+			fold(&n.Elements[i].Expr, env, extVars)
+		}
+	case *ast.Binary:
+		fold(&n.Left, env, extVars)
+		fold(&n.Right, env, extVars)
+		if value, ok := foldBinary(n); ok {
+			*node = value
+		}
+	case *ast.Conditional:
+		fold(&n.Cond, env, extVars)
+		fold(&n.BranchTrue, env, extVars)
+		fold(&n.BranchFalse, env, extVars)
+		if cond, ok := n.Cond.(*ast.LiteralBoolean); ok {
+			if cond.Value {
+				*node = n.BranchTrue
+			} else {
+				*node = n.BranchFalse
+			}
+		}
+	case *ast.DesugaredObject:
+		// Field names are calculated outside of the object.
+		for i := range n.Fields {
+			fold(&n.Fields[i].Name, env, extVars)
+		}
+		newEnv := foldLocals(n.Locals, env, extVars)
+		for i := range n.Fields {
+			fold(&n.Fields[i].Body, newEnv, extVars)
+		}
+		for i := range n.Asserts {
+			fold(&n.Asserts[i], newEnv, extVars)
+		}
+	case *ast.Error:
+		fold(&n.Expr, env, extVars)
+	case *ast.Function:
+		names := make([]ast.Identifier, len(n.Parameters))
+		for i, param := range n.Parameters {
+			names[i] = param.Name
+		}
+		newEnv := env.extend(names...)
+		for i := range n.Parameters {
+			if n.Parameters[i].DefaultArg != nil {
+				fold(&n.Parameters[i].DefaultArg, newEnv, extVars)
+			}
+		}
+		fold(&n.Body, newEnv, extVars)
+	case *ast.Index:
+		fold(&n.Target, env, extVars)
+		fold(&n.Index, env, extVars)
+	case *ast.InSuper:
+		fold(&n.Index, env, extVars)
+	case *ast.Local:
+		newEnv := foldLocals(n.Binds, env, extVars)
+		fold(&n.Body, newEnv, extVars)
+	case *ast.SuperIndex:
+		fold(&n.Index, env, extVars)
+	case *ast.Unary:
+		fold(&n.Expr, env, extVars)
+		if value, ok := foldUnary(n); ok {
+			*node = value
+		}
+	case *ast.Var:
+		if value := env[n.Id]; value != nil {
+			*node = copyLiteral(value, *n.Loc())
+		}
+	}
+}
+
+// isFoldedLiteral returns true if the node is a literal of a primitive value.
+// Number literals too large to fit in a double are not, since they fail when evaluated.
+func isFoldedLiteral(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.LiteralNull, *ast.LiteralBoolean, *ast.LiteralString:
+		return true
+	case *ast.LiteralNumber:
+		_, ok := literalNumber(node)
+		return ok
+	}
+	return false
+}
+
+func copyLiteral(node ast.Node, loc ast.LocationRange) ast.Node {
+	switch node := node.(type) {
+	case *ast.LiteralNull:
+		return &ast.LiteralNull{NodeBase: ast.NodeBase{LocRange: loc}}
+	case *ast.LiteralBoolean:
+		return makeBoolean(node.Value, loc)
+	case *ast.LiteralNumber:
+		return &ast.LiteralNumber{NodeBase: ast.NodeBase{LocRange: loc}, OriginalString: node.OriginalString}
+	case *ast.LiteralString:
+		return makeString(node.Value, loc)
+	}
+	panic("not a literal")
+}
+
+func makeBoolean(value bool, loc ast.LocationRange) ast.Node {
+	return &ast.LiteralBoolean{NodeBase: ast.NodeBase{LocRange: loc}, Value: value}
+}
+
+func makeString(value string, loc ast.LocationRange) ast.Node {
+	str := makeStr(value)
+	str.LocRange = loc
+	return str
+}
+
+// literalNumber returns the value of a number literal, if it fits in a double.
+func literalNumber(node ast.Node) (float64, bool) {
+	num, ok := node.(*ast.LiteralNumber)
+	if !ok {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(num.OriginalString, 64)
+	if err != nil {
+		return 0, false
+	}
+	return value, true
+}
+
+func makeNumber(value float64, loc ast.LocationRange) (ast.Node, bool) {
+	if math.IsInf(value, 0) || math.IsNaN(value) {
+		return nil, false
+	}
+	return &ast.LiteralNumber{
+		NodeBase:       ast.NodeBase{LocRange: loc},
+		OriginalString: strconv.FormatFloat(value, 'g', -1, 64),
+	}, true
+}
+
+func foldExtVar(node *ast.Apply, env foldEnv, extVars map[string]string) (ast.Node, bool) {
+	index, ok := node.Target.(*ast.Index)
+	if !ok || len(node.Arguments.Positional) != 1 || len(node.Arguments.Named) != 0 {
+		return nil, false
+	}
+	std, ok := index.Target.(*ast.Var)
+	if !ok || std.Id != "std" {
+		return nil, false
+	}
+	if _, shadowed := env["std"]; shadowed {
+		return nil, false
+	}
+	member, ok := index.Index.(*ast.LiteralString)
+	if !ok || member.Value != "extVar" {
+		return nil, false
+	}
+	name, ok := node.Arguments.Positional[0].Expr.(*ast.LiteralString)
+	if !ok {
+		return nil, false
+	}
+	value, known := extVars[name.Value]
+	if !known {
+		return nil, false
+	}
+	return makeString(value, node.LocRange), true
+}
+
+func foldUnary(node *ast.Unary) (ast.Node, bool) {
+	switch node.Op {
+	case ast.UopNot:
+		if b, ok := node.Expr.(*ast.LiteralBoolean); ok {
+			return makeBoolean(!b.Value, node.LocRange), true
+		}
+	case ast.UopPlus:
+		if x, ok := literalNumber(node.Expr); ok {
+			return makeNumber(x, node.LocRange)
+		}
+	case ast.UopMinus:
+		if x, ok := literalNumber(node.Expr); ok {
+			return makeNumber(-x, node.LocRange)
+		}
+	}
+	return nil, false
+}
+
+func foldBinary(node *ast.Binary) (ast.Node, bool) {
+	if !isFoldedLiteral(node.Left) || !isFoldedLiteral(node.Right) {
+		return nil, false
+	}
+	loc := node.LocRange
+	switch node.Op {
+	case ast.BopManifestEqual:
+		return makeBoolean(literalsEqual(node.Left, node.Right), loc), true
+	case ast.BopManifestUnequal:
+		return makeBoolean(!literalsEqual(node.Left, node.Right), loc), true
+	}
+
+	if x, ok := literalNumber(node.Left); ok {
+		y, ok := literalNumber(node.Right)
+		if !ok {
+			return nil, false
+		}
+		switch node.Op {
+		case ast.BopPlus:
+			return makeNumber(x+y, loc)
+		case ast.BopMinus:
+			return makeNumber(x-y, loc)
+		case ast.BopMult:
+			return makeNumber(x*y, loc)
+		case ast.BopDiv:
+			if y == 0 {
+				return nil, false
+			}
+			return makeNumber(x/y, loc)
+		case ast.BopLess:
+			return makeBoolean(x < y, loc), true
+		case ast.BopLessEq:
+			return makeBoolean(x <= y, loc), true
+		case ast.BopGreater:
+			return makeBoolean(x > y, loc), true
+		case ast.BopGreaterEq:
+			return makeBoolean(x >= y, loc), true
+		}
+		return nil, false
+	}
+
+	if x, ok := node.Left.(*ast.LiteralString); ok {
+		y, ok := node.Right.(*ast.LiteralString)
+		if !ok {
+			return nil, false
+		}
+		// Strings are compared by code points, which matches the order of their UTF-8 encodings.
+		switch node.Op {
+		case ast.BopPlus:
+			return makeString(x.Value+y.Value, loc), true
+		case ast.BopLess:
+			return makeBoolean(x.Value < y.Value, loc), true
+		case ast.BopLessEq:
+			return makeBoolean(x.Value <= y.Value, loc), true
+		case ast.BopGreater:
+			return makeBoolean(x.Value > y.Value, loc), true
+		case ast.BopGreaterEq:
+			return makeBoolean(x.Value >= y.Value, loc), true
+		}
+		return nil, false
+	}
+
+	if x, ok := node.Left.(*ast.LiteralBoolean); ok {
+		y, ok := node.Right.(*ast.LiteralBoolean)
+		if !ok {
+			return nil, false
+		}
+		switch node.Op {
+		case ast.BopAnd:
+			return makeBoolean(x.Value && y.Value, loc), true
+		case ast.BopOr:
+			return makeBoolean(x.Value || y.Value, loc), true
+		}
+	}
+	return nil, false
+}
+
+func literalsEqual(a, b ast.Node) bool {
+	switch a := a.(type) {
+	case *ast.LiteralNull:
+		_, ok := b.(*ast.LiteralNull)
+		return ok
+	case *ast.LiteralBoolean:
+		b, ok := b.(*ast.LiteralBoolean)
+		return ok && a.Value == b.Value
+	case *ast.LiteralNumber:
+		x, _ := literalNumber(a)
+		y, ok := literalNumber(b)
+		return ok && x == y
+	case *ast.LiteralString:
+		b, ok := b.(*ast.LiteralString)
+		return ok && a.Value == b.Value
+	}
+	return false
+}
@@ -0,0 +1,71 @@
+package program
+
+import (
+	"testing"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+func TestFoldConstants(t *testing.T) {
+	tests := []struct {
+		name     string
+		snippet  string
+		expected ast.Node
+	}{
+		{"arithmetic", `2 * 3 + 1`, &ast.LiteralNumber{OriginalString: "7"}},
+		{"negative", `1 - 2.5`, &ast.LiteralNumber{OriginalString: "-1.5"}},
+		{"concatenation", `"a" + "b"`, &ast.LiteralString{Value: "ab"}},
+		{"comparison", `"a" < "b" && 1 >= 2`, &ast.LiteralBoolean{Value: false}},
+		{"equality", `null == null`, &ast.LiteralBoolean{Value: true}},
+		{"ext var", `std.extVar("env") + "-suffix"`, &ast.LiteralString{Value: "prod-suffix"}},
+		{"conditional", `if std.extVar("env") == "prod" then 1 else error "unreachable"`, &ast.LiteralNumber{OriginalString: "1"}},
+		{"local", `local env = std.extVar("env"); env == "dev"`, &ast.LiteralBoolean{Value: false}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node, err := SnippetToAST("", "", test.snippet)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := FoldConstants(&node, map[string]string{"env": "prod"}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if local, ok := node.(*ast.Local); ok {
+				node = local.Body
+			}
+			if !literalsEqual(node, test.expected) {
+				t.Errorf("Expected %#v, but got %#v", test.expected, node)
+			}
+		})
+	}
+}
+
+func TestFoldConstantsKeepsDynamicParts(t *testing.T) {
+	tests := []struct {
+		name    string
+		snippet string
+	}{
+		{"division by zero", `1 / 0`},
+		{"overflow", `1e308 * 10`},
+		{"unknown ext var", `std.extVar("other")`},
+		{"shadowed std", `local std = { extVar(x): x }; std.extVar("env")`},
+		{"mixed types", `1 + "a"`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node, err := SnippetToAST("", "", test.snippet)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if err := FoldConstants(&node, map[string]string{"env": "prod"}); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if local, ok := node.(*ast.Local); ok {
+				node = local.Body
+			}
+			if isFoldedLiteral(node) {
+				t.Errorf("Expected the expression not to be folded, got %#v", node)
+			}
+		})
+	}
+}
@@ -25,6 +25,9 @@ import (
 	"github.com/google/go-jsonnet/internal/parser"
 )
 
+// desugaredBop maps the operators implemented by the standard library to the functions.
+// `k in o` is std.objectHasAll(o, k), so it is true for the hidden fields as well,
+// like `k in super`.
 var desugaredBop = map[ast.BinaryOp]ast.Identifier{
 	ast.BopPercent: "mod",
 	ast.BopIn:      "objectHasAll",
@@ -39,6 +42,22 @@ func makeStr(s string) *ast.LiteralString {
 	}
 }
 
+// fieldComment returns the lines of the comments preceding the field on their own lines.
+// A comment following the previous field on the same line belongs to that field, so it is skipped.
+func fieldComment(field *ast.ObjectField) []string {
+	fodder := field.Fodder1
+	if field.Kind == ast.ObjectFieldStr {
+		fodder = *field.Expr1.OpenFodder()
+	}
+	var comment []string
+	for i, f := range fodder {
+		if f.Kind == ast.FodderParagraph || (f.Kind == ast.FodderInterstitial && i > 0) {
+			comment = append(comment, f.Comment...)
+		}
+	}
+	return comment
+}
+
 func desugarFields(nodeBase ast.NodeBase, fields *ast.ObjectFields, objLevel int) (*ast.DesugaredObject, error) {
 	for i := range *fields {
 		field := &((*fields)[i])
@@ -83,6 +102,7 @@ func desugarFields(nodeBase ast.NodeBase, fields *ast.ObjectFields, objLevel int
 				Body:      field.Expr2,
 				PlusSuper: field.SuperSugar,
 				LocRange:  field.LocRange,
+				Comment:   fieldComment(field),
 			})
 
 		case ast.ObjectFieldExpr, ast.ObjectFieldStr:
@@ -92,6 +112,7 @@ func desugarFields(nodeBase ast.NodeBase, fields *ast.ObjectFields, objLevel int
 				Body:      field.Expr2,
 				PlusSuper: field.SuperSugar,
 				LocRange:  field.LocRange,
+				Comment:   fieldComment(field),
 			})
 
 		case ast.ObjectLocal:
@@ -0,0 +1,117 @@
+/*
+Copyright 2024 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package program
+
+import (
+	"sort"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/internal/parser"
+)
+
+// ExtVarNames returns the sorted names of the external variables used by a desugared AST
+// in calls of std.extVar with a literal name. Dynamic is true if std.extVar is called
+// with a name which is not a literal, or used in any other way, e.g. passed to a function,
+// so the returned names may be incomplete. The uses of a local variable named std
+// are ignored.
+func ExtVarNames(node ast.Node) (names []string, dynamic bool) {
+	found := make(map[string]struct{})
+	findExtVars(node, false, found, &dynamic)
+	for name := range found {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, dynamic
+}
+
+func findExtVars(node ast.Node, stdShadowed bool, found map[string]struct{}, dynamic *bool) {
+	if node == nil {
+		return
+	}
+	switch node := node.(type) {
+	case *ast.Apply:
+		if !stdShadowed && isStdExtVar(node.Target) {
+			if name, ok := literalExtVarName(node.Arguments); ok {
+				found[name] = struct{}{}
+			} else {
+				*dynamic = true
+			}
+			for _, arg := range node.Arguments.Positional {
+				findExtVars(arg.Expr, stdShadowed, found, dynamic)
+			}
+			for _, arg := range node.Arguments.Named {
+				findExtVars(arg.Arg, stdShadowed, found, dynamic)
+			}
+			return
+		}
+	case *ast.Index:
+		if !stdShadowed && isStdExtVar(node) {
+			*dynamic = true
+			return
+		}
+	case *ast.Local:
+		stdShadowed = stdShadowed || bindsStd(node.Binds)
+	case *ast.DesugaredObject:
+		stdShadowed = stdShadowed || bindsStd(node.Locals)
+	case *ast.Function:
+		for _, param := range node.Parameters {
+			stdShadowed = stdShadowed || param.Name == "std"
+		}
+	}
+	for _, child := range parser.Children(node) {
+		findExtVars(child, stdShadowed, found, dynamic)
+	}
+}
+
+func isStdExtVar(node ast.Node) bool {
+	index, ok := node.(*ast.Index)
+	if !ok {
+		return false
+	}
+	std, ok := index.Target.(*ast.Var)
+	if !ok || std.Id != "std" {
+		return false
+	}
+	member, ok := index.Index.(*ast.LiteralString)
+	return ok && member.Value == "extVar"
+}
+
+func literalExtVarName(args ast.Arguments) (string, bool) {
+	var arg ast.Node
+	switch {
+	case len(args.Positional) == 1 && len(args.Named) == 0:
+		arg = args.Positional[0].Expr
+	case len(args.Positional) == 0 && len(args.Named) == 1 && args.Named[0].Name == "x":
+		arg = args.Named[0].Arg
+	default:
+		return "", false
+	}
+	name, ok := arg.(*ast.LiteralString)
+	if !ok {
+		return "", false
+	}
+	return name.Value, true
+}
+
+func bindsStd(binds ast.LocalBinds) bool {
+	for _, bind := range binds {
+		if bind.Variable == "std" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+/*
+Copyright 2024 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package program
+
+import (
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/internal/errors"
+	"github.com/google/go-jsonnet/internal/parser"
+)
+
+// Preamble is a sequence of local declarations, e.g. "local a = 1; local b = a + 1;",
+// in the scope of which the snippets are evaluated. It is parsed as a separate file,
+// so the locations in the snippets are not affected by it.
+type Preamble struct {
+	// Raw AST of the nested locals, the innermost body is a placeholder for the snippet.
+	node ast.Node
+}
+
+// ParsePreamble parses and checks the preamble code. The code can refer to the global
+// variables and to the locals declared before.
+func ParsePreamble(diagnosticFilename ast.DiagnosticFileName, code string, globalVars ...ast.Identifier) (*Preamble, error) {
+	// The placeholder is on its own line, so that a trailing comment does not hide it.
+	node, _, err := parser.SnippetToRawAST(diagnosticFilename, "", code+"\nnull")
+	if err != nil {
+		return nil, err
+	}
+	for current := node; ; {
+		if local, ok := current.(*ast.Local); ok {
+			current = local.Body
+			continue
+		}
+		if _, ok := current.(*ast.LiteralNull); !ok {
+			return nil, errors.MakeStaticError("the preamble can only consist of local declarations", *current.Loc())
+		}
+		break
+	}
+	checked := ast.Clone(node)
+	if err := PreprocessAst(&checked, globalVars...); err != nil {
+		return nil, err
+	}
+	return &Preamble{node: node}, nil
+}
+
+// wrap returns the raw AST of the snippet in the scope of the preamble locals.
+// A nil preamble returns the snippet unchanged.
+func (p *Preamble) wrap(node ast.Node) ast.Node {
+	if p == nil {
+		return node
+	}
+	wrapped := ast.Clone(p.node)
+	current := wrapped
+	for {
+		local, ok := current.(*ast.Local)
+		if !ok {
+			return node
+		}
+		if _, ok := local.Body.(*ast.Local); !ok {
+			local.Body = node
+			return wrapped
+		}
+		current = local.Body
+	}
+}
@@ -2,20 +2,65 @@
 package program
 
 import (
+	"time"
+
 	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/internal/parser"
 )
 
 // SnippetToAST converts a Jsonnet code snippet to a desugared and analyzed AST.
 func SnippetToAST(diagnosticFilename ast.DiagnosticFileName, importedFilename, snippet string, globalVars ...ast.Identifier) (ast.Node, error) {
-	node, _, err := parser.SnippetToRawAST(diagnosticFilename, importedFilename, snippet)
+	return SnippetWithPreambleToAST(diagnosticFilename, importedFilename, snippet, nil, globalVars...)
+}
+
+// SnippetWithPreambleToAST is like SnippetToAST, but the snippet is in the scope of the locals
+// of the preamble, which can be nil.
+func SnippetWithPreambleToAST(diagnosticFilename ast.DiagnosticFileName, importedFilename, snippet string, preamble *Preamble, globalVars ...ast.Identifier) (ast.Node, error) {
+	node, _, err := SnippetToASTTimed(diagnosticFilename, importedFilename, snippet, preamble, globalVars...)
+	return node, err
+}
+
+// Timings holds the time spent in each step of SnippetToAST.
+type Timings struct {
+	Lexing         time.Duration
+	Parsing        time.Duration
+	Desugaring     time.Duration
+	StaticAnalysis time.Duration
+}
+
+// SnippetToASTTimed is like SnippetWithPreambleToAST, but it also measures how long each step took.
+func SnippetToASTTimed(diagnosticFilename ast.DiagnosticFileName, importedFilename, snippet string, preamble *Preamble, globalVars ...ast.Identifier) (ast.Node, Timings, error) {
+	var timings Timings
+
+	start := time.Now()
+	tokens, err := parser.Lex(diagnosticFilename, importedFilename, snippet)
+	timings.Lexing = time.Since(start)
 	if err != nil {
-		return nil, err
+		return nil, timings, err
 	}
-	if err := PreprocessAst(&node, globalVars...); err != nil {
-		return nil, err
+
+	start = time.Now()
+	node, _, err := parser.Parse(tokens)
+	timings.Parsing = time.Since(start)
+	if err != nil {
+		return nil, timings, err
+	}
+	node = preamble.wrap(node)
+
+	start = time.Now()
+	err = desugarAST(&node)
+	timings.Desugaring = time.Since(start)
+	if err != nil {
+		return nil, timings, err
+	}
+
+	start = time.Now()
+	err = analyze(node, globalVars...)
+	timings.StaticAnalysis = time.Since(start)
+	if err != nil {
+		return nil, timings, err
 	}
-	return node, nil
+	return node, timings, nil
 }
 
 func PreprocessAst(node *ast.Node, globalVars ...ast.Identifier) error {
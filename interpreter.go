@@ -18,15 +18,19 @@ package jsonnet
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"math"
 	"reflect"
 	"sort"
 	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/astgen"
+	"github.com/google/go-jsonnet/internal/parser"
 )
 
 // TODO(sbarzowski) use it as a pointer in most places b/c it can sometimes be shared
@@ -49,8 +53,8 @@ func makeEnvironment(upValues bindingFrame, sb selfBinding) environment {
 	}
 }
 
-func (i *interpreter) getCurrentStackTrace() []traceFrame {
-	var result []traceFrame
+func (i *interpreter) getCurrentStackTrace() []TraceFrame {
+	var result []TraceFrame
 	for _, f := range i.stack.stack {
 		if f.cleanEnv {
 			result = append(result, traceElementToTraceFrame(f.trace))
@@ -247,6 +251,17 @@ type interpreter struct {
 	// Native functions
 	nativeFuncs map[string]*NativeFunction
 
+	// Provides native functions which are not in nativeFuncs
+	nativeResolver NativeResolver
+	// The functions provided by nativeResolver, it is called once per name
+	resolvedNatives map[string]*NativeFunction
+
+	// Functions implemented in Go which are added to the std object
+	stdFuncs map[string]*NativeFunction
+
+	// Messages of the std members which fail when used
+	deprecatedStd map[string]string
+
 	// A part of std object common to all files
 	baseStd *valueObject
 
@@ -255,11 +270,81 @@ type interpreter struct {
 	// Keeps imports
 	importCache *importCache
 
+	// Kinds of imports which are allowed
+	importPolicy ImportPolicy
+
+	// What imports do when the file is not found
+	missingImportPolicy MissingImportPolicy
+
+	// What std.extVar does when the variable is not defined
+	missingExtVarPolicy MissingExtVarPolicy
+
+	// Maximum number of distinct files imported in a single evaluation, 0 means no limit
+	maxImports int
+
+	// Files imported in the current evaluation, used for enforcing maxImports
+	importedFiles map[string]struct{}
+
+	// Maximum number of elements or fields produced by a single comprehension, 0 means no limit
+	maxComprehensionSize int
+
+	// Maximum length of the arrays and strings built by std.makeArray, std.range and std.repeat, 0 means no limit
+	maxArrayLength int
+
+	// Maximum nesting of the arrays and objects in the manifested values, 0 means no limit
+	maxManifestDepth int
+
+	// Current nesting of the manifested value, used for enforcing maxManifestDepth
+	manifestDepth int
+
+	// Maximum nesting of the objects created during the evaluation, 0 means no limit
+	maxObjectNesting int
+
+	// Nesting of the object whose field is currently evaluated, 0 outside of any field
+	objectNesting int
+
+	// Whether the location of every evaluated node is skipped, making the stack traces coarse
+	noLocationTracking bool
+
 	// Output stream for trace() for
 	traceOut io.Writer
 
+	// Pauses the evaluation at breakpoints, nil if not debugging
+	debugger *debugger
+
+	// Whether the std variable is unavailable to the evaluated code
+	stdlibDisabled bool
+
+	// Whether the comments preceding the object fields are added to the JSON output
+	emitComments bool
+
+	// Separator of the lines in the output, replacing every newline
+	lineSeparator string
+
+	// Field order and whitespace of the JSON output
+	outputStyle outputStyle
+
+	// Whether a null anywhere in the output is an error
+	rejectNullOutput bool
+
+	// Convert the values of custom Go types returned by native functions
+	valueMarshalers map[reflect.Type]ValueMarshaler
+
 	notifier Notifier
 
+	// Transforms the leaf values of the output, nil when not set
+	fieldTransformHook FieldTransformHook
+
+	// Called before each native function call, nil when not set
+	nativeCallHook NativeCallHook
+
+	// Whether the failed std.assertEqual checks are collected instead of failing the evaluation.
+	// See VM.EvaluateAssertEqualReport.
+	collectAssertEqualFailures bool
+
+	// Failed std.assertEqual checks, when they are collected
+	assertEqualFailures []AssertEqualFailure
+
 	// Current stack. It is used for:
 	// 1) Keeping environment (object we're in, variables)
 	// 2) Diagnostic information in case of failure
@@ -290,15 +375,31 @@ func (i *interpreter) newCall(env environment, trimmable bool) error {
 	return nil
 }
 
+// resetStack discards anything left on the stack, e.g. by a failed evaluation,
+// so that the interpreter can be safely reused for another one.
+func (i *interpreter) resetStack() {
+	i.stack = makeCallStack(i.stack.limit)
+}
+
 func (i *interpreter) evaluate(a ast.Node, tc tailCallStatus) (value, error) {
-	trace := traceElement{
-		loc:     a.Loc(),
-		context: a.Context(),
-	}
 	oldTrace := i.stack.currentTrace
-	i.stack.clearCurrentTrace()
-	i.stack.setCurrentTrace(trace)
-	defer func() { i.stack.clearCurrentTrace(); i.stack.setCurrentTrace(oldTrace) }()
+	// Without the location tracking, the location is only set when entering a new frame,
+	// which is required for the stack trace.
+	if !i.noLocationTracking || oldTrace == (traceElement{}) {
+		trace := traceElement{
+			loc:     a.Loc(),
+			context: a.Context(),
+		}
+		i.stack.clearCurrentTrace()
+		i.stack.setCurrentTrace(trace)
+		defer func() { i.stack.clearCurrentTrace(); i.stack.setCurrentTrace(oldTrace) }()
+	}
+
+	if i.debugger != nil {
+		i.debugger.depth++
+		defer func() { i.debugger.depth-- }()
+		i.debugger.step(i, a, oldTrace.loc)
+	}
 
 	switch node := a.(type) {
 	case *ast.Array:
@@ -358,6 +459,9 @@ func (i *interpreter) evaluate(a ast.Node, tc tailCallStatus) (value, error) {
 			if err != nil {
 				return nil, err
 			}
+			if err := checkBinaryOperands(i, node.Op, left, right); err != nil {
+				return nil, err
+			}
 			// TODO(dcunnin): The double dereference here is probably not necessary.
 			builtin := bopBuiltins[node.Op]
 			return builtin.function(i, left, right)
@@ -394,6 +498,7 @@ func (i *interpreter) evaluate(a ast.Node, tc tailCallStatus) (value, error) {
 	case *ast.DesugaredObject:
 		// Evaluate all the field names.  Check for null, dups, etc.
 		fields := make(simpleObjectFieldMap, len(node.Fields))
+		fieldOrder := make([]string, 0, len(node.Fields))
 		for _, field := range node.Fields {
 			fieldNameValue, err := i.evaluate(field.Name, nonTailCall)
 			if err != nil {
@@ -417,7 +522,11 @@ func (i *interpreter) evaluate(a ast.Node, tc tailCallStatus) (value, error) {
 			if field.PlusSuper {
 				f = &plusSuperUnboundField{f}
 			}
+			if i.emitComments && len(field.Comment) > 0 {
+				f = &commentedUnboundField{f, field.Comment}
+			}
 			fields[fieldName] = simpleObjectField{f, field.Hide}
+			fieldOrder = append(fieldOrder, fieldName)
 		}
 		var asserts []unboundField
 		for _, assert := range node.Asserts {
@@ -427,8 +536,15 @@ func (i *interpreter) evaluate(a ast.Node, tc tailCallStatus) (value, error) {
 		for _, local := range node.Locals {
 			locals = append(locals, objectLocal{name: local.Variable, node: local.Body})
 		}
+		nesting := i.objectNesting + 1
+		if err := i.checkObjectNesting(nesting); err != nil {
+			return nil, err
+		}
 		upValues := i.stack.capture(node.FreeVariables())
-		return makeValueSimpleObject(upValues, fields, asserts, locals), nil
+		obj := makeValueSimpleObject(upValues, fields, asserts, locals)
+		obj.uncached.(*simpleObject).fieldOrder = fieldOrder
+		obj.nesting = nesting
+		return obj, nil
 
 	case *ast.Error:
 		msgVal, err := i.evaluate(node.Expr, nonTailCall)
@@ -484,14 +600,23 @@ func (i *interpreter) evaluate(a ast.Node, tc tailCallStatus) (value, error) {
 		return nil, i.Error(fmt.Sprintf("Value non indexable: %v", reflect.TypeOf(targetValue)))
 
 	case *ast.Import:
+		if !i.importPolicy.allows("import") {
+			return nil, i.importDisabledError("import", node.File.Value)
+		}
 		codePath := node.Loc().FileName
 		return i.importCache.importCode(codePath, node.File.Value, i)
 
 	case *ast.ImportStr:
+		if !i.importPolicy.allows("importstr") {
+			return nil, i.importDisabledError("importstr", node.File.Value)
+		}
 		codePath := node.Loc().FileName
 		return i.importCache.importString(codePath, node.File.Value, i)
 
 	case *ast.ImportBin:
+		if !i.importPolicy.allows("importbin") {
+			return nil, i.importDisabledError("importbin", node.File.Value)
+		}
 		codePath := node.Loc().FileName
 		return i.importCache.importBinary(codePath, node.File.Value, i)
 
@@ -672,6 +797,15 @@ func (i *interpreter) manifestJSON(v value) (out interface{}, err error) {
 	}
 	stackSize := len(i.stack.stack)
 	defer i.stack.popIfExists(stackSize)
+
+	if t := v.getType(); t == arrayType || t == objectType {
+		i.manifestDepth++
+		defer func() { i.manifestDepth-- }()
+		if err := i.checkManifestDepth(i.manifestDepth); err != nil {
+			return nil, err
+		}
+	}
+
 	defer func() {
 		// Notify on generated value
 		if functions := v.generatedByNativeFunctions(); len(functions) > 0 && i.notifier != nil && err == nil {
@@ -705,6 +839,97 @@ func (i *interpreter) manifestJSON(v value) (out interface{}, err error) {
 	return i.doManifestJSON(v)
 }
 
+// manifestOutputJSON is like manifestJSON, but the leaf values are transformed
+// by the hook set by VM.SetFieldTransformHook. It is used for the output.
+func (i *interpreter) manifestOutputJSON(v value) (interface{}, error) {
+	out, err := i.manifestJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	if i.fieldTransformHook != nil {
+		out = transformLeaves(i.fieldTransformHook, nil, out)
+	}
+	if i.rejectNullOutput {
+		if pointer, found := findNull("", out); found {
+			if pointer == "" {
+				return nil, i.Error("null is not allowed in the output")
+			}
+			return nil, i.Error(fmt.Sprintf("null is not allowed in the output, found at %s", pointer))
+		}
+	}
+	return out, nil
+}
+
+// findNull returns the JSON pointer of the first null in a manifested value,
+// visiting the fields of the objects in the sorted order.
+func findNull(pointer string, v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return pointer, true
+	case []interface{}:
+		for index, elem := range v {
+			if found, ok := findNull(pointer+pointerToken(strconv.Itoa(index)), elem); ok {
+				return found, true
+			}
+		}
+	case map[string]interface{}:
+		fieldNames := make([]string, 0, len(v))
+		for name := range v {
+			fieldNames = append(fieldNames, name)
+		}
+		sort.Strings(fieldNames)
+		for _, name := range fieldNames {
+			if found, ok := findNull(pointer+pointerToken(name), v[name]); ok {
+				return found, true
+			}
+		}
+	}
+	return "", false
+}
+
+// transformLeaves replaces the leaf values of a manifested value with the results of the hook.
+// The fields of the objects are visited in the sorted order.
+func transformLeaves(hook FieldTransformHook, path []interface{}, v interface{}) interface{} {
+	switch v := v.(type) {
+	case []interface{}:
+		for index, elem := range v {
+			v[index] = transformLeaves(hook, append(path, ArrayIndexStep{Index: index}), elem)
+		}
+		return v
+	case map[string]interface{}:
+		fieldNames := make([]string, 0, len(v))
+		for name := range v {
+			fieldNames = append(fieldNames, name)
+		}
+		sort.Strings(fieldNames)
+		for _, name := range fieldNames {
+			v[name] = transformLeaves(hook, append(path, ObjectFieldStep{Field: name}), v[name])
+		}
+		return v
+	default:
+		// The hook gets its own copy, the path is reused for the other leaves.
+		return hook(append([]interface{}(nil), path...), v)
+	}
+}
+
+// checkManifestDepth fails when the nesting of the arrays and objects being manifested
+// exceeds the limit set by VM.SetMaxManifestDepth.
+func (i *interpreter) checkManifestDepth(depth int) error {
+	if i.maxManifestDepth > 0 && depth > i.maxManifestDepth {
+		return i.Error(fmt.Sprintf("maximum manifestation depth exceeded (%d)", i.maxManifestDepth))
+	}
+	return nil
+}
+
+// checkObjectNesting fails when the nesting of an object created during the evaluation
+// exceeds the limit set by VM.SetMaxObjectNesting.
+func (i *interpreter) checkObjectNesting(nesting int) error {
+	if i.maxObjectNesting > 0 && nesting > i.maxObjectNesting {
+		return i.Error(fmt.Sprintf("maximum object nesting exceeded (%d)", i.maxObjectNesting))
+	}
+	return nil
+}
+
 func (i *interpreter) doManifestJSON(v value) (out interface{}, err error) {
 	switch v := v.(type) {
 
@@ -748,7 +973,6 @@ func (i *interpreter) doManifestJSON(v value) (out interface{}, err error) {
 
 	case *valueObject:
 		fieldNames := objectFields(v, withoutHidden)
-		sort.Strings(fieldNames)
 
 		msg := ast.MakeLocationRangeMessage("Checking object assertions")
 		i.stack.setCurrentTrace(traceElement{
@@ -795,14 +1019,79 @@ func (i *interpreter) doManifestJSON(v value) (out interface{}, err error) {
 	}
 }
 
-func serializeJSON(v interface{}, multiline bool, indent string, buf *bytes.Buffer) {
+// sortFieldNames sorts the field names of a manifested object. With the natural
+// key order, if all the names consist only of ASCII digits, they are sorted by
+// their numeric value, and the names with the same value, like "1" and "01", are
+// sorted lexically. Otherwise, or without the natural order, they are sorted lexically.
+func sortFieldNames(fieldNames []string, naturalKeyOrder bool) {
+	if !naturalKeyOrder || !allDigits(fieldNames) {
+		sort.Strings(fieldNames)
+		return
+	}
+	sort.Slice(fieldNames, func(a, b int) bool {
+		numA := strings.TrimLeft(fieldNames[a], "0")
+		numB := strings.TrimLeft(fieldNames[b], "0")
+		if len(numA) != len(numB) {
+			return len(numA) < len(numB)
+		}
+		if numA != numB {
+			return numA < numB
+		}
+		return fieldNames[a] < fieldNames[b]
+	})
+}
+
+func allDigits(names []string) bool {
+	for _, name := range names {
+		if name == "" {
+			return false
+		}
+		for _, r := range name {
+			if r < '0' || r > '9' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// outputStyle describes the field order and the whitespace of the serialized JSON.
+// The zero value is the default style.
+type outputStyle struct {
+	// Whether the numeric-looking keys are sorted by their value
+	naturalKeyOrder bool
+
+	// Whether the empty arrays are written as "[]" instead of "[ ]"
+	noArrayPadding bool
+
+	// Whether the empty objects are written as "{}" instead of "{ }"
+	noObjectPadding bool
+}
+
+// emptyArray returns the representation of an empty array in the style.
+func (style outputStyle) emptyArray() string {
+	if style.noArrayPadding {
+		return "[]"
+	}
+	return "[ ]"
+}
+
+// emptyObject returns the representation of an empty object in the style.
+func (style outputStyle) emptyObject() string {
+	if style.noObjectPadding {
+		return "{}"
+	}
+	return "{ }"
+}
+
+func serializeJSON(v interface{}, multiline bool, indent string, style outputStyle, buf *bytes.Buffer) {
 	switch v := v.(type) {
 	case nil:
 		buf.WriteString("null")
 
 	case []interface{}:
 		if len(v) == 0 {
-			buf.WriteString("[ ]")
+			buf.WriteString(style.emptyArray())
 		} else {
 			var prefix string
 			var indent2 string
@@ -816,7 +1105,7 @@ func serializeJSON(v interface{}, multiline bool, indent string, buf *bytes.Buff
 			for _, elem := range v {
 				buf.WriteString(prefix)
 				buf.WriteString(indent2)
-				serializeJSON(elem, multiline, indent2, buf)
+				serializeJSON(elem, multiline, indent2, style, buf)
 				if multiline {
 					prefix = ",\n"
 				} else {
@@ -845,10 +1134,10 @@ func serializeJSON(v interface{}, multiline bool, indent string, buf *bytes.Buff
 		for name := range v {
 			fieldNames = append(fieldNames, name)
 		}
-		sort.Strings(fieldNames)
+		sortFieldNames(fieldNames, style.naturalKeyOrder)
 
 		if len(fieldNames) == 0 {
-			buf.WriteString("{ }")
+			buf.WriteString(style.emptyObject())
 		} else {
 			var prefix string
 			var indent2 string
@@ -868,7 +1157,7 @@ func serializeJSON(v interface{}, multiline bool, indent string, buf *bytes.Buff
 				buf.WriteString(unparseString(fieldName))
 				buf.WriteString(": ")
 
-				serializeJSON(fieldVal, multiline, indent2, buf)
+				serializeJSON(fieldVal, multiline, indent2, style, buf)
 
 				if multiline {
 					prefix = ",\n"
@@ -892,13 +1181,177 @@ func serializeJSON(v interface{}, multiline bool, indent string, buf *bytes.Buff
 	}
 }
 
+// serializeJsonnet renders a value in the standard Go JSON representation
+// as Jsonnet source code, in the style of jsonnetfmt.
+func serializeJsonnet(v interface{}, indent string, buf *bytes.Buffer) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteString("null")
+
+	case bool:
+		if v {
+			buf.WriteString("true")
+		} else {
+			buf.WriteString("false")
+		}
+
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("cannot represent %v in Jsonnet", v)
+		}
+		buf.WriteString(unparseNumber(v))
+
+	case string:
+		buf.WriteString("'" + parser.StringEscape(v, true) + "'")
+
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		indent2 := indent + "  "
+		buf.WriteString("[\n")
+		for _, elem := range v {
+			buf.WriteString(indent2)
+			if err := serializeJsonnet(elem, indent2, buf); err != nil {
+				return err
+			}
+			buf.WriteString(",\n")
+		}
+		buf.WriteString(indent)
+		buf.WriteString("]")
+
+	case map[string]interface{}:
+		if len(v) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		fieldNames := make([]string, 0, len(v))
+		for name := range v {
+			fieldNames = append(fieldNames, name)
+		}
+		sort.Strings(fieldNames)
+		indent2 := indent + "  "
+		buf.WriteString("{\n")
+		for _, fieldName := range fieldNames {
+			buf.WriteString(indent2)
+			if parser.IsValidIdentifier(fieldName) {
+				buf.WriteString(fieldName)
+			} else {
+				buf.WriteString("'" + parser.StringEscape(fieldName, true) + "'")
+			}
+			buf.WriteString(": ")
+			if err := serializeJsonnet(v[fieldName], indent2, buf); err != nil {
+				return err
+			}
+			buf.WriteString(",\n")
+		}
+		buf.WriteString(indent)
+		buf.WriteString("}")
+
+	default:
+		return fmt.Errorf("unsupported value for serialization %#+v", v)
+	}
+	return nil
+}
+
 func (i *interpreter) manifestAndSerializeJSON(
 	buf *bytes.Buffer, v value, multiline bool, indent string) error {
 	manifested, err := i.manifestJSON(v)
 	if err != nil {
 		return err
 	}
-	serializeJSON(manifested, multiline, indent, buf)
+	serializeJSON(manifested, multiline, indent, outputStyle{}, buf)
+	return nil
+}
+
+// objectFieldComment returns the comment of the field which the object uses,
+// or of the field it overrides if that one has none.
+func objectFieldComment(obj *valueObject, fieldName string) []string {
+	for depth := 0; depth < obj.uncached.inheritanceSize(); depth++ {
+		found, field, _, _, foundAt := findField(obj.uncached, depth, fieldName)
+		if !found {
+			return nil
+		}
+		if commented, ok := field.field.(*commentedUnboundField); ok {
+			return commented.comment
+		}
+		depth = foundAt
+	}
+	return nil
+}
+
+// serializeJSONC serializes the manifested value v like serializeJSON with a multiline output.
+// The value val it was manifested from is used for finding the comments of the object fields,
+// its parts were already evaluated, so they are not evaluated again.
+func (i *interpreter) serializeJSONC(v interface{}, val value, indent string, buf *bytes.Buffer) error {
+	switch v := v.(type) {
+	case []interface{}:
+		if len(v) == 0 {
+			buf.WriteString(i.outputStyle.emptyArray())
+			return nil
+		}
+		arr := val.(*valueArray)
+		indent2 := indent + "   "
+		prefix := "[\n"
+		for index, elem := range v {
+			elemVal, err := i.evaluatePV(arr.elements[index])
+			if err != nil {
+				return err
+			}
+			buf.WriteString(prefix)
+			buf.WriteString(indent2)
+			if err := i.serializeJSONC(elem, elemVal, indent2, buf); err != nil {
+				return err
+			}
+			prefix = ",\n"
+		}
+		buf.WriteString("\n")
+		buf.WriteString(indent)
+		buf.WriteString("]")
+
+	case map[string]interface{}:
+		if len(v) == 0 {
+			buf.WriteString(i.outputStyle.emptyObject())
+			return nil
+		}
+		obj := val.(*valueObject)
+		fieldNames := make([]string, 0, len(v))
+		for name := range v {
+			fieldNames = append(fieldNames, name)
+		}
+		sortFieldNames(fieldNames, i.outputStyle.naturalKeyOrder)
+		indent2 := indent + "   "
+		prefix := "{\n"
+		for _, fieldName := range fieldNames {
+			fieldVal, err := obj.index(i, fieldName)
+			if err != nil {
+				return err
+			}
+			buf.WriteString(prefix)
+			for _, line := range objectFieldComment(obj, fieldName) {
+				if strings.HasPrefix(line, "#") {
+					line = "//" + line[1:]
+				}
+				buf.WriteString(indent2)
+				buf.WriteString(line)
+				buf.WriteString("\n")
+			}
+			buf.WriteString(indent2)
+			buf.WriteString(unparseString(fieldName))
+			buf.WriteString(": ")
+			if err := i.serializeJSONC(v[fieldName], fieldVal, indent2, buf); err != nil {
+				return err
+			}
+			prefix = ",\n"
+		}
+		buf.WriteString("\n")
+		buf.WriteString(indent)
+		buf.WriteString("}")
+
+	default:
+		serializeJSON(v, true, indent, i.outputStyle, buf)
+	}
 	return nil
 }
 
@@ -915,13 +1368,20 @@ func (i *interpreter) manifestString(buf *bytes.Buffer, v value) error {
 
 func (i *interpreter) manifestAndSerializeMulti(v value, stringOutputMode bool) (r map[string]string, err error) {
 	r = make(map[string]string)
-	json, err := i.manifestJSON(v)
+	json, err := i.manifestOutputJSON(v)
 	if err != nil {
 		return r, err
 	}
 	switch json := json.(type) {
 	case map[string]interface{}:
-		for filename, fileJSON := range json {
+		// Go through the files in a stable order, so that the errors are deterministic.
+		filenames := make([]string, 0, len(json))
+		for filename := range json {
+			filenames = append(filenames, filename)
+		}
+		sort.Strings(filenames)
+		for _, filename := range filenames {
+			fileJSON := json[filename]
 			if stringOutputMode {
 				switch val := fileJSON.(type) {
 				case string:
@@ -933,7 +1393,7 @@ func (i *interpreter) manifestAndSerializeMulti(v value, stringOutputMode bool)
 				}
 			} else {
 				var buf bytes.Buffer
-				serializeJSON(fileJSON, true, "", &buf)
+				serializeJSON(fileJSON, true, "", i.outputStyle, &buf)
 				buf.WriteString("\n")
 				r[filename] = buf.String()
 			}
@@ -949,7 +1409,7 @@ func (i *interpreter) manifestAndSerializeMulti(v value, stringOutputMode bool)
 
 func (i *interpreter) manifestAndSerializeYAMLStream(v value) (r []string, err error) {
 	r = make([]string, 0)
-	json, err := i.manifestJSON(v)
+	json, err := i.manifestOutputJSON(v)
 	if err != nil {
 		return r, err
 	}
@@ -957,7 +1417,7 @@ func (i *interpreter) manifestAndSerializeYAMLStream(v value) (r []string, err e
 	case []interface{}:
 		for _, doc := range json {
 			var buf bytes.Buffer
-			serializeJSON(doc, true, "", &buf)
+			serializeJSON(doc, true, "", i.outputStyle, &buf)
 			buf.WriteString("\n")
 			r = append(r, buf.String())
 		}
@@ -989,9 +1449,28 @@ func jsonToValue(i *interpreter, v interface{}) (value, error) {
 	case bool:
 		return makeValueBoolean(v), nil
 	case int, int8, int16, int32, int64:
-		return makeDoubleCheck(i, v.(float64))
+		return makeDoubleCheck(i, float64(reflect.ValueOf(v).Int()))
 	case float64:
 		return makeDoubleCheck(i, v)
+	case json.Number:
+		f, err := strconv.ParseFloat(string(v), 64)
+		if err != nil {
+			return nil, i.Error(fmt.Sprintf("Not a valid JSON number: %v", v))
+		}
+		return makeDoubleCheck(i, f)
+
+	case json.RawMessage:
+		// The numbers are parsed directly to float64, without any intermediate conversions.
+		decoder := json.NewDecoder(bytes.NewReader(v))
+		decoder.UseNumber()
+		var parsed interface{}
+		if err := decoder.Decode(&parsed); err != nil {
+			return nil, i.Error(fmt.Sprintf("failed to parse JSON: %v", err))
+		}
+		if _, err := decoder.Token(); err != io.EOF {
+			return nil, i.Error("failed to parse JSON: unexpected data after the value")
+		}
+		return jsonToValue(i, parsed)
 
 	case map[string]interface{}:
 		fieldMap := map[string]value{}
@@ -1008,6 +1487,16 @@ func jsonToValue(i *interpreter, v interface{}) (value, error) {
 		return makeValueString(v), nil
 
 	default:
+		if marshal, ok := i.valueMarshalers[reflect.TypeOf(v)]; ok {
+			converted, err := marshal(v)
+			if err != nil {
+				return nil, i.Error(fmt.Sprintf("cannot convert a value of type %T: %v", v, err))
+			}
+			if reflect.TypeOf(converted) == reflect.TypeOf(v) {
+				return nil, i.Error(fmt.Sprintf("the marshaler of type %T returned a value of the same type", v))
+			}
+			return jsonToValue(i, converted)
+		}
 		return nil, i.Error(fmt.Sprintf("Not a json type: %#+v", v))
 	}
 }
@@ -1045,6 +1534,29 @@ func (i *interpreter) Error(s string) error {
 	return err
 }
 
+func (i *interpreter) importDisabledError(kind string, path string) error {
+	return i.Error(fmt.Sprintf("imports are disabled: %s %s is not allowed", kind, unparseString(path)))
+}
+
+// countImport records a file imported in the current evaluation
+// and fails if it exceeds the limit on the number of imported files.
+func (i *interpreter) countImport(foundAt string) error {
+	if i.maxImports <= 0 {
+		return nil
+	}
+	if _, imported := i.importedFiles[foundAt]; imported {
+		return nil
+	}
+	if len(i.importedFiles) >= i.maxImports {
+		return i.Error(fmt.Sprintf("max number of imported files exceeded (%d) when importing %s", i.maxImports, unparseString(foundAt)))
+	}
+	if i.importedFiles == nil {
+		i.importedFiles = make(map[string]struct{})
+	}
+	i.importedFiles[foundAt] = struct{}{}
+	return nil
+}
+
 func (i *interpreter) typeErrorSpecific(bad value, good value) error {
 	return i.Error(
 		fmt.Sprintf("Unexpected type %v, expected %v", bad.getType().name, good.getType().name),
@@ -1208,48 +1720,101 @@ func (i *interpreter) evaluateObject(pv potentialValue) (*valueObject, error) {
 	return i.getObject(v)
 }
 
-func buildStdObject(i *interpreter) (*valueObject, error) {
-	objVal, err := evaluateStd(i)
-	if err != nil {
-		return nil, err
+var (
+	sharedStdOnce sync.Once
+	// The fields and locals of the std object, which are the same for all the interpreters,
+	// so they are built only once. They must not be modified.
+	sharedStdFields simpleObjectFieldMap
+	sharedStdLocals []objectLocal
+)
+
+// buildSharedStd builds the fields of the std object from the precompiled AST
+// of the Jsonnet part of the standard library and from the builtins.
+func buildSharedStd() {
+	node := astgen.StdAst
+	sharedStdFields = make(simpleObjectFieldMap, len(node.Fields)+len(funcBuiltins))
+	for _, field := range node.Fields {
+		// The std object has only fixed field names.
+		name := field.Name.(*ast.LiteralString).Value
+		sharedStdFields[name] = simpleObjectField{&codeUnboundField{field.Body}, field.Hide}
+	}
+	for _, local := range node.Locals {
+		sharedStdLocals = append(sharedStdLocals, objectLocal{name: local.Variable, node: local.Body})
 	}
-	obj := objVal.(*valueObject).uncached.(*simpleObject)
-	builtinFields := map[string]unboundField{}
 	for key, ec := range funcBuiltins {
 		function := valueFunction{ec: ec} // TODO(sbarzowski) better way to build function value
-		builtinFields[key] = &readyValue{&function}
+		sharedStdFields[key] = simpleObjectField{&readyValue{&function}, ast.ObjectFieldHidden}
 	}
+	assertEqual := sharedStdFields["assertEqual"]
+	sharedStdFields["assertEqual"] = simpleObjectField{&assertEqualStdField{assertEqual.field}, assertEqual.hide}
+}
+
+// assertEqualStdField is std.assertEqual, which collects the failures instead
+// of failing when the interpreter is set up to do so.
+type assertEqualStdField struct {
+	field unboundField
+}
 
-	for name, value := range builtinFields {
-		obj.fields[name] = simpleObjectField{value, ast.ObjectFieldHidden}
+func (f *assertEqualStdField) evaluate(i *interpreter, sb selfBinding, origBindings bindingFrame, fieldName string) (value, error) {
+	if i.collectAssertEqualFailures {
+		return &valueFunction{ec: assertEqualCollect}, nil
 	}
-	return objVal.(*valueObject), nil
+	return f.field.evaluate(i, sb, origBindings, fieldName)
+}
+
+func (f *assertEqualStdField) loc() *ast.LocationRange {
+	return f.field.loc()
 }
 
-func evaluateStd(i *interpreter) (value, error) {
-	// We are bootstrapping std before it is properly available.
-	// We need "$std" for desugaring.
-	// So, we're creating an empty thunk (evaluating will panic).
-	// We will fill it manually before it's evaluated, though.
-	// This "std" does not have std.thisFile.
+func buildStdObject(i *interpreter) (*valueObject, error) {
+	sharedStdOnce.Do(buildSharedStd)
+	fields := sharedStdFields
+	if len(i.stdFuncs) > 0 || len(i.deprecatedStd) > 0 {
+		fields = make(simpleObjectFieldMap, len(sharedStdFields)+len(i.stdFuncs))
+		for name, field := range sharedStdFields {
+			fields[name] = field
+		}
+		for key, f := range i.stdFuncs {
+			fields[key] = simpleObjectField{&readyValue{&valueFunction{ec: f}}, ast.ObjectFieldHidden}
+		}
+		for key, message := range i.deprecatedStd {
+			if field, ok := fields[key]; ok {
+				fields[key] = simpleObjectField{&deprecatedStdField{message: message}, field.hide}
+			}
+		}
+	}
+	// The std object refers to itself as $std.
 	stdThunk := &cachedThunk{}
-	beforeStdEnv := makeEnvironment(
-		bindingFrame{
-			"$std": stdThunk,
-		},
-		makeUnboundSelfBinding(),
-	)
-	evalLoc := ast.MakeLocationRangeMessage("During evaluation of std")
-	evalTrace := traceElement{loc: &evalLoc}
-	node := astgen.StdAst
-	i.stack.setCurrentTrace(evalTrace)
-	defer i.stack.clearCurrentTrace()
-	content, err := i.EvalInCleanEnv(&beforeStdEnv, node, false)
-	if err != nil {
-		return nil, err
+	obj := makeValueSimpleObject(bindingFrame{"$std": stdThunk}, fields, nil, sharedStdLocals)
+	stdThunk.content = obj
+	return obj, nil
+}
+
+// deprecatedStdField is a std member deprecated by VM.DeprecateStdMember, using it fails.
+type deprecatedStdField struct {
+	message string
+}
+
+func (f *deprecatedStdField) evaluate(i *interpreter, sb selfBinding, origBindings bindingFrame, fieldName string) (value, error) {
+	return nil, i.Error(fmt.Sprintf("std.%s is deprecated: %s", fieldName, f.message))
+}
+
+func (f *deprecatedStdField) loc() *ast.LocationRange {
+	return &ast.LocationRange{}
+}
+
+// isStdMember returns true if std has a member with the given name, either
+// a builtin or one defined in the Jsonnet part of the standard library.
+func isStdMember(name string) bool {
+	if _, isBuiltin := funcBuiltins[name]; isBuiltin || name == "thisFile" {
+		return true
+	}
+	for _, field := range astgen.StdAst.Fields {
+		if fieldName, ok := field.Name.(*ast.LiteralString); ok && fieldName.Value == name {
+			return true
+		}
 	}
-	stdThunk.content = content
-	return content, nil
+	return false
 }
 
 func prepareExtVars(i *interpreter, ext vmExtMap, kind string) map[string]*cachedThunk {
@@ -1276,14 +1841,37 @@ func buildObject(hide ast.ObjectFieldHide, fields map[string]value) *valueObject
 	return makeValueSimpleObject(bindingFrame{}, fieldMap, nil, nil)
 }
 
-func buildInterpreter(ext vmExtMap, nativeFuncs map[string]*NativeFunction, globalBinding globalBindingMap, maxStack int, ic *importCache, traceOut io.Writer, notifier Notifier) (*interpreter, error) {
+// buildInterpreter creates an interpreter with the settings of the VM.
+func buildInterpreter(vm *VM) (*interpreter, error) {
 	i := interpreter{
-		stack:       makeCallStack(maxStack),
-		importCache: ic,
-		traceOut:    traceOut,
-		nativeFuncs: nativeFuncs,
-		notifier:    notifier,
-	}
+		stack:                makeCallStack(vm.MaxStack),
+		importCache:          vm.importCache,
+		importPolicy:         vm.importPolicy,
+		missingImportPolicy:  vm.missingImportPolicy,
+		missingExtVarPolicy:  vm.missingExtVarPolicy,
+		traceOut:             vm.traceOut,
+		nativeFuncs:          vm.nativeFuncs,
+		nativeResolver:       vm.nativeResolver,
+		maxImports:           vm.maxImports,
+		maxComprehensionSize: vm.maxComprehensionSize,
+		maxArrayLength:       vm.maxArrayLength,
+		maxManifestDepth:     vm.maxManifestDepth,
+		maxObjectNesting:     vm.maxObjectNesting,
+		stdlibDisabled:       vm.stdlibDisabled,
+		emitComments:         vm.emitComments,
+		lineSeparator:        vm.lineSeparator,
+		outputStyle:          vm.outputStyle(),
+		rejectNullOutput:     vm.rejectNullOutput,
+		valueMarshalers:      vm.valueMarshalers,
+		debugger:             vm.debugger(),
+		stdFuncs:             vm.stdFuncs,
+		deprecatedStd:        vm.deprecatedStd,
+		notifier:             vm.notifier,
+		fieldTransformHook:   vm.fieldTransformHook,
+		nativeCallHook:       vm.nativeCallHook,
+	}
+	// The debugger needs the location of every node.
+	i.noLocationTracking = vm.noLocationTracking && i.debugger == nil
 
 	stdObj, err := buildStdObject(&i)
 	if err != nil {
@@ -1292,14 +1880,26 @@ func buildInterpreter(ext vmExtMap, nativeFuncs map[string]*NativeFunction, glob
 
 	i.baseStd = stdObj
 
-	i.globalBinding = globalBinding
+	i.globalBinding = vm.globalBinding
 
-	i.extVars = prepareExtVars(&i, ext, "extvar")
+	i.extVars = prepareExtVars(&i, vm.ext, "extvar")
 
 	return &i, nil
 }
 
-func makeInitialEnv(filename string, baseStd *valueObject, globalBinding globalBindingMap) environment {
+// disabledStdThunk fails when std is used while the standard library is disabled.
+func disabledStdThunk() *cachedThunk {
+	env := makeEnvironment(bindingFrame{}, makeUnboundSelfBinding())
+	return &cachedThunk{
+		env: &env,
+		body: &ast.Error{
+			NodeBase: ast.NewNodeBaseLoc(ast.MakeLocationRangeMessage("Use of std"), nil),
+			Expr:     &ast.LiteralString{Value: "std is disabled", Kind: ast.StringDouble},
+		},
+	}
+}
+
+func makeInitialEnv(filename string, baseStd *valueObject, globalBinding globalBindingMap, stdlibDisabled bool) environment {
 	fileSpecific := buildObject(ast.ObjectFieldHidden, map[string]value{
 		"thisFile": makeValueString(filename),
 	})
@@ -1310,6 +1910,10 @@ func makeInitialEnv(filename string, baseStd *valueObject, globalBinding globalB
 		"std":  stdThunk,
 		"$std": stdThunk, // Unavailable to the user. To be used with desugaring.
 	}
+	if stdlibDisabled {
+		// The desugared code still needs $std.
+		binding["std"] = disabledStdThunk()
+	}
 
 	// Create ENV
 	env := makeEnvironment(
@@ -1338,7 +1942,19 @@ func evaluateAux(i *interpreter, node ast.Node, tla vmExtMap) (value, error) {
 	evalTrace := traceElement{
 		loc: &evalLoc,
 	}
-	env := makeInitialEnv(node.Loc().FileName, i.baseStd, i.globalBinding)
+	// The interpreter may be reused, start from a clean state.
+	i.resetStack()
+	i.importedFiles = nil
+	if i.debugger != nil {
+		i.debugger.reset()
+	}
+	if i.maxImports > 0 {
+		// The values of the files imported in the previous evaluations are not reused,
+		// so that all imports they make are counted again. The parsed files are kept,
+		// see VM.SetMaxImports.
+		i.importCache.flushValueCache()
+	}
+	env := makeInitialEnv(node.Loc().FileName, i.baseStd, i.globalBinding, i.stdlibDisabled)
 	i.stack.setCurrentTrace(evalTrace)
 	result, err := i.EvalInCleanEnv(&env, node, false)
 	i.stack.clearCurrentTrace()
@@ -1348,9 +1964,15 @@ func evaluateAux(i *interpreter, node ast.Node, tla vmExtMap) (value, error) {
 	// If it's not a function, ignore TLA
 	if f, ok := result.(*valueFunction); ok {
 		toplevelArgMap := prepareExtVars(i, tla, "top-level-arg")
+		// Pass the arguments in a stable order, so that the errors are deterministic.
+		argNames := make([]string, 0, len(toplevelArgMap))
+		for argName := range toplevelArgMap {
+			argNames = append(argNames, argName)
+		}
+		sort.Strings(argNames)
 		args := callArguments{}
-		for argName, pv := range toplevelArgMap {
-			args.named = append(args.named, namedCallArgument{name: ast.Identifier(argName), pv: pv})
+		for _, argName := range argNames {
+			args.named = append(args.named, namedCallArgument{name: ast.Identifier(argName), pv: toplevelArgMap[argName]})
 		}
 		funcLoc := ast.MakeLocationRangeMessage("Top-level function call")
 		funcTrace := traceElement{
@@ -1366,25 +1988,175 @@ func evaluateAux(i *interpreter, node ast.Node, tla vmExtMap) (value, error) {
 	return result, nil
 }
 
-func evaluate(i *interpreter, node ast.Node, tla vmExtMap, stringOutputMode bool) (string, error) {
+// literalToJSON converts the AST of a pure JSON document, i.e. a tree of literals,
+// arrays and objects with plain fields, directly to its JSON representation.
+// It returns false for anything which needs to be evaluated, and for the arrays
+// and objects nested deeper than maxDepth.
+func literalToJSON(node ast.Node, maxDepth int) (interface{}, bool) {
+	switch node := node.(type) {
+	case *ast.LiteralNull:
+		return nil, true
+	case *ast.LiteralBoolean:
+		return node.Value, true
+	case *ast.LiteralString:
+		return node.Value, true
+	case *ast.LiteralNumber:
+		num, err := strconv.ParseFloat(node.OriginalString, 64)
+		if err != nil {
+			return nil, false
+		}
+		return num, true
+	case *ast.Unary:
+		if node.Op != ast.UopMinus {
+			return nil, false
+		}
+		if num, ok := node.Expr.(*ast.LiteralNumber); ok {
+			value, ok := literalToJSON(num, maxDepth)
+			if ok {
+				return -value.(float64), true
+			}
+		}
+		return nil, false
+	case *ast.Array:
+		if maxDepth <= 0 {
+			return nil, false
+		}
+		elements := make([]interface{}, len(node.Elements))
+		for index, element := range node.Elements {
+			value, ok := literalToJSON(element.Expr, maxDepth-1)
+			if !ok {
+				return nil, false
+			}
+			elements[index] = value
+		}
+		return elements, true
+	case *ast.DesugaredObject:
+		if len(node.Asserts) > 0 || maxDepth <= 0 {
+			return nil, false
+		}
+		for _, local := range node.Locals {
+			// The only local allowed is the implicit $ binding of the outermost object.
+			if _, isSelf := local.Body.(*ast.Self); local.Variable != "$" || !isSelf {
+				return nil, false
+			}
+		}
+		fields := make(map[string]interface{}, len(node.Fields))
+		for _, field := range node.Fields {
+			name, ok := field.Name.(*ast.LiteralString)
+			if !ok || field.PlusSuper || field.Hide == ast.ObjectFieldHidden {
+				return nil, false
+			}
+			if _, duplicate := fields[name.Value]; duplicate {
+				return nil, false
+			}
+			value, ok := literalToJSON(field.Body, maxDepth-1)
+			if !ok {
+				return nil, false
+			}
+			fields[name.Value] = value
+		}
+		return fields, true
+	}
+	return nil, false
+}
+
+func evaluate(i *interpreter, node ast.Node, tla vmExtMap, stringOutputMode bool, stringOutputNewline bool) (string, error) {
+	if !stringOutputMode && i.maxManifestDepth == 0 && i.maxObjectNesting == 0 && i.fieldTransformHook == nil && !i.rejectNullOutput && i.debugger == nil && !i.emitComments {
+		// Fast path for pure JSON documents, they don't need to be evaluated.
+		// It is not used when the depth or the nesting is limited, the limits are checked
+		// when manifesting and evaluating, when the output is transformed or checked,
+		// when debugging, the breakpoints are checked when evaluating, or when emitting
+		// the comments of the fields.
+		// The evaluation takes about a stack frame per nesting level, so the deep
+		// documents are evaluated, which reports exceeding the stack limit.
+		if json, ok := literalToJSON(node, i.stack.limit/2); ok {
+			var buf bytes.Buffer
+			serializeJSON(json, true, "", i.outputStyle, &buf)
+			buf.WriteString("\n")
+			return i.separateLines(buf.String()), nil
+		}
+	}
+
 	result, err := evaluateAux(i, node, tla)
 	if err != nil {
 		return "", err
 	}
+	return i.manifestOutput(result, stringOutputMode, stringOutputNewline)
+}
+
+// evaluateWithJSON is like evaluate, but the result is also returned
+// in the standard Go JSON representation.
+func evaluateWithJSON(i *interpreter, node ast.Node, tla vmExtMap, stringOutputMode bool, stringOutputNewline bool) (string, interface{}, error) {
+	result, err := evaluateAux(i, node, tla)
+	if err != nil {
+		return "", nil, err
+	}
+	return i.manifestOutputWithJSON(result, stringOutputMode, stringOutputNewline, true)
+}
 
+// manifestOutput manifests the result of the evaluation in the regular output mode.
+func (i *interpreter) manifestOutput(result value, stringOutputMode bool, stringOutputNewline bool) (string, error) {
+	output, _, err := i.manifestOutputWithJSON(result, stringOutputMode, stringOutputNewline, false)
+	return output, err
+}
+
+// manifestOutputWithJSON is like manifestOutput, but it also returns the result manifested
+// in the standard Go JSON representation, which the output is serialized from. In the string
+// output mode, the result is manifested to JSON only if withJSON is set.
+func (i *interpreter) manifestOutputWithJSON(result value, stringOutputMode bool, stringOutputNewline bool, withJSON bool) (string, interface{}, error) {
 	var buf bytes.Buffer
+	var manifested interface{}
+	var err error
 	i.stack.setCurrentTrace(manifestationTrace())
 	if stringOutputMode {
 		err = i.manifestString(&buf, result)
+		if err == nil && withJSON {
+			manifested, err = i.manifestOutputJSON(result)
+		}
 	} else {
-		err = i.manifestAndSerializeJSON(&buf, result, true, "")
+		manifested, err = i.manifestOutputJSON(result)
+		if err == nil {
+			if i.emitComments {
+				err = i.serializeJSONC(manifested, result, "", &buf)
+			} else {
+				serializeJSON(manifested, true, "", i.outputStyle, &buf)
+			}
+		}
 	}
 	i.stack.clearCurrentTrace()
 	if err != nil {
-		return "", err
+		return "", nil, err
+	}
+	if !stringOutputMode || stringOutputNewline {
+		buf.WriteString("\n")
+	}
+	return i.separateLines(buf.String()), manifested, nil
+}
+
+// separateLines replaces the newlines in the output with the configured line separator.
+func (i *interpreter) separateLines(output string) string {
+	return separateLines(output, i.lineSeparator)
+}
+
+// separateLines replaces the newlines in the output with the line separator.
+func separateLines(output string, lineSeparator string) string {
+	if lineSeparator == "" || lineSeparator == "\n" {
+		return output
+	}
+	lines := strings.Split(strings.ReplaceAll(output, "\r\n", "\n"), "\n")
+	return strings.Join(lines, lineSeparator)
+}
+
+func evaluateJSON(i *interpreter, node ast.Node, tla vmExtMap) (interface{}, error) {
+	result, err := evaluateAux(i, node, tla)
+	if err != nil {
+		return nil, err
 	}
-	buf.WriteString("\n")
-	return buf.String(), nil
+
+	i.stack.setCurrentTrace(manifestationTrace())
+	manifested, err := i.manifestOutputJSON(result)
+	i.stack.clearCurrentTrace()
+	return manifested, err
 }
 
 func evaluateMulti(i *interpreter, node ast.Node, tla vmExtMap, stringOutputMode bool) (map[string]string, error) {
@@ -1396,6 +2168,9 @@ func evaluateMulti(i *interpreter, node ast.Node, tla vmExtMap, stringOutputMode
 	i.stack.setCurrentTrace(manifestationTrace())
 	manifested, err := i.manifestAndSerializeMulti(result, stringOutputMode)
 	i.stack.clearCurrentTrace()
+	for filename, output := range manifested {
+		manifested[filename] = i.separateLines(output)
+	}
 	return manifested, err
 }
 
@@ -1408,9 +2183,61 @@ func evaluateStream(i *interpreter, node ast.Node, tla vmExtMap) ([]string, erro
 	i.stack.setCurrentTrace(manifestationTrace())
 	manifested, err := i.manifestAndSerializeYAMLStream(result)
 	i.stack.clearCurrentTrace()
+	for index, output := range manifested {
+		manifested[index] = i.separateLines(output)
+	}
 	return manifested, err
 }
 
+// evaluateField is like evaluate, but only the value at the path in the result is manifested.
+func evaluateField(i *interpreter, node ast.Node, tla vmExtMap, path []string, stringOutputMode bool, stringOutputNewline bool) (string, error) {
+	result, err := evaluateAux(i, node, tla)
+	if err != nil {
+		return "", err
+	}
+	evalLoc := ast.MakeLocationRangeMessage("During evaluation")
+	i.stack.setCurrentTrace(traceElement{loc: &evalLoc})
+	result, err = i.selectField(result, path)
+	i.stack.clearCurrentTrace()
+	if err != nil {
+		return "", err
+	}
+	return i.manifestOutput(result, stringOutputMode, stringOutputNewline)
+}
+
+// selectField returns the value at the path, consisting of the names of the object fields
+// and the indexes of the array elements.
+func (i *interpreter) selectField(v value, path []string) (value, error) {
+	fieldPath := strings.Join(path, ".")
+	for index, name := range path {
+		where := "the result"
+		if index > 0 {
+			where = strings.Join(path[:index], ".")
+		}
+		var err error
+		switch container := v.(type) {
+		case *valueObject:
+			if !objectHasField(objectBinding(container), name, withHidden) {
+				return nil, i.Error(fmt.Sprintf("field path %s: %s has no field %s", fieldPath, where, unparseString(name)))
+			}
+			v, err = container.index(i, name)
+		case *valueArray:
+			elemIndex, convErr := strconv.Atoi(name)
+			if convErr != nil || elemIndex < 0 || elemIndex >= container.length() {
+				return nil, i.Error(fmt.Sprintf("field path %s: %s is an array of length %d, %s is not a valid index",
+					fieldPath, where, container.length(), unparseString(name)))
+			}
+			v, err = container.index(i, elemIndex)
+		default:
+			return nil, i.Error(fmt.Sprintf("field path %s: %s is %s, not an object or an array", fieldPath, where, v.getType().name))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
 type ObjectFieldStep struct {
 	Field string
 }
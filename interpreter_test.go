@@ -15,3 +15,95 @@ limitations under the License.
 */
 
 package jsonnet
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestBinaryOperatorTypeErrors(t *testing.T) {
+	operands := []struct {
+		typeName string
+		code     string
+	}{
+		{"number", "1"},
+		{"string", "'s'"},
+		{"boolean", "true"},
+		{"null", "null"},
+		{"array", "[1]"},
+		{"object", "{}"},
+		{"function", "function() 1"},
+	}
+	operators := []struct {
+		op    string
+		verb  string
+		valid func(x, y string) bool
+	}{
+		{"+", "add", func(x, y string) bool {
+			return x == "string" || y == "string" || (x == y && (x == "number" || x == "array" || x == "object"))
+		}},
+		{"-", "subtract", bothNumbers},
+		{"*", "multiply", bothNumbers},
+		{"/", "divide", bothNumbers},
+		{"<<", "shift", bothNumbers},
+		{">>", "shift", bothNumbers},
+		{"&", "bitwise and", bothNumbers},
+		{"^", "bitwise xor", bothNumbers},
+		{"|", "bitwise or", bothNumbers},
+		{"<", "compare", bothComparable},
+		{"<=", "compare", bothComparable},
+		{">", "compare", bothComparable},
+		{">=", "compare", bothComparable},
+	}
+	for _, operator := range operators {
+		for _, x := range operands {
+			for _, y := range operands {
+				if operator.valid(x.typeName, y.typeName) {
+					continue
+				}
+				snippet := fmt.Sprintf("local x = %s, y = %s; x %s y", x.code, y.code, operator.op)
+				vm := MakeVM()
+				_, err := vm.EvaluateAnonymousSnippet("op.jsonnet", snippet)
+				expected := fmt.Sprintf("RUNTIME ERROR: cannot %s %s and %s with operator %s\n", operator.verb, x.typeName, y.typeName, operator.op)
+				if err == nil || !strings.HasPrefix(err.Error(), expected) {
+					t.Errorf("%s: expected error %q, but got %v", snippet, expected, err)
+				}
+			}
+		}
+	}
+}
+
+func bothNumbers(x, y string) bool {
+	return x == "number" && y == "number"
+}
+
+func bothComparable(x, y string) bool {
+	return x == y && (x == "number" || x == "string" || x == "array")
+}
+
+func TestParseJSONErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"a": [1, 2,]}`, `invalid character ']' looking for beginning of value at line 1, column 13 (offset 12), near "{\"a\": [1, 2,]}"`},
+		{"{\n  \"a\": 1\n  \"b\": 2\n}", `invalid character '"' after object key:value pair at line 3, column 3 (offset 13), near "  \"b\": 2"`},
+		{"[\"é\", tru]", `invalid character 't' looking for beginning of value at line 1, column 7 (offset 7), near "[\"é\", tru]"`},
+		{`{"a": "b`, `unexpected end of JSON input at line 1, column 9 (offset 8), near "{\"a\": \"b"`},
+		{`["\x"]`, `invalid character 'x' in string escape code at line 1, column 4 (offset 3), near "[\"\\x\"]"`},
+		{`[1e400]`, `number 1e400 is out of range at line 1, column 2 (offset 1), near "[1e400]"`},
+		{`{"key": 01}`, `invalid character '1' after object key:value pair at line 1, column 10 (offset 9), near "{\"key\": 01}"`},
+		{strings.Repeat("x", 30) + `[]`, `invalid character 'x' looking for beginning of value at line 1, column 1 (offset 0), near "xxxxxxxxxxxxxxxxxxxx"`},
+	}
+	for _, test := range tests {
+		_, err := parseJSON(test.input)
+		if err == nil {
+			t.Errorf("%q: expected error, got nil", test.input)
+			continue
+		}
+		if err.Error() != test.expected {
+			t.Errorf("%q: expected %q, but got %q", test.input, test.expected, err.Error())
+		}
+	}
+}
@@ -0,0 +1,427 @@
+/*
+Copyright 2024 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// The same limit as in encoding/json.
+const jsonMaxDepth = 10000
+
+// The values are immutable, so all the parsed booleans can share them.
+var (
+	jsonTrue  = makeValueBoolean(true)
+	jsonFalse = makeValueBoolean(false)
+)
+
+// jsonParser parses JSON text directly to Jsonnet values, without building
+// the standard Go representation of the whole document first.
+// It follows encoding/json: the duplicate keys are allowed and the last one wins,
+// and invalid UTF-8 and unpaired surrogates are replaced with U+FFFD.
+type jsonParser struct {
+	data  string
+	pos   int
+	depth int
+	// The object keys which were already found, large documents usually
+	// have many objects with the same keys.
+	keys map[string]string
+}
+
+// parseJSON parses a complete JSON document.
+func parseJSON(data string) (value, error) {
+	p := jsonParser{data: data, keys: map[string]string{}}
+	v, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	p.skipWhitespace()
+	if p.pos < len(p.data) {
+		return nil, p.invalidCharacter("after top-level value")
+	}
+	return v, nil
+}
+
+func (p *jsonParser) skipWhitespace() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\n', '\r':
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// The number of bytes of the input shown on each side of the error position.
+const jsonErrorContext = 20
+
+// errorAt makes an error with the position in the input, as the line and the column
+// (both counted from 1, the column in characters), the byte offset and the surrounding text.
+func (p *jsonParser) errorAt(pos int, msg string) error {
+	lineStart := strings.LastIndexByte(p.data[:pos], '\n') + 1
+	line := strings.Count(p.data[:lineStart], "\n") + 1
+	column := utf8.RuneCountInString(p.data[lineStart:pos]) + 1
+	lineEnd := len(p.data)
+	if n := strings.IndexByte(p.data[pos:], '\n'); n >= 0 {
+		lineEnd = pos + n
+	}
+	from := pos - jsonErrorContext
+	if from < lineStart {
+		from = lineStart
+	}
+	to := pos + jsonErrorContext
+	if to > lineEnd {
+		to = lineEnd
+	}
+	// Do not cut the characters in the middle.
+	for from > lineStart && !utf8.RuneStart(p.data[from]) {
+		from--
+	}
+	for to < lineEnd && !utf8.RuneStart(p.data[to]) {
+		to++
+	}
+	return fmt.Errorf("%s at line %d, column %d (offset %d), near %s",
+		msg, line, column, pos, unparseString(p.data[from:to]))
+}
+
+func (p *jsonParser) invalidCharacter(context string) error {
+	if p.pos >= len(p.data) {
+		return p.errorAt(len(p.data), "unexpected end of JSON input")
+	}
+	r, _ := utf8.DecodeRuneInString(p.data[p.pos:])
+	var quoted string
+	switch r {
+	case '\'':
+		quoted = `'\''`
+	case '"':
+		quoted = `'"'`
+	default:
+		quoted = strconv.Quote(string(r))
+		quoted = "'" + quoted[1:len(quoted)-1] + "'"
+	}
+	return p.errorAt(p.pos, fmt.Sprintf("invalid character %s %s", quoted, context))
+}
+
+func (p *jsonParser) parseValue() (value, error) {
+	p.skipWhitespace()
+	if p.pos >= len(p.data) {
+		return nil, p.invalidCharacter("")
+	}
+	switch c := p.data[p.pos]; {
+	case c == '{':
+		return p.parseObject()
+	case c == '[':
+		return p.parseArray()
+	case c == '"':
+		runes, err := p.parseString()
+		if err != nil {
+			return nil, err
+		}
+		return makeStringFromRunes(runes), nil
+	case c == '-' || (c >= '0' && c <= '9'):
+		return p.parseNumber()
+	case p.consumeLiteral("true"):
+		return jsonTrue, nil
+	case p.consumeLiteral("false"):
+		return jsonFalse, nil
+	case p.consumeLiteral("null"):
+		return &nullValue, nil
+	default:
+		return nil, p.invalidCharacter("looking for beginning of value")
+	}
+}
+
+func (p *jsonParser) consumeLiteral(literal string) bool {
+	if len(p.data)-p.pos >= len(literal) && p.data[p.pos:p.pos+len(literal)] == literal {
+		p.pos += len(literal)
+		return true
+	}
+	return false
+}
+
+func (p *jsonParser) enter() error {
+	p.depth++
+	if p.depth > jsonMaxDepth {
+		return p.errorAt(p.pos, "exceeded max depth")
+	}
+	p.pos++
+	return nil
+}
+
+func (p *jsonParser) parseArray() (value, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	var elements []*cachedThunk
+	p.skipWhitespace()
+	if p.pos < len(p.data) && p.data[p.pos] == ']' {
+		p.pos++
+		p.depth--
+		return makeValueArray(elements), nil
+	}
+	for {
+		elem, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, readyThunk(elem))
+		p.skipWhitespace()
+		if p.pos < len(p.data) && p.data[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		if p.pos < len(p.data) && p.data[p.pos] == ']' {
+			p.pos++
+			p.depth--
+			return makeValueArray(elements), nil
+		}
+		return nil, p.invalidCharacter("after array element")
+	}
+}
+
+func (p *jsonParser) parseObject() (value, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	fields := simpleObjectFieldMap{}
+	p.skipWhitespace()
+	if p.pos < len(p.data) && p.data[p.pos] == '}' {
+		p.pos++
+		p.depth--
+		return makeValueSimpleObject(bindingFrame{}, fields, nil, nil), nil
+	}
+	for {
+		p.skipWhitespace()
+		if p.pos >= len(p.data) || p.data[p.pos] != '"' {
+			return nil, p.invalidCharacter("looking for beginning of object key string")
+		}
+		name, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		p.skipWhitespace()
+		if p.pos >= len(p.data) || p.data[p.pos] != ':' {
+			return nil, p.invalidCharacter("after object key")
+		}
+		p.pos++
+		fieldValue, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		fields[name] = simpleObjectField{hide: ast.ObjectFieldInherit, field: &readyValue{fieldValue}}
+		p.skipWhitespace()
+		if p.pos < len(p.data) && p.data[p.pos] == ',' {
+			p.pos++
+			continue
+		}
+		if p.pos < len(p.data) && p.data[p.pos] == '}' {
+			p.pos++
+			p.depth--
+			return makeValueSimpleObject(bindingFrame{}, fields, nil, nil), nil
+		}
+		return nil, p.invalidCharacter("after object key:value pair")
+	}
+}
+
+// parseKey parses a string, reusing the same Go string for the equal keys.
+func (p *jsonParser) parseKey() (string, error) {
+	if raw, ok := p.scanSimpleString(); ok {
+		// Indexing the map with a substring does not allocate.
+		if key, found := p.keys[raw]; found {
+			return key, nil
+		}
+		key := string([]byte(raw))
+		p.keys[key] = key
+		return key, nil
+	}
+	runes, err := p.parseString()
+	if err != nil {
+		return "", err
+	}
+	return string(runes), nil
+}
+
+// scanSimpleString consumes a string with no escapes and no control characters
+// and returns its content. Otherwise, the position is unchanged.
+func (p *jsonParser) scanSimpleString() (string, bool) {
+	for end := p.pos + 1; end < len(p.data); end++ {
+		c := p.data[end]
+		if c == '"' {
+			raw := p.data[p.pos+1 : end]
+			if !utf8.ValidString(raw) {
+				return "", false
+			}
+			p.pos = end + 1
+			return raw, true
+		}
+		if c == '\\' || c < 0x20 {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+func (p *jsonParser) parseString() ([]rune, error) {
+	if raw, ok := p.scanSimpleString(); ok {
+		return []rune(raw), nil
+	}
+	p.pos++
+	var runes []rune
+	for {
+		if p.pos >= len(p.data) {
+			return nil, p.invalidCharacter("")
+		}
+		c := p.data[p.pos]
+		switch {
+		case c == '"':
+			p.pos++
+			return runes, nil
+		case c < 0x20:
+			return nil, p.invalidCharacter("in string literal")
+		case c == '\\':
+			r, err := p.parseEscape()
+			if err != nil {
+				return nil, err
+			}
+			runes = append(runes, r)
+		case c < utf8.RuneSelf:
+			runes = append(runes, rune(c))
+			p.pos++
+		default:
+			r, size := utf8.DecodeRuneInString(p.data[p.pos:])
+			runes = append(runes, r)
+			p.pos += size
+		}
+	}
+}
+
+func (p *jsonParser) parseEscape() (rune, error) {
+	p.pos++
+	if p.pos >= len(p.data) {
+		return 0, p.invalidCharacter("")
+	}
+	c := p.data[p.pos]
+	p.pos++
+	switch c {
+	case '"', '\\', '/':
+		return rune(c), nil
+	case 'b':
+		return '\b', nil
+	case 'f':
+		return '\f', nil
+	case 'n':
+		return '\n', nil
+	case 'r':
+		return '\r', nil
+	case 't':
+		return '\t', nil
+	case 'u':
+		r, err := p.parseHex4()
+		if err != nil {
+			return 0, err
+		}
+		if !utf16.IsSurrogate(r) {
+			return r, nil
+		}
+		if p.consumeLiteral(`\u`) {
+			start := p.pos - 2
+			r2, err := p.parseHex4()
+			if err != nil {
+				return 0, err
+			}
+			if combined := utf16.DecodeRune(r, r2); combined != utf8.RuneError {
+				return combined, nil
+			}
+			// Not a pair, the second escape is parsed again on its own.
+			p.pos = start
+		}
+		return utf8.RuneError, nil
+	default:
+		p.pos--
+		return 0, p.invalidCharacter("in string escape code")
+	}
+}
+
+func (p *jsonParser) parseHex4() (rune, error) {
+	var r rune
+	for n := 0; n < 4; n++ {
+		if p.pos >= len(p.data) {
+			return 0, p.invalidCharacter("")
+		}
+		c := p.data[p.pos]
+		switch {
+		case c >= '0' && c <= '9':
+			r = r*16 + rune(c-'0')
+		case c >= 'a' && c <= 'f':
+			r = r*16 + rune(c-'a'+10)
+		case c >= 'A' && c <= 'F':
+			r = r*16 + rune(c-'A'+10)
+		default:
+			return 0, p.invalidCharacter(`in \u hexadecimal character escape`)
+		}
+		p.pos++
+	}
+	return r, nil
+}
+
+func (p *jsonParser) skipDigits() int {
+	start := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '9' {
+		p.pos++
+	}
+	return p.pos - start
+}
+
+func (p *jsonParser) parseNumber() (value, error) {
+	start := p.pos
+	if p.data[p.pos] == '-' {
+		p.pos++
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '0' {
+		p.pos++
+	} else if p.skipDigits() == 0 {
+		return nil, p.invalidCharacter("in numeric literal")
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '.' {
+		p.pos++
+		if p.skipDigits() == 0 {
+			return nil, p.invalidCharacter("after decimal point in numeric literal")
+		}
+	}
+	if p.pos < len(p.data) && (p.data[p.pos] == 'e' || p.data[p.pos] == 'E') {
+		p.pos++
+		if p.pos < len(p.data) && (p.data[p.pos] == '+' || p.data[p.pos] == '-') {
+			p.pos++
+		}
+		if p.skipDigits() == 0 {
+			return nil, p.invalidCharacter("in exponent of numeric literal")
+		}
+	}
+	f, err := strconv.ParseFloat(p.data[start:p.pos], 64)
+	if err != nil || math.IsInf(f, 0) {
+		return nil, p.errorAt(start, fmt.Sprintf("number %s is out of range", p.data[start:p.pos]))
+	}
+	return makeValueNumber(f), nil
+}
@@ -3,15 +3,23 @@ package jsonnet
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 	"unicode/utf8"
 
 	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/internal/program"
 	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/yaml"
 )
 
 type errorFormattingTest struct {
@@ -126,6 +134,98 @@ func TestCustomImporter(t *testing.T) {
 	}
 }
 
+func TestChainImporter(t *testing.T) {
+	vm := MakeVM()
+	vm.Importer(&ChainImporter{
+		Importers: []Importer{
+			&MemoryImporter{
+				map[string]Contents{
+					"overlay.jsonnet": MakeContents("'overlay'"),
+					"true.jsonnet":    MakeContents("'shadowed'"),
+				},
+			},
+			&FileImporter{JPaths: []string{"testdata"}},
+		},
+	})
+	input := `[import "overlay.jsonnet", import "true.jsonnet", import "array.jsonnet"]`
+	expected := `[ "overlay", "shadowed", [ 1, 2, 3 ] ]`
+	actual, err := vm.EvaluateAnonymousSnippet("chain_import.jsonnet", input)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	actual = removeExcessiveWhitespace(actual)
+	if actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+
+	foundAt, err := vm.ResolveImport("", "array.jsonnet")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "testdata/array.jsonnet"; foundAt != expected {
+		t.Errorf("Expected foundAt %q, but got %q", expected, foundAt)
+	}
+
+	_, err = vm.EvaluateAnonymousSnippet("chain_import.jsonnet", `import "missing.jsonnet"`)
+	if err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+	for _, msg := range []string{"import not available missing.jsonnet", "no match locally or in the Jsonnet library paths"} {
+		if !strings.Contains(err.Error(), msg) {
+			t.Errorf("Expected error to contain %q, got %v", msg, err)
+		}
+	}
+	if n := strings.Count(err.Error(), `couldn't open import "missing.jsonnet"`); n != 1 {
+		t.Errorf("Expected the import to be named once, got %v", err)
+	}
+
+	// The importers returning plain errors for missing files are followed by the next ones.
+	vm.Importer(&ChainImporter{
+		Importers: []Importer{
+			failingImporter{err: errors.New("not in the bundle")},
+			&FileImporter{JPaths: []string{"testdata"}},
+		},
+	})
+	if _, err := vm.EvaluateAnonymousSnippet("chain_import.jsonnet", `import "array.jsonnet"`); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	// If none of them succeeds, all the errors are reported, and the missing file is not
+	// mapped to null, as the other failure may be a real one.
+	vm.SetMissingImportPolicy(MissingImportNull)
+	_, err = vm.EvaluateAnonymousSnippet("chain_import.jsonnet", `import "missing.jsonnet"`)
+	for _, msg := range []string{"not in the bundle", "no match locally or in the Jsonnet library paths"} {
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("Expected error to contain %q, got %v", msg, err)
+		}
+	}
+	vm.SetMissingImportPolicy(MissingImportError)
+	vm.Importer(&ChainImporter{
+		Importers: []Importer{
+			failingImporter{err: fmt.Errorf("not in the bundle: %w", ErrImportNotFound)},
+			&FileImporter{JPaths: []string{"testdata"}},
+		},
+	})
+	if _, err := vm.EvaluateAnonymousSnippet("chain_import.jsonnet", `import "array.jsonnet"`); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+// failingImporter fails every import with the given error.
+type failingImporter struct {
+	err error
+}
+
+func (importer failingImporter) Import(importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	return Contents{}, "", importer.err
+}
+
+// panickingImporter crashes the evaluation which imports anything.
+type panickingImporter struct{}
+
+func (panickingImporter) Import(importedFrom, importedPath string) (contents Contents, foundAt string, err error) {
+	panic("importer crashed")
+}
+
 type importHistoryEntry struct {
 	importedFrom string
 	importedPath string
@@ -221,6 +321,92 @@ func TestAnonymousImportedFrom(t *testing.T) {
 	}
 }
 
+func TestIncrementalEvaluation(t *testing.T) {
+	vm := MakeVM()
+	importer := importerWithHistory{
+		i: MemoryImporter{
+			map[string]Contents{
+				"a.libsonnet": MakeContents("{ a: 1 }"),
+				"b.libsonnet": MakeContents("{ b: 2 }"),
+			},
+		},
+	}
+	vm.Importer(&importer)
+	for _, test := range []struct {
+		snippet  string
+		expected string
+	}{
+		{`(import 'a.libsonnet') + (import 'b.libsonnet')`, `{ "a": 1, "b": 2 }`},
+		{`(import 'a.libsonnet') + (import 'b.libsonnet') + { c: 3 }`, `{ "a": 1, "b": 2, "c": 3 }`},
+		{`(import 'a.libsonnet') + (import 'b.libsonnet') + { c: 4 }`, `{ "a": 1, "b": 2, "c": 4 }`},
+	} {
+		actual, err := vm.EvaluateSnippet("main.jsonnet", test.snippet)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if removeExcessiveWhitespace(actual) != test.expected {
+			t.Errorf("Expected %q, but got %q", test.expected, removeExcessiveWhitespace(actual))
+		}
+	}
+	// Editing the main file doesn't call the importer again.
+	expectedImportHistory := []importHistoryEntry{{"main.jsonnet", "a.libsonnet"}, {"main.jsonnet", "b.libsonnet"}}
+	if !reflect.DeepEqual(importer.history, expectedImportHistory) {
+		t.Errorf("Expected %q, but got %q", expectedImportHistory, importer.history)
+	}
+
+	// After editing a dependency, only the changed file is parsed again.
+	parsedA := vm.importCache.astCache["a.libsonnet"]
+	parsedB := vm.importCache.astCache["b.libsonnet"]
+	vm.Importer(&MemoryImporter{
+		map[string]Contents{
+			"a.libsonnet": MakeContents("{ a: 1 }"),
+			"b.libsonnet": MakeContents("{ b: 20 }"),
+		},
+	})
+	actual, err := vm.EvaluateSnippet("main.jsonnet", `(import 'a.libsonnet') + (import 'b.libsonnet')`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "a": 1, "b": 20 }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+	if vm.importCache.astCache["a.libsonnet"] != parsedA {
+		t.Errorf("Expected the unchanged a.libsonnet not to be parsed again")
+	}
+	if vm.importCache.astCache["b.libsonnet"] == parsedB {
+		t.Errorf("Expected the changed b.libsonnet to be parsed again")
+	}
+}
+
+func TestResetImportCache(t *testing.T) {
+	for _, frozen := range []bool{false, true} {
+		vm := MakeVM()
+		importer := &MemoryImporter{map[string]Contents{"config.libsonnet": MakeContents("{ replicas: 1 }")}}
+		vm.Importer(importer)
+		if frozen {
+			if err := vm.Freeze(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		evaluate := func(expected string) {
+			actual, err := vm.EvaluateSnippet("main.jsonnet", `(import 'config.libsonnet').replicas`)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if removeExcessiveWhitespace(actual) != expected {
+				t.Errorf("frozen=%v: expected %q, but got %q", frozen, expected, removeExcessiveWhitespace(actual))
+			}
+		}
+		evaluate("1")
+		importer.Data["config.libsonnet"] = MakeContents("{ replicas: 3 }")
+		// The cached import is used until the cache is reset.
+		evaluate("1")
+		vm.ResetImportCache()
+		evaluate("3")
+	}
+}
+
 func TestContents(t *testing.T) {
 	a := "aaa"
 	c1 := MakeContents(a)
@@ -253,6 +439,30 @@ func TestExtReset(t *testing.T) {
 	}
 }
 
+func TestMissingExtVarPolicy(t *testing.T) {
+	snippet := `{ defined: std.extVar('defined'), missing: std.extVar('missing') }`
+
+	vm := MakeVM()
+	vm.ExtVar("defined", "x")
+	_, err := vm.EvaluateAnonymousSnippet("test.jsonnet", snippet)
+	if err == nil {
+		t.Fatalf("expected error, got nil")
+	}
+	if !strings.Contains(err.Error(), "Undefined external variable: missing") {
+		t.Errorf("unexpected error %v", err)
+	}
+
+	vm.SetMissingExtVarPolicy(MissingExtVarNull)
+	actual, err := vm.EvaluateAnonymousSnippet("test.jsonnet", snippet)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "defined": "x", "missing": null }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+}
+
 func TestTLAReset(t *testing.T) {
 	vm := MakeVM()
 	vm.TLAVar("fooString", "bar")
@@ -312,6 +522,198 @@ func TestExtTypes(t *testing.T) {
 	assertVarOutput(t, jsonStr)
 }
 
+type extVarGoBase struct {
+	ID   int64  `json:"id"`
+	Kind string `json:"kind"`
+}
+
+type extVarGoItem struct {
+	Name  string
+	Count uint8 `json:"count"`
+}
+
+type extVarGoConfig struct {
+	extVarGoBase
+	Kind     string            `json:"kind"`
+	Items    []extVarGoItem    `json:"items"`
+	Labels   map[string]string `json:"labels"`
+	Parent   *extVarGoConfig   `json:"parent"`
+	Optional string            `json:"optional,omitempty"`
+	Skipped  string            `json:"-"`
+	Ratio    float64
+	private  int
+}
+
+func TestExtVarGo(t *testing.T) {
+	vm := MakeVM()
+	err := vm.ExtVarGo("config", extVarGoConfig{
+		extVarGoBase: extVarGoBase{ID: 123456789012, Kind: "base"},
+		Kind:         "outer",
+		Items:        []extVarGoItem{{Name: "a", Count: 1}, {Name: "b", Count: 2}},
+		Labels:       map[string]string{"z": "last", "a": "first"},
+		Skipped:      "skipped",
+		Ratio:        0.5,
+		private:      1,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	err = vm.ExtVarGo("scalar", 42)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	actual, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `
+		local config = std.extVar('config');
+		config + {
+			idString: std.toString(config.id),
+			scalarType: std.type(std.extVar('scalar')),
+		}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "Ratio": 0.5, "id": 123456789012, "idString": "123456789012", "items": [ { "Name": "a", "count": 1 }, { "Name": "b", "count": 2 } ], "kind": "outer", "labels": { "a": "first", "z": "last" }, "parent": null, "scalarType": "number" }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	// Like in encoding/json, []byte is base64 encoded and integer map keys are formatted.
+	// A value referenced more than once is not a cycle.
+	shared := []string{"x"}
+	err = vm.ExtVarGo("encoded", map[string]interface{}{
+		"shared":  [][]string{shared, shared},
+		"bytes":   []byte("hello"),
+		"intKeys": map[int]string{10: "ten", -1: "minus one"},
+		"uint8s":  [2]uint8{1, 2},
+		"large":   int64(1 << 60),
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	actual, err = vm.EvaluateAnonymousSnippet("test.jsonnet", `std.extVar('encoded')`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = `{ "bytes": "aGVsbG8=", "intKeys": { "-1": "minus one", "10": "ten" }, "large": 1152921504606846976, "shared": [ [ "x" ], [ "x" ] ], "uint8s": [ 1, 2 ] }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	type node struct {
+		Next *node
+	}
+	cyclicNode := &node{}
+	cyclicNode.Next = cyclicNode
+	type embedded struct {
+		*embedded
+	}
+	cyclicEmbedded := &embedded{}
+	cyclicEmbedded.embedded = cyclicEmbedded
+	cyclicMap := map[string]interface{}{}
+	cyclicMap["self"] = cyclicMap
+	cyclicSlice := []interface{}{nil}
+	cyclicSlice[0] = cyclicSlice
+
+	invalid := []struct {
+		value       interface{}
+		expectedErr string
+	}{
+		{cyclicNode, `ext var "invalid": invalid.Next: encountered a cycle via *jsonnet.node`},
+		{cyclicEmbedded, `ext var "invalid": invalid: encountered a cycle via *jsonnet.embedded`},
+		{cyclicMap, `ext var "invalid": invalid.self: encountered a cycle via map[string]interface {}`},
+		{cyclicSlice, `ext var "invalid": invalid[0]: encountered a cycle via []interface {}`},
+		{map[bool]string{true: "a"}, `ext var "invalid": invalid: unsupported map key type bool`},
+		{[]int64{1, 9007199254740993}, `ext var "invalid": invalid[1]: integer 9007199254740993 cannot be represented exactly as a number`},
+		{uint64(math.MaxUint64), `ext var "invalid": invalid: integer 18446744073709551615 cannot be represented exactly as a number`},
+		{int64(math.MaxInt64), `ext var "invalid": invalid: integer 9223372036854775807 cannot be represented exactly as a number`},
+	}
+	for _, test := range invalid {
+		err = vm.ExtVarGo("invalid", test.value)
+		if err == nil {
+			t.Errorf("Expected error for %v, got nil", test.value)
+		} else if err.Error() != test.expectedErr {
+			t.Errorf("Expected %q, but got %q", test.expectedErr, err.Error())
+		}
+	}
+}
+
+type evaluateIntoServer struct {
+	Host string `json:"host"`
+	Port uint16 `json:"port"`
+}
+
+type evaluateIntoConfig struct {
+	Name     string                 `json:"name"`
+	Replicas int64                  `json:"replicas"`
+	Ratio    float64                `json:"ratio"`
+	Enabled  bool                   `json:"enabled"`
+	Servers  []evaluateIntoServer   `json:"servers"`
+	Primary  *evaluateIntoServer    `json:"primary"`
+	Labels   map[string]string      `json:"labels"`
+	Extra    map[string]interface{} `json:"extra"`
+	Ignored  string                 `json:"-"`
+}
+
+func TestEvaluateInto(t *testing.T) {
+	vm := MakeVM()
+	var config evaluateIntoConfig
+	err := vm.EvaluateInto("config.jsonnet", `{
+		name: 'app',
+		replicas: 9007199254740991,
+		ratio: 0.25,
+		enabled: true,
+		servers: [{ host: 'a', port: 80 }, { host: 'b', port: 443 }],
+		primary: self.servers[1],
+		labels: { tier: 'web' },
+		extra: { list: [1, 'two'], nothing: null },
+		Ignored: 'ignored',
+		unknown: 'unknown',
+	}`, &config)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := evaluateIntoConfig{
+		Name:     "app",
+		Replicas: 9007199254740991,
+		Ratio:    0.25,
+		Enabled:  true,
+		Servers:  []evaluateIntoServer{{Host: "a", Port: 80}, {Host: "b", Port: 443}},
+		Primary:  &evaluateIntoServer{Host: "b", Port: 443},
+		Labels:   map[string]string{"tier": "web"},
+		Extra:    map[string]interface{}{"list": []interface{}{1.0, "two"}, "nothing": nil},
+	}
+	if !reflect.DeepEqual(config, expected) {
+		t.Errorf("Expected %+v, but got %+v", expected, config)
+	}
+
+	errorTests := []struct {
+		snippet string
+		err     string
+	}{
+		{`{ replicas: 1.5 }`, "$.replicas: number 1.5 does not fit in Go value of type int64"},
+		{`{ servers: [{ port: 70000 }] }`, "$.servers[0].port: number 70000 does not fit in Go value of type uint16"},
+		{`{ name: 42 }`, "$.name: cannot store number in Go value of type string"},
+		{`[]`, "$: cannot store array in Go value of type jsonnet.evaluateIntoConfig"},
+	}
+	for _, test := range errorTests {
+		var config evaluateIntoConfig
+		err := vm.EvaluateInto("config.jsonnet", test.snippet, &config)
+		if err == nil || err.Error() != test.err {
+			t.Errorf("Expected error %q, but got %v", test.err, err)
+		}
+	}
+
+	err = vm.EvaluateInto("config.jsonnet", `{}`, config)
+	if err == nil {
+		t.Errorf("Expected error for a non-pointer argument, got nil")
+	}
+
+	_, err = vm.EvaluateAnonymousSnippet("config.jsonnet", `error 'broken'`)
+	intoErr := vm.EvaluateInto("config.jsonnet", `error 'broken'`, &config)
+	if intoErr == nil || intoErr.Error() != err.Error() {
+		t.Errorf("Expected %v, but got %v", err, intoErr)
+	}
+}
+
 func TestTLATypes(t *testing.T) {
 	node, err := SnippetToAST("var.jsonnet", `{ node: 'node' }`)
 	if err != nil {
@@ -397,6 +799,202 @@ func TestGlobalBinding(t *testing.T) {
 	}
 }
 
+func TestDeprecateStdMember(t *testing.T) {
+	vm := MakeVM()
+	vm.DeprecateStdMember("trace", "use a debugger instead")
+	vm.DeprecateStdMember("notAStdMember", "ignored")
+	_, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `{ a: std.trace('here', 1) }`)
+	if err == nil || !strings.Contains(err.Error(), "std.trace is deprecated: use a debugger instead") {
+		t.Errorf("Expected the deprecation error, but got %v", err)
+	}
+
+	actual, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `{ a: std.length([1]), has: std.objectHasAll(std, 'trace'), other: std.objectHasAll(std, 'notAStdMember') }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "a": 1, "has": true, "other": false }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+}
+
+func TestBindAll(t *testing.T) {
+	vm := MakeVM()
+	vm.Bind("a", &ast.LiteralString{Value: "a"})
+	vm.BindAll(map[string]ast.Node{
+		"b": &ast.LiteralNumber{OriginalString: "2"},
+		"c": &ast.LiteralBoolean{Value: true},
+	})
+	if expected, actual := []string{"a", "b", "c"}, vm.BoundGlobals(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+	vm.Importer(&MemoryImporter{Data: map[string]Contents{"lib.jsonnet": MakeContents(`[a, b]`)}})
+	actual, err := vm.EvaluateAnonymousSnippet("main.jsonnet", `{ lib: import "lib.jsonnet", c: c }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "c": true, "lib": [ "a", 2 ] }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	vm.Unbind("a")
+	vm.Unbind("missing")
+	if expected, actual := []string{"b", "c"}, vm.BoundGlobals(); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+	// The imported file is checked again without the identifier.
+	_, err = vm.EvaluateAnonymousSnippet("main.jsonnet", `import "lib.jsonnet"`)
+	if err == nil || !strings.Contains(err.Error(), "Unknown variable: a") || strings.Contains(err.Error(), "INTERNAL ERROR") {
+		t.Errorf("Expected the unknown variable error, but got %v", err)
+	}
+
+	vm.Bind("a", &ast.LiteralString{Value: "again"})
+	actual, err = vm.EvaluateAnonymousSnippet("main.jsonnet", `import "lib.jsonnet"`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = `[ "again", 2 ]`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+}
+
+func TestEvaluateAST(t *testing.T) {
+	vm := MakeVM()
+	vm.Bind("suffix", &ast.LiteralString{Value: "!"})
+	name, greeting := ast.Identifier("name"), ast.Identifier("greeting")
+	node := &ast.Object{
+		Fields: ast.ObjectFields{
+			{Kind: ast.ObjectFieldID, Hide: ast.ObjectFieldInherit, Id: &name, Expr2: &ast.LiteralString{Value: "world"}},
+			{
+				Kind: ast.ObjectFieldID,
+				Hide: ast.ObjectFieldInherit,
+				Id:   &greeting,
+				Expr2: &ast.Binary{
+					Left: &ast.Binary{
+						Left:  &ast.LiteralString{Value: "hello "},
+						Op:    ast.BopPlus,
+						Right: &ast.Index{Target: &ast.Self{}, Id: &name},
+					},
+					Op:    ast.BopPlus,
+					Right: &ast.Var{Id: "suffix"},
+				},
+			},
+		},
+	}
+	actual, err := vm.EvaluateAST(node)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "greeting": "hello world!", "name": "world" }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	// The node is analyzed, so the unknown variables are reported.
+	vm.Unbind("suffix")
+	_, err = vm.EvaluateAST(node)
+	if err == nil || !strings.Contains(err.Error(), "Unknown variable: suffix") {
+		t.Errorf("Expected the unknown variable error, but got %v", err)
+	}
+}
+
+func TestNativeResolver(t *testing.T) {
+	var resolved []string
+	vm := MakeVM()
+	vm.SetNativeResolver(func(name string) (*NativeFunction, bool) {
+		resolved = append(resolved, name)
+		if name != "double" {
+			return nil, false
+		}
+		return &NativeFunction{
+			Name:   name,
+			Params: ast.Identifiers{"x"},
+			Func: func(params []interface{}) (interface{}, error) {
+				return params[0].(float64) * 2, nil
+			},
+		}, true
+	})
+
+	actual, err := vm.EvaluateAnonymousSnippet("native.jsonnet", `{
+		a: std.native('double')(2),
+		b: std.native('double')(21),
+		missing: std.native('missing'),
+	}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "a": 4, "b": 42, "missing": null }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+	// The resolved function is kept, so the resolver is called only once per name.
+	if !reflect.DeepEqual(resolved, []string{"double", "missing"}) {
+		t.Errorf("Unexpected resolver calls: %v", resolved)
+	}
+
+	// The resolved function is not registered in the VM, so replacing the resolver applies.
+	vm.SetNativeResolver(func(name string) (*NativeFunction, bool) {
+		return &NativeFunction{
+			Name:   name,
+			Params: ast.Identifiers{"x"},
+			Func: func(params []interface{}) (interface{}, error) {
+				return params[0].(float64) * 3, nil
+			},
+		}, true
+	})
+	actual, err = vm.EvaluateAnonymousSnippet("native.jsonnet", `std.native('double')(2)`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "6\n"; actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+	vm.SetNativeResolver(nil)
+	actual, err = vm.EvaluateAnonymousSnippet("native.jsonnet", `std.native('double')`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "null\n"; actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+}
+
+func TestAddStdFunc(t *testing.T) {
+	vm := MakeVM()
+	err := vm.AddStdFunc("greet", ast.Identifiers{"name", "greeting"}, func(args []interface{}) (interface{}, error) {
+		return fmt.Sprintf("%s, %s!", args[1], args[0]), nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	actual, err := vm.EvaluateAnonymousSnippet("greet.jsonnet", `{
+		positional: std.greet('World', 'Hello'),
+		named: std.greet(greeting='Hi', name='there'),
+		hidden: std.objectHas(std, 'greet'),
+		member: std.objectHasAll(std, 'greet'),
+	}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "hidden": false, "member": true, "named": "Hi, there!", "positional": "Hello, World!" }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	for _, name := range []string{"length", "manifestJson", "thisFile"} {
+		err = vm.AddStdFunc(name, ast.Identifiers{"x"}, func(args []interface{}) (interface{}, error) {
+			return nil, nil
+		})
+		expectedErr := fmt.Sprintf("std.%s is already defined", name)
+		if err == nil || err.Error() != expectedErr {
+			t.Errorf("Expected error %q, but got %v", expectedErr, err)
+		}
+	}
+}
+
 func TestNotifier_OnGeneratedValue(t *testing.T) {
 	notifier := &testNotifier{}
 	vm := MakeVM()
@@ -605,3 +1203,1959 @@ func (n *testNotifier) OnGeneratedValue(fnName string, args []interface{}, parti
 		steps:        steps,
 	})
 }
+
+func TestFieldTransformHook(t *testing.T) {
+	vm := MakeVM()
+	var paths []string
+	vm.SetFieldTransformHook(func(path []interface{}, value interface{}) interface{} {
+		paths = append(paths, fmt.Sprint(path...))
+		if len(path) > 0 && path[len(path)-1] == (ObjectFieldStep{Field: "password"}) {
+			return "<redacted>"
+		}
+		return value
+	})
+	actual, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `{
+		user: 'admin',
+		password: 'secret',
+		databases: [{ name: 'db', password: 'db-secret' }],
+		config: std.manifestJson({ password: 'inner' }),
+	}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "config": "{\n \"password\": \"inner\"\n}", "databases": [ { "name": "db", "password": "<redacted>" } ], "password": "<redacted>", "user": "admin" }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+	sort.Strings(paths)
+	expectedPaths := []string{"{config}", "{databases} {0} {name}", "{databases} {0} {password}", "{password}", "{user}"}
+	if !reflect.DeepEqual(paths, expectedPaths) {
+		t.Errorf("Expected paths %v, but got %v", expectedPaths, paths)
+	}
+
+	vm.SetFieldTransformHook(nil)
+	actual, err = vm.EvaluateAnonymousSnippet("test.jsonnet", `{ password: 'secret' }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = `{ "password": "secret" }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+}
+
+func TestManifestYamlDocAmbiguousScalars(t *testing.T) {
+	ambiguous := []string{
+		"yes", "No", "ON", "off", "y", "N", "true", "False", "null", "Null", "~", "",
+		"1", "-1", "1_000", "1.0", "-1.5", "1e3", "-1E5", "1.5e3", ".5", "0b101", "0o17", "017", "0x1F",
+		".inf", "-.Inf", ".NaN", "2001-12-14", "1:20", "-", "---", "<<", "=", "plain",
+	}
+	ambiguousJSON, err := json.Marshal(ambiguous)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	vm := MakeVM()
+	vm.ExtCode("ambiguous", string(ambiguousJSON))
+	for _, quoteKeys := range []string{"true", "false"} {
+		output, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `
+			local ambiguous = std.extVar('ambiguous');
+			std.manifestYamlDoc({ keys: { [s]: s for s in ambiguous }, values: ambiguous }, quote_keys=`+quoteKeys+`)`)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var doc string
+		if err := json.Unmarshal([]byte(output), &doc); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		reparsed, err := yaml.YAMLToJSONStrict([]byte(doc))
+		if err != nil {
+			t.Fatalf("quote_keys=%s: unexpected error: %v", quoteKeys, err)
+		}
+		var actual struct {
+			Keys   map[string]interface{}
+			Values []interface{}
+		}
+		if err := json.Unmarshal(reparsed, &actual); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for index, s := range ambiguous {
+			if actual.Keys[s] != s {
+				t.Errorf("quote_keys=%s: expected the key %q to stay a string, but got %v", quoteKeys, s, actual.Keys)
+			}
+			if index >= len(actual.Values) || actual.Values[index] != s {
+				t.Errorf("quote_keys=%s: expected the value %q to stay a string, but got %v", quoteKeys, s, actual.Values)
+			}
+		}
+	}
+}
+
+func TestRejectNullOutput(t *testing.T) {
+	vm := MakeVM()
+	vm.SetRejectNullOutput(true)
+	actual, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `{ a: [1, 'null'], b: std.manifestJson(null), c: std.prune({ d: null }) }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "a": [ 1, "null" ], "b": "null", "c": { } }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	tests := map[string]string{
+		`{ spec: { replicas: null, name: 'web' } }`: "null is not allowed in the output, found at /spec/replicas",
+		`{ containers: [{ ports: [80, null] }] }`:   "null is not allowed in the output, found at /containers/0/ports/1",
+		`{ b: null, a: { 'x/y': null } }`:           "null is not allowed in the output, found at /a/x~1y",
+		`null`:                                      "null is not allowed in the output",
+	}
+	for snippet, expectedErr := range tests {
+		_, err := vm.EvaluateAnonymousSnippet("test.jsonnet", snippet)
+		if err == nil || !strings.Contains(err.Error(), expectedErr) {
+			t.Errorf("%s: expected the error %q, but got %v", snippet, expectedErr, err)
+		}
+	}
+}
+
+func TestEvaluateSnippetField(t *testing.T) {
+	vm := MakeVM()
+	snippet := `{
+		prod: { web: { replicas: 3, ports: [80, 443] }, name:: 'production' },
+		dev: { web: { replicas: 1 } },
+		broken: error 'not evaluated',
+	}`
+	tests := map[string]string{
+		"prod/web":         `{ "ports": [ 80, 443 ], "replicas": 3 }`,
+		"prod.web":         `{ "ports": [ 80, 443 ], "replicas": 3 }`,
+		"/dev/web/":        `{ "replicas": 1 }`,
+		"prod.web.ports.1": `443`,
+		"prod.name":        `"production"`,
+	}
+	for fieldPath, expected := range tests {
+		actual, err := vm.EvaluateSnippetField("configs.jsonnet", snippet, fieldPath)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", fieldPath, err)
+		}
+		if removeExcessiveWhitespace(actual) != expected {
+			t.Errorf("%s: expected %q, but got %q", fieldPath, expected, removeExcessiveWhitespace(actual))
+		}
+	}
+
+	errorTests := map[string]string{
+		"staging/web":         "field path staging.web: the result has no field \"staging\"",
+		"prod/db":             "field path prod.db: prod has no field \"db\"",
+		"prod/web/ports/2":    "field path prod.web.ports.2: prod.web.ports is an array of length 2, \"2\" is not a valid index",
+		"prod/web/replicas/x": "field path prod.web.replicas.x: prod.web.replicas is number, not an object or an array",
+		"broken":              "not evaluated",
+	}
+	for fieldPath, expectedErr := range errorTests {
+		_, err := vm.EvaluateSnippetField("configs.jsonnet", snippet, fieldPath)
+		if err == nil || !strings.Contains(err.Error(), expectedErr) {
+			t.Errorf("%s: expected the error %q, but got %v", fieldPath, expectedErr, err)
+		}
+	}
+}
+
+func TestEvaluateSnippetTimed(t *testing.T) {
+	vm := MakeVM()
+	actual, timings, err := vm.EvaluateSnippetTimed("timed.jsonnet", `{ a: std.length([1, 2, 3]) }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "{\n   \"a\": 3\n}\n"; actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+	if timings.Evaluation <= 0 {
+		t.Errorf("Expected evaluation time to be measured, got %v", timings)
+	}
+
+	_, timings, err = vm.EvaluateSnippetTimed("timed.jsonnet", `{ a: }`)
+	if err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+	if timings.Desugaring != 0 || timings.StaticAnalysis != 0 || timings.Evaluation != 0 {
+		t.Errorf("Expected phases after parsing to be skipped, got %v", timings)
+	}
+}
+
+func TestEvaluateDetailed(t *testing.T) {
+	vm := MakeVM()
+	var traceOut bytes.Buffer
+	vm.SetTraceOut(&traceOut)
+	vm.Importer(&MemoryImporter{Data: map[string]Contents{
+		"lib.libsonnet": MakeContents(`{ x: std.trace("in lib", 1) }`),
+		"data.txt":      MakeContents("text"),
+	}})
+	result, err := vm.EvaluateDetailed("main.jsonnet", `
+		local lib = import "lib.libsonnet";
+		{ a: std.trace("in main", lib.x), b: importstr "data.txt" }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := `{ "a": 1, "b": "text" }`; removeExcessiveWhitespace(result.Output) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(result.Output))
+	}
+	expectedTraces := []string{"TRACE: lib.libsonnet:1 in lib", "TRACE: main.jsonnet:3 in main"}
+	if !reflect.DeepEqual(result.Traces, expectedTraces) {
+		t.Errorf("Expected traces %q, but got %q", expectedTraces, result.Traces)
+	}
+	expectedFiles := []string{"data.txt", "lib.libsonnet"}
+	if !reflect.DeepEqual(result.ImportedFiles, expectedFiles) {
+		t.Errorf("Expected imported files %q, but got %q", expectedFiles, result.ImportedFiles)
+	}
+	if result.Timings.Evaluation <= 0 {
+		t.Errorf("Expected evaluation time to be measured, got %v", result.Timings)
+	}
+	if traceOut.Len() != 0 {
+		t.Errorf("Expected no traces written to the trace output, got %q", traceOut.String())
+	}
+
+	// The imports are cached now, but they are still reported.
+	result, err = vm.EvaluateDetailed("main.jsonnet", `std.trace("before", (import "lib.libsonnet").x) + error "boom"`)
+	if err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+	if expected := []string{"lib.libsonnet"}; !reflect.DeepEqual(result.ImportedFiles, expected) {
+		t.Errorf("Expected imported files %q, but got %q", expected, result.ImportedFiles)
+	}
+	if len(result.Traces) != 1 {
+		t.Errorf("Expected the trace before the failure to be collected, got %q", result.Traces)
+	}
+
+	// The trace output is restored afterwards.
+	if _, err := vm.EvaluateAnonymousSnippet("main.jsonnet", `std.trace("after", 1)`); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "TRACE: main.jsonnet:1 after\n"; traceOut.String() != expected {
+		t.Errorf("Expected %q, but got %q", expected, traceOut.String())
+	}
+
+	// A crash is reported as an error, without any output.
+	vm.Importer(panickingImporter{})
+	result, err = vm.EvaluateDetailed("main.jsonnet", `import "lib.libsonnet"`)
+	if err == nil || !strings.Contains(err.Error(), "importer crashed") {
+		t.Errorf("Expected the crash error, but got %v", err)
+	}
+	if result.Output != "" {
+		t.Errorf("Expected no output, but got %q", result.Output)
+	}
+}
+
+func TestPartialEval(t *testing.T) {
+	snippets := []string{
+		`{ env: std.extVar("env"), replicas: if std.extVar("env") == "prod" then 3 * 2 else 1 }`,
+		`local env = std.extVar("env"); { name: "app-" + env, [env + "Only"]: true, debug: env != "prod" }`,
+		`{ local prefix = "app", name: prefix + "-" + std.extVar("region"), all: self.name + std.extVar("env") }`,
+		`local x = 1; { shadowed: (function(x) x + 1)(5), outer: x + 1 }`,
+		`local std2 = std; { a: std2.extVar("env"), b: -(1 + 2), c: !(1 < 2) }`,
+		`[1 / 4, 2 - 0.5, "a" < "b", null == false, 1e3 >= 1000]`,
+		`{ assert std.extVar("env") == "prod", x: 1 }`,
+	}
+	vm := MakeVM()
+	vm.ExtVar("env", "prod")
+	vm.ExtVar("region", "eu")
+	for _, snippet := range snippets {
+		node, err := SnippetToAST("partial.jsonnet", snippet)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		folded, err := vm.PartialEval(node, map[string]string{"env": "prod"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected, err := vm.Evaluate(node)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		actual, err := vm.Evaluate(folded)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if actual != expected {
+			t.Errorf("Expected %q, but got %q for %s", expected, actual, snippet)
+		}
+	}
+}
+
+func TestPartialEvalKeepsErrors(t *testing.T) {
+	vm := MakeVM()
+	node, err := SnippetToAST("partial.jsonnet", `{ a: 1 / 0, b: std.extVar("missing") }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	folded, err := vm.PartialEval(node, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, expectedErr := vm.Evaluate(node)
+	_, actualErr := vm.Evaluate(folded)
+	if expectedErr == nil || actualErr == nil || actualErr.Error() != expectedErr.Error() {
+		t.Errorf("Expected error %v, but got %v", expectedErr, actualErr)
+	}
+}
+
+func TestMapWithKeyLaziness(t *testing.T) {
+	var calls []string
+	vm := MakeVM()
+	vm.NativeFunction(&NativeFunction{
+		Name:   "record",
+		Params: ast.Identifiers{"key", "value"},
+		Func: func(params []interface{}) (interface{}, error) {
+			calls = append(calls, params[0].(string))
+			return params[1], nil
+		},
+	})
+	actual, err := vm.EvaluateAnonymousSnippet("mapWithKey.jsonnet", `
+		local mapped = std.mapWithKey(std.native('record'), { a: 1, b: 2, c: 3 });
+		[mapped.b, mapped.b, std.objectFields(mapped)]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := `[ 2, 2, [ "a", "b", "c" ] ]`; removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+	if !reflect.DeepEqual(calls, []string{"b"}) {
+		t.Errorf("Expected the function to be called only for the accessed field, got %v", calls)
+	}
+}
+
+func TestValidateStaticError(t *testing.T) {
+	tests := []struct {
+		name     string
+		snippet  string
+		message  string
+		location string
+	}{
+		{"lexing", "{ a: 'foo }", "Unterminated String", "main.jsonnet:1:6"},
+		{"parsing", "{ a: }", "Unexpected: \"}\" while parsing terminal", "main.jsonnet:1:6-7"},
+		{"desugaring", "[$.a]", "No top-level object found.", "main.jsonnet:1:2-3"},
+		{"static analysis", "{ a: b }", "Unknown variable: b", "main.jsonnet:1:6-7"},
+	}
+	vm := MakeVM()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := vm.Validate("main.jsonnet", test.snippet)
+			staticErr, ok := err.(StaticError)
+			if !ok {
+				t.Fatalf("Expected StaticError, got %#v", err)
+			}
+			if staticErr.Message != test.message {
+				t.Errorf("Expected message %q, but got %q", test.message, staticErr.Message)
+			}
+			if staticErr.Loc.String() != test.location {
+				t.Errorf("Expected location %q, but got %q", test.location, staticErr.Loc.String())
+			}
+			if expected := test.location + " " + test.message; staticErr.Error() != expected {
+				t.Errorf("Expected %q, but got %q", expected, staticErr.Error())
+			}
+		})
+	}
+
+	vm.Bind("myVar", &ast.LiteralString{Value: "bar"})
+	if err := vm.Validate("main.jsonnet", `{ a: myVar, b: std.length([]) }`); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := SnippetToAST("main.jsonnet", `myVar`); err == nil {
+		t.Errorf("Expected error, got nil")
+	} else if staticErr, ok := err.(StaticError); !ok || staticErr.Loc.Begin.Column != 1 {
+		t.Errorf("Expected StaticError at column 1, got %#v", err)
+	}
+}
+
+func TestSnapshotImporter(t *testing.T) {
+	root := t.TempDir()
+	writeFile := func(name, content string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	writeFile("lib/lib.libsonnet", `{ name: importstr "name.txt", bytes: importbin "../data.bin" }`)
+	writeFile("lib/name.txt", "snapshot")
+	writeFile("data.bin", "\x00\xff")
+
+	importer, err := NewSnapshotImporter(root)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// Later changes of the directory are not visible.
+	writeFile("lib/name.txt", "changed")
+	writeFile("added.libsonnet", "{}")
+
+	vm := MakeVM()
+	vm.Importer(importer)
+	actual, err := vm.EvaluateAnonymousSnippet("main.jsonnet", `import "lib/lib.libsonnet"`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := `{ "bytes": [ 0, 255 ], "name": "snapshot" }`; removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+	_, err = vm.EvaluateAnonymousSnippet("main.jsonnet", `import "added.libsonnet"`)
+	if err == nil || !strings.Contains(err.Error(), "no match in the snapshot") {
+		t.Errorf("Expected the file added later not to be found, got %v", err)
+	}
+
+	if _, err := NewSnapshotImporter(filepath.Join(root, "missing")); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestSnapshotImporterSymlinks(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "lib"), 0755); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, "lib", "name.txt"), []byte("target"), 0644); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	links := map[string]string{
+		"name.txt": filepath.Join("lib", "name.txt"),
+		"dir":      "lib",
+		"dangling": "missing.txt",
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("Symbolic links are not supported: %v", err)
+		}
+	}
+
+	importer, err := NewSnapshotImporter(root)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	vm := MakeVM()
+	vm.Importer(importer)
+	actual, err := vm.EvaluateAnonymousSnippet("main.jsonnet", `importstr "name.txt"`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "\"target\"\n"; actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+	for _, path := range []string{"dir/name.txt", "dangling"} {
+		_, err = vm.EvaluateAnonymousSnippet("main.jsonnet", fmt.Sprintf("importstr %q", path))
+		if err == nil || !strings.Contains(err.Error(), "no match in the snapshot") {
+			t.Errorf("%s: expected the skipped link not to be found, got %v", path, err)
+		}
+	}
+
+	// The root can be a link to a directory too, the files are found under the link.
+	linkedRoot := filepath.Join(t.TempDir(), "linked")
+	if err := os.Symlink(filepath.Join(root, "lib"), linkedRoot); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	importer, err = NewSnapshotImporter(linkedRoot)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	vm.Importer(importer)
+	for _, path := range []string{"name.txt", filepath.Join(linkedRoot, "name.txt")} {
+		actual, err = vm.EvaluateAnonymousSnippet("main.jsonnet", fmt.Sprintf("importstr %q", path))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := "\"target\"\n"; actual != expected {
+			t.Errorf("Expected %q, but got %q", expected, actual)
+		}
+	}
+	_, err = vm.EvaluateAnonymousSnippet("main.jsonnet", `importstr "missing.txt"`)
+	if err == nil || !strings.Contains(err.Error(), "no match in the snapshot of "+linkedRoot) {
+		t.Errorf("Expected the error to name the linked root, got %v", err)
+	}
+}
+
+func TestMaxImports(t *testing.T) {
+	vm := MakeVM()
+	vm.Importer(&MemoryImporter{Data: map[string]Contents{
+		"a.libsonnet": MakeContents(`import "b.libsonnet"`),
+		"b.libsonnet": MakeContents(`1`),
+		"c.txt":       MakeContents(`c`),
+	}})
+	vm.SetMaxImports(2)
+
+	// Importing the same file again does not count.
+	actual, err := vm.EvaluateAnonymousSnippet("main.jsonnet", `[import "a.libsonnet", import "a.libsonnet", import "b.libsonnet"]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := `[ 1, 1, 1 ]`; removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	_, err = vm.EvaluateAnonymousSnippet("main.jsonnet", `[import "a.libsonnet", importstr "c.txt"]`)
+	if err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+	if expected := `max number of imported files exceeded (2) when importing "c.txt"`; !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected error containing %q, but got %q", expected, err.Error())
+	}
+
+	// The counter starts from zero in every evaluation.
+	if _, err := vm.EvaluateAnonymousSnippet("main.jsonnet", `importbin "c.txt"`); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+
+	vm.SetMaxImports(0)
+	if _, err := vm.EvaluateAnonymousSnippet("main.jsonnet", `[import "a.libsonnet", importstr "c.txt"]`); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+}
+
+func TestPreamble(t *testing.T) {
+	vm := MakeVM()
+	err := vm.SetPreamble(`
+		local greeting = 'Hello';
+		local greet(name) = greeting + ', ' + name + '!';
+	`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	actual, err := vm.EvaluateAnonymousSnippet("snippet.jsonnet", `{ message: greet('World') }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "message": "Hello, World!" }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	// The locations in the snippet are not shifted by the preamble.
+	_, err = vm.EvaluateAnonymousSnippet("snippet.jsonnet", "\n\nerror greet('error')")
+	if err == nil || !strings.Contains(err.Error(), "snippet.jsonnet:3:1-21") {
+		t.Errorf("Expected the error to be located in the snippet, but got %v", err)
+	}
+	_, err = vm.EvaluateAnonymousSnippet("snippet.jsonnet", "\nunknown")
+	if err == nil || !strings.Contains(err.Error(), "snippet.jsonnet:2:1-8 Unknown variable: unknown") {
+		t.Errorf("Expected the error to be located in the snippet, but got %v", err)
+	}
+
+	for _, code := range []string{"local a = ", "local a = 1; a + 1", "local a = unknown;"} {
+		if err := vm.SetPreamble(code); err == nil {
+			t.Errorf("Expected an error for the preamble %q", code)
+		}
+	}
+
+	if err := vm.SetPreamble(""); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	_, err = vm.EvaluateAnonymousSnippet("snippet.jsonnet", `greet('World')`)
+	if err == nil || !strings.Contains(err.Error(), "Unknown variable: greet") {
+		t.Errorf("Expected the preamble to be removed, but got %v", err)
+	}
+}
+
+func TestNativeCallHook(t *testing.T) {
+	vm := MakeVM()
+	called := map[string]int{}
+	for _, name := range []string{"allowed", "blocked"} {
+		name := name
+		vm.NativeFunction(&NativeFunction{
+			Name:   name,
+			Params: ast.Identifiers{"x"},
+			Func: func(params []interface{}) (interface{}, error) {
+				called[name]++
+				return params[0], nil
+			},
+		})
+	}
+	var calls []string
+	vm.SetNativeCallHook(func(name string, args []interface{}) error {
+		calls = append(calls, fmt.Sprint(name, args))
+		if name == "blocked" {
+			return fmt.Errorf("native function %s is not allowed", name)
+		}
+		return nil
+	})
+
+	actual, err := vm.EvaluateAnonymousSnippet("native.jsonnet", `std.native('allowed')({ a: 1 })`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "a": 1 }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	_, err = vm.EvaluateAnonymousSnippet("native.jsonnet", `std.native('blocked')(1)`)
+	if err == nil || !strings.Contains(err.Error(), "native function blocked is not allowed") {
+		t.Errorf("Expected the call to be blocked, but got %v", err)
+	}
+	if called["blocked"] != 0 {
+		t.Errorf("Expected the blocked function not to be called, but it was called %d times", called["blocked"])
+	}
+	expectedCalls := []string{"allowed[map[a:1]]", "blocked[1]"}
+	if !reflect.DeepEqual(calls, expectedCalls) {
+		t.Errorf("Expected calls %v, but got %v", expectedCalls, calls)
+	}
+
+	vm.SetNativeCallHook(nil)
+	_, err = vm.EvaluateAnonymousSnippet("native.jsonnet", `std.native('blocked')(1)`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if called["blocked"] != 1 {
+		t.Errorf("Expected the blocked function to be called once, but it was called %d times", called["blocked"])
+	}
+}
+
+func TestNativeFunctionRawJSON(t *testing.T) {
+	vm := MakeVM()
+	vm.NativeFunction(&NativeFunction{
+		Name:   "fetch",
+		Params: ast.Identifiers{"id"},
+		Func: func(params []interface{}) (interface{}, error) {
+			return json.RawMessage(`{"id": 9007199254740991, "size": 1234567890123, "tags": ["a", null, true], "ratio": 0.5}`), nil
+		},
+	})
+	vm.NativeFunction(&NativeFunction{
+		Name:   "invalid",
+		Params: ast.Identifiers{},
+		Func: func(params []interface{}) (interface{}, error) {
+			return json.RawMessage(`{"a": 1} {}`), nil
+		},
+	})
+	vm.NativeFunction(&NativeFunction{
+		Name:   "count",
+		Params: ast.Identifiers{},
+		Func: func(params []interface{}) (interface{}, error) {
+			return int64(42), nil
+		},
+	})
+
+	actual, err := vm.EvaluateAnonymousSnippet("native.jsonnet", `
+		local result = std.native('fetch')(1);
+		[result, result.id - 1, std.native('count')()]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `[ { "id": 9007199254740991, "ratio": 0.5, "size": 1234567890123, "tags": [ "a", null, true ] }, 9007199254740990, 42 ]`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	_, err = vm.EvaluateAnonymousSnippet("native.jsonnet", `std.native('invalid')()`)
+	if err == nil || !strings.Contains(err.Error(), "unexpected data after the value") {
+		t.Errorf("Expected error about the trailing data, got %v", err)
+	}
+}
+
+func TestBuiltinParamTypeErrors(t *testing.T) {
+	tests := []struct {
+		snippet  string
+		expected string
+	}{
+		{`std.objectFields(importbin "data.bin")`, "std.objectFields first param must be object, got array"},
+		{`std.objectFieldsAll("abc")`, "std.objectFieldsAll first param must be object, got string"},
+		{`std.objectValues([])`, "std.objectValues first param must be object, got array"},
+		{`std.objectHas({}, 1)`, "std.objectHas second param must be string, got number"},
+		{`std.objectHasAll(null, "a")`, "std.objectHasAll first param must be object, got null"},
+		{`std.length(42)`, "std.length first param must be array, string, object or function, got number"},
+		{`std.sort({})`, "std.sort first param must be array, got object"},
+		{`std.sort([], 1)`, "std.sort second param must be function, got number"},
+		{`std.minArray("abc")`, "std.minArray first param must be array, got string"},
+		{`std.contains({}, 1)`, "std.contains first param must be array, got object"},
+		{`std.mapWithKey(function(k, v) v, importbin "data.bin")`, "std.mapWithKey second param must be object, got array"},
+		{`std.join(",", 1)`, "std.join second param must be array, got number"},
+		{`std.join(1, [])`, "std.join first param must be string or array, got number"},
+		{`std.sum(1)`, "std.sum first param must be array, got number"},
+		{`std.avg("abc")`, "std.avg first param must be array, got string"},
+		{`std.getPath({}, "a")`, "std.getPath second param must be array, got string"},
+		{`std.objectHasPath({}, {})`, "std.objectHasPath second param must be array, got object"},
+		{`std.decodeUTF8("abc")`, "std.decodeUTF8 first param must be array, got string"},
+		{`std.dirname(1)`, "std.dirname first param must be string, got number"},
+		{`std.basename([])`, "std.basename first param must be string, got array"},
+		{`std.trim(null)`, "std.trim first param must be string, got null"},
+	}
+	vm := MakeVM()
+	vm.Importer(&MemoryImporter{Data: map[string]Contents{"data.bin": MakeContentsRaw([]byte{1, 2})}})
+	for _, test := range tests {
+		_, err := vm.EvaluateAnonymousSnippet("main.jsonnet", test.snippet)
+		if err == nil {
+			t.Errorf("Expected error for %s, got nil", test.snippet)
+			continue
+		}
+		if !strings.Contains(err.Error(), "RUNTIME ERROR: "+test.expected+"\n") {
+			t.Errorf("Expected error %q for %s, but got %q", test.expected, test.snippet, err.Error())
+		}
+	}
+}
+
+func TestBreakpoint(t *testing.T) {
+	snippet := "local f(x) = x * 2;\nlocal a = 1;\nf(a + 3)\n"
+	vm := MakeVM()
+	var paused []StackFrame
+	vm.SetStepHook(func(frame StackFrame) StepAction {
+		paused = append(paused, frame)
+		return StepContinue
+	})
+	vm.SetBreakpoint(ast.Location{Line: 3})
+	vm.SetBreakpoint(ast.Location{Line: 1, Column: 14})
+
+	actual, err := vm.EvaluateAnonymousSnippet("main.jsonnet", snippet)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "8\n"; actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+	// The argument is evaluated lazily inside of the function, and it pauses again on line 3.
+	if len(paused) != 3 {
+		t.Fatalf("Expected 3 pauses, but got %d", len(paused))
+	}
+	if _, ok := paused[0].Node.(*ast.Apply); !ok || paused[0].Loc.String() != "main.jsonnet:3:1-9" {
+		t.Errorf("Expected to pause at the call main.jsonnet:3:1-9, but got %T at %s", paused[0].Node, paused[0].Loc.String())
+	}
+	if _, ok := paused[1].Node.(*ast.Binary); !ok || paused[1].Loc.String() != "main.jsonnet:1:14-19" {
+		t.Errorf("Expected to pause at the function body main.jsonnet:1:14-19, but got %T at %s", paused[1].Node, paused[1].Loc.String())
+	}
+	if callers := paused[1].Callers; len(callers) != 1 || callers[0].String() != "main.jsonnet:3:1-9" {
+		t.Errorf("Expected the call main.jsonnet:3:1-9 as the only caller, but got %v", callers)
+	}
+	if paused[2].Loc.String() != "main.jsonnet:3:3-8" {
+		t.Errorf("Expected to pause at the argument main.jsonnet:3:3-8, but got %s", paused[2].Loc.String())
+	}
+}
+
+func TestLineBreakpointOnce(t *testing.T) {
+	vm := MakeVM()
+	var paused []string
+	vm.SetStepHook(func(frame StackFrame) StepAction {
+		paused = append(paused, frame.Loc.String())
+		return StepContinue
+	})
+	vm.SetBreakpoint(ast.Location{Line: 2})
+
+	if _, err := vm.EvaluateAnonymousSnippet("main.jsonnet", "local f(x) = x * 2;\n{ a: f(1) + f(2) }"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	// The field and the arguments are evaluated lazily on the same line, they don't pause again.
+	expected := []string{"main.jsonnet:2:1-19"}
+	if !reflect.DeepEqual(paused, expected) {
+		t.Errorf("Expected to pause at %v, but got %v", expected, paused)
+	}
+}
+
+func TestBreakpointLiteral(t *testing.T) {
+	vm := MakeVM()
+	var paused []string
+	vm.SetStepHook(func(frame StackFrame) StepAction {
+		paused = append(paused, frame.Loc.String())
+		return StepContinue
+	})
+	vm.SetBreakpoint(ast.Location{Line: 1, Column: 1})
+
+	if _, err := vm.EvaluateAnonymousSnippet("main.jsonnet", `{ "a": 1 }`); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"main.jsonnet:1:1-11"}
+	if !reflect.DeepEqual(paused, expected) {
+		t.Errorf("Expected to pause at %v, but got %v", expected, paused)
+	}
+}
+
+func TestStepInto(t *testing.T) {
+	vm := MakeVM()
+	var paused []string
+	vm.SetStepHook(func(frame StackFrame) StepAction {
+		paused = append(paused, frame.Loc.String())
+		if len(paused) < 3 {
+			return StepInto
+		}
+		return StepContinue
+	})
+	vm.SetBreakpoint(ast.Location{Line: 1, Column: 1})
+
+	if _, err := vm.EvaluateAnonymousSnippet("main.jsonnet", "(1 + 2) * 3"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := []string{"main.jsonnet:1:1-12", "main.jsonnet:1:2-7", "main.jsonnet:1:2-3"}
+	if !reflect.DeepEqual(paused, expected) {
+		t.Errorf("Expected to pause at %v, but got %v", expected, paused)
+	}
+}
+
+func TestEvaluateSnippetAs(t *testing.T) {
+	vm := MakeVM()
+	vm.RegisterOutputFormat("env", OutputFormatterFunc(func(value interface{}) ([]byte, error) {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected an object, got %T", value)
+		}
+		var keys []string
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var buf bytes.Buffer
+		for _, k := range keys {
+			fmt.Fprintf(&buf, "%s=%v\n", k, obj[k])
+		}
+		return buf.Bytes(), nil
+	}))
+	snippet := `{ PORT: 8080, HOST: 'localhost', DEBUG: true }`
+	tests := map[string]string{
+		"env":  "DEBUG=true\nHOST=localhost\nPORT=8080\n",
+		"yaml": "DEBUG: true\nHOST: \"localhost\"\nPORT: 8080\n",
+		"json": "{\n   \"DEBUG\": true,\n   \"HOST\": \"localhost\",\n   \"PORT\": 8080\n}\n",
+	}
+	for format, expected := range tests {
+		actual, err := vm.EvaluateSnippetAs(format, "test.jsonnet", snippet)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", format, err)
+		}
+		if string(actual) != expected {
+			t.Errorf("%s: expected %q, but got %q", format, expected, string(actual))
+		}
+	}
+
+	// The builtin formats follow the output settings.
+	vm.SetLineSeparator("\r\n")
+	vm.SetObjectPadding(false)
+	vm.StringOutput = true
+	styled := map[string]string{
+		"yaml": "a:\r\n- 1\r\n- {}\r\nb: |\r\n  x\r\n  y\r\n",
+		"json": "{\r\n   \"a\": [\r\n      1,\r\n      {}\r\n   ],\r\n   \"b\": \"x\\ny\\n\"\r\n}\r\n",
+	}
+	for format, expected := range styled {
+		actual, err := vm.EvaluateSnippetAs(format, "test.jsonnet", `{ a: [1, {}], b: 'x\ny\n' }`)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", format, err)
+		}
+		if string(actual) != expected {
+			t.Errorf("%s: expected %q, but got %q", format, expected, string(actual))
+		}
+	}
+
+	if _, err := vm.EvaluateSnippetAs("env", "test.jsonnet", `[1]`); err == nil || !strings.Contains(err.Error(), "expected an object, got []interface {}") {
+		t.Errorf("Expected the formatter error, but got %v", err)
+	}
+	if _, err := vm.EvaluateSnippetAs("hcl", "test.jsonnet", snippet); err == nil || err.Error() != `unknown output format "hcl"` {
+		t.Errorf("Expected the unknown format error, but got %v", err)
+	}
+
+	if _, ok := vm.OutputFormat("hcl"); ok {
+		t.Errorf("Expected no hcl format")
+	}
+
+	// The builtin formats can be wrapped.
+	yamlFormat, ok := vm.OutputFormat("yaml")
+	if !ok {
+		t.Fatalf("Expected the yaml format")
+	}
+	vm.RegisterOutputFormat("yaml-stream", OutputFormatterFunc(func(value interface{}) ([]byte, error) {
+		doc, err := yamlFormat.Format(value)
+		return append([]byte("---\r\n"), doc...), err
+	}))
+	actual, err := vm.EvaluateSnippetAs("yaml-stream", "test.jsonnet", `{ a: 1 }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "---\r\na: 1\r\n"; string(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, string(actual))
+	}
+}
+
+func TestEvaluateToAST(t *testing.T) {
+	vm := MakeVM()
+	node, err := vm.EvaluateToAST("main.jsonnet", `{ b: [1.5, "x", null], a:: "hidden", c: { d: 1 == 1 } }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	str := func(s string) *ast.LiteralString {
+		return &ast.LiteralString{Value: s, Kind: ast.StringDouble}
+	}
+	field := func(name string, body ast.Node) ast.ObjectField {
+		return ast.ObjectField{Kind: ast.ObjectFieldStr, Hide: ast.ObjectFieldInherit, Expr1: str(name), Expr2: body}
+	}
+	expected := &ast.Object{Fields: ast.ObjectFields{
+		field("b", &ast.Array{Elements: []ast.CommaSeparatedExpr{
+			{Expr: &ast.LiteralNumber{OriginalString: "1.5"}},
+			{Expr: str("x")},
+			{Expr: &ast.LiteralNull{}},
+		}}),
+		field("c", &ast.Object{Fields: ast.ObjectFields{
+			field("d", &ast.LiteralBoolean{Value: true}),
+		}}),
+	}}
+	if !reflect.DeepEqual(node, expected) {
+		t.Errorf("Expected %#v, but got %#v", expected, node)
+	}
+
+	if _, err := vm.EvaluateToAST("main.jsonnet", `{ a: error "x" }`); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestStdlibDisabled(t *testing.T) {
+	vm := MakeVM()
+	vm.SetStdlibEnabled(false)
+
+	_, err := vm.EvaluateAnonymousSnippet("main.jsonnet", `std.length([])`)
+	if err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+	if expected := "std is disabled"; !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected error containing %q, but got %q", expected, err.Error())
+	}
+
+	// The operators desugared to the standard library calls keep working.
+	actual, err := vm.EvaluateAnonymousSnippet("main.jsonnet", `local std = 1; ["%d" % std, [1, 2, 3][1:], { a: 1 } == { a: 1 }]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := `[ "1", [ 2, 3 ], true ]`; removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	vm.SetStdlibEnabled(true)
+	actual, err = vm.EvaluateAnonymousSnippet("main.jsonnet", `std.length([])`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "0\n"; actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+}
+
+func TestEmitComments(t *testing.T) {
+	snippet := `local base = {
+  // The port to listen on.
+  port: 80,
+};
+base {
+  port: 8080,
+  # Where to connect.
+  hosts: [{
+    name: 'a',  // Not documenting the next field.
+    ip: '10.0.0.1',
+  }],
+}`
+	vm := MakeVM()
+	vm.SetEmitComments(true)
+	actual, err := vm.EvaluateAnonymousSnippet("main.jsonnet", snippet)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{
+   // Where to connect.
+   "hosts": [
+      {
+         "ip": "10.0.0.1",
+         "name": "a"
+      }
+   ],
+   // The port to listen on.
+   "port": 8080
+}
+`
+	if actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+
+	// A literal document is not evaluated, but its comments are emitted too.
+	actual, err = vm.EvaluateAnonymousSnippet("main.json", "{\n  // The port.\n  \"port\": 80\n}")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = "{\n   // The port.\n   \"port\": 80\n}\n"
+	if actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+
+	vm.SetEmitComments(false)
+	actual, err = vm.EvaluateAnonymousSnippet("main.jsonnet", snippet)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if strings.Contains(actual, "//") {
+		t.Errorf("Expected no comments, but got %q", actual)
+	}
+}
+
+func TestTabSize(t *testing.T) {
+	vm := MakeVM()
+	vm.SetTabSize(4)
+	_, err := vm.EvaluateAnonymousSnippet("main.jsonnet", "{\n\ta:\t'x' +\t,\n}")
+	if err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+	lines := strings.Split(err.Error(), "\n")
+	if len(lines) < 4 {
+		t.Fatalf("Expected the code and the underline in %q", err.Error())
+	}
+	code, underline := lines[2], lines[3]
+	if expected := "    a:  'x' +   ,"; code != expected {
+		t.Errorf("Expected %q, but got %q", expected, code)
+	}
+	// The caret is under the comma, which follows a tab.
+	if expected := strings.Repeat(" ", strings.Index(code, ",")) + "^"; underline != expected {
+		t.Errorf("Expected %q, but got %q", expected, underline)
+	}
+}
+
+func TestLex(t *testing.T) {
+	tokens, err := Lex("lex.jsonnet", `local x = 'a'; // comment
+{ f: x + 1 }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var actual []string
+	for _, token := range tokens {
+		actual = append(actual, fmt.Sprintf("%d:%d-%d:%d %d %q", token.Loc.Begin.Line, token.Loc.Begin.Column,
+			token.Loc.End.Line, token.Loc.End.Column, token.Kind, token.Value))
+	}
+	expected := []string{
+		fmt.Sprintf(`1:1-1:6 %d "local"`, TokenKeyword),
+		fmt.Sprintf(`1:7-1:8 %d "x"`, TokenIdentifier),
+		fmt.Sprintf(`1:9-1:10 %d "="`, TokenOperator),
+		fmt.Sprintf(`1:11-1:14 %d "a"`, TokenString),
+		fmt.Sprintf(`1:14-1:15 %d ";"`, TokenPunctuation),
+		fmt.Sprintf(`2:1-2:2 %d "{"`, TokenPunctuation),
+		fmt.Sprintf(`2:3-2:4 %d "f"`, TokenIdentifier),
+		fmt.Sprintf(`2:4-2:5 %d ":"`, TokenOperator),
+		fmt.Sprintf(`2:6-2:7 %d "x"`, TokenIdentifier),
+		fmt.Sprintf(`2:8-2:9 %d "+"`, TokenOperator),
+		fmt.Sprintf(`2:10-2:11 %d "1"`, TokenNumber),
+		fmt.Sprintf(`2:12-2:13 %d "}"`, TokenPunctuation),
+		fmt.Sprintf(`2:13-2:13 %d ""`, TokenEndOfFile),
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+
+	_, err = Lex("broken.jsonnet", "'unterminated")
+	if err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestLineSeparator(t *testing.T) {
+	vm := MakeVM()
+	vm.SetLineSeparator("\r\n")
+	actual, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `{ a: [1, "x\ny"] }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "{\r\n   \"a\": [\r\n      1,\r\n      \"x\\ny\"\r\n   ]\r\n}\r\n"
+	if actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+
+	vm.StringOutput = true
+	actual, err = vm.EvaluateAnonymousSnippet("test.jsonnet", `"line1\nline2"`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = "line1\r\nline2\r\n"
+	if actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+
+	actual, err = vm.EvaluateAnonymousSnippet("test.jsonnet", `"line1\r\nline2\n"`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected = "line1\r\nline2\r\n\r\n"
+	if actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+}
+
+func TestNaturalKeyOrder(t *testing.T) {
+	vm := MakeVM()
+	vm.SetNaturalKeyOrder(true)
+	actual, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `{
+		numeric: { "10": 0, "2": 0, "01": 0, "1": 0 },
+		mixed: { "10": 0, "2": 0, a: 0 },
+		fields: std.objectFields({ "10": 0, "2": 0 }),
+	}`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "fields": [ "10", "2" ], "mixed": { "10": 0, "2": 0, "a": 0 }, "numeric": { "01": 0, "1": 0, "2": 0, "10": 0 } }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+}
+
+func TestManifestationPadding(t *testing.T) {
+	snippet := `{ arr: [], obj: {}, nested: [[], {}], str: std.toString([[], {}]) }`
+	tests := []struct {
+		arrayPadding  bool
+		objectPadding bool
+		expected      string
+	}{
+		{true, true, `{ "arr": [ ], "nested": [ [ ], { } ], "obj": { }, "str": "[[ ], { }]" }`},
+		{true, false, `{ "arr": [ ], "nested": [ [ ], {} ], "obj": {}, "str": "[[ ], { }]" }`},
+		{false, true, `{ "arr": [], "nested": [ [], { } ], "obj": { }, "str": "[[ ], { }]" }`},
+		{false, false, `{ "arr": [], "nested": [ [], {} ], "obj": {}, "str": "[[ ], { }]" }`},
+	}
+	for _, test := range tests {
+		vm := MakeVM()
+		vm.SetArrayPadding(test.arrayPadding)
+		vm.SetObjectPadding(test.objectPadding)
+		for _, emitComments := range []bool{false, true} {
+			vm.SetEmitComments(emitComments)
+			actual, err := vm.EvaluateAnonymousSnippet("test.jsonnet", snippet)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if removeExcessiveWhitespace(actual) != test.expected {
+				t.Errorf("Expected %q, but got %q", test.expected, removeExcessiveWhitespace(actual))
+			}
+		}
+	}
+}
+
+func TestEvaluateAndValidate(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"required": ["name", "ports"],
+		"properties": {
+			"name": {"type": "string", "pattern": "^[a-z]+$"},
+			"ports": {"type": "array", "items": {"$ref": "#/$defs/port"}}
+		},
+		"additionalProperties": false,
+		"$defs": {"port": {"type": "integer", "minimum": 1, "maximum": 65535}}
+	}`
+	vm := MakeVM()
+	output, schemaErrors, err := vm.EvaluateAndValidate("valid.jsonnet", `{ name: 'web', ports: [80, 443] }`, schema)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(schemaErrors) != 0 {
+		t.Errorf("Expected no schema errors, but got %v", schemaErrors)
+	}
+	expected := `{ "name": "web", "ports": [ 80, 443 ] }`
+	if removeExcessiveWhitespace(output) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(output))
+	}
+
+	output, schemaErrors, err = vm.EvaluateAndValidate("invalid.jsonnet", `{ name: 'Web', ports: [80, 0, 'x'], extra: true }`, schema)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if output == "" {
+		t.Errorf("Expected the output, got an empty string")
+	}
+	expectedErrors := []SchemaError{
+		{Path: "/extra", Keyword: "additionalProperties", Message: `property "extra" is not allowed`},
+		{Path: "/name", Keyword: "pattern", Message: `string does not match the pattern "^[a-z]+$"`},
+		{Path: "/ports/1", Keyword: "minimum", Message: "0 is less than the minimum 1"},
+		{Path: "/ports/2", Keyword: "type", Message: "expected integer, got string"},
+	}
+	if !reflect.DeepEqual(schemaErrors, expectedErrors) {
+		t.Errorf("Expected %v, but got %v", expectedErrors, schemaErrors)
+	}
+
+	_, _, err = vm.EvaluateAndValidate("test.jsonnet", `{}`, `{"type": 1}`)
+	if err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+
+	for _, schema := range []string{`{"$ref": "#"}`, `{"$defs": {"a": {"$ref": "#/$defs/b"}, "b": {"allOf": [{"$ref": "#/$defs/a"}]}}, "$ref": "#/$defs/a"}`} {
+		_, _, err = vm.EvaluateAndValidate("test.jsonnet", `{}`, schema)
+		if err == nil || !strings.Contains(err.Error(), "refers to itself") {
+			t.Errorf("Expected a reference cycle error, but got %v", err)
+		}
+	}
+
+	// A recursive schema is fine as long as it validates the nested values.
+	tree := `{"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#"}}}}`
+	_, schemaErrors, err = vm.EvaluateAndValidate("test.jsonnet", `{ children: [{ children: [] }, { children: [1] }] }`, tree)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedErrors = []SchemaError{{Path: "/children/1/children/0", Keyword: "type", Message: "expected object, got integer"}}
+	if !reflect.DeepEqual(schemaErrors, expectedErrors) {
+		t.Errorf("Expected %v, but got %v", expectedErrors, schemaErrors)
+	}
+
+	// The output and the validated value are manifested once.
+	calls := 0
+	vm.SetFieldTransformHook(func(path []interface{}, value interface{}) interface{} {
+		calls++
+		return value
+	})
+	if _, _, err = vm.EvaluateAndValidate("test.jsonnet", `{ a: 1, b: [2, 3] }`, `{}`); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected the hook to be called 3 times, but got %d", calls)
+	}
+}
+
+func TestRegisterValueMarshaler(t *testing.T) {
+	vm := MakeVM()
+	vm.RegisterValueMarshaler(reflect.TypeOf(time.Time{}), func(v interface{}) (interface{}, error) {
+		return v.(time.Time).Format(time.RFC3339), nil
+	})
+	created := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	vm.NativeFunction(&NativeFunction{
+		Name:   "created",
+		Params: ast.Identifiers{},
+		Func: func(x []interface{}) (interface{}, error) {
+			return map[string]interface{}{"at": created, "history": []interface{}{created.AddDate(0, 0, -1)}}, nil
+		},
+	})
+	vm.NativeFunction(&NativeFunction{
+		Name:   "duration",
+		Params: ast.Identifiers{},
+		Func: func(x []interface{}) (interface{}, error) {
+			return time.Second, nil
+		},
+	})
+	actual, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `std.native('created')()`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "at": "2024-03-01T12:30:00Z", "history": [ "2024-02-29T12:30:00Z" ] }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	// The types without a marshaler are still rejected.
+	_, err = vm.EvaluateAnonymousSnippet("test.jsonnet", `std.native('duration')()`)
+	if err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestRequiredExtVars(t *testing.T) {
+	vm := MakeVM()
+	tests := []struct {
+		snippet string
+		names   []string
+		dynamic bool
+	}{
+		{`{ a: std.extVar('region'), b: [std.extVar("env"), std.extVar(x='region')] }`, []string{"env", "region"}, false},
+		{`local f(x) = std.extVar('inner' + x); { a: f(std.extVar('suffix')) }`, []string{"suffix"}, true},
+		{`std.map(std.extVar, ['a'])`, nil, true},
+		{`local std = { extVar(x): x }; std.extVar('shadowed')`, nil, false},
+		{`{ a: 1 }`, nil, false},
+	}
+	for _, test := range tests {
+		names, dynamic, err := vm.RequiredExtVars("test.jsonnet", test.snippet)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(names, test.names) || dynamic != test.dynamic {
+			t.Errorf("%s: expected %q (dynamic %v), but got %q (dynamic %v)", test.snippet, test.names, test.dynamic, names, dynamic)
+		}
+	}
+
+	if _, _, err := vm.RequiredExtVars("test.jsonnet", `std.extVar(`); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+func TestValidateExtAndTLA(t *testing.T) {
+	vm := MakeVM()
+	vm.ExtCode("valid", "{ a: 1 }")
+	vm.ExtCode("unterminated", "'foo")
+	vm.ExtCode("unknown", "[x]")
+	vm.ExtVar("plain", "'not code")
+	vm.TLACode("broken", "{ a: }")
+	errs := vm.ValidateExtAndTLA()
+	var actual []string
+	for _, err := range errs {
+		staticErr, ok := err.(StaticError)
+		if !ok {
+			t.Fatalf("Expected StaticError, got %#v", err)
+		}
+		actual = append(actual, staticErr.Loc.String()+" "+staticErr.Message)
+	}
+	expected := []string{
+		"<extvar:unknown>:1:2-3 Unknown variable: x",
+		"<extvar:unterminated>:1:1 Unterminated String",
+		"<top-level-arg:broken>:1:6-7 Unexpected: \"}\" while parsing terminal",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+}
+
+func TestMaxComprehensionSize(t *testing.T) {
+	vm := MakeVM()
+	vm.SetMaxComprehensionSize(100)
+	_, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `[x for x in std.range(1, 100)] + [x for x in std.range(1, 1000) if x % 10 == 0]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := []string{
+		`[x for x in std.range(1, 101)]`,
+		`[[x, y] for x in std.range(1, 20) for y in std.range(1, 20)]`,
+		`{ [std.toString(x)]: x for x in std.range(1, 1000) }`,
+		`std.flatMap(function(x) [x, x], std.range(1, 51))`,
+		`std.flatMap(function(c) c + c, std.repeat('a', 51))`,
+	}
+	for _, snippet := range tests {
+		_, err = vm.EvaluateAnonymousSnippet("test.jsonnet", snippet)
+		if err == nil || !strings.Contains(err.Error(), "comprehension or std.flatMap result exceeded the maximum size of 100") {
+			t.Errorf("%s: expected the comprehension size error, but got %v", snippet, err)
+		}
+	}
+}
+
+func TestSetClock(t *testing.T) {
+	vm := MakeVM()
+	frozen := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+	vm.SetClock(func() time.Time { return frozen })
+	vm.NativeFunction(&NativeFunction{
+		Name:   "now",
+		Params: ast.Identifiers{},
+		Func: func(x []interface{}) (interface{}, error) {
+			return vm.Now().Format(time.RFC3339), nil
+		},
+	})
+	expected := `{ "generatedAt": "2024-03-01T12:30:00Z" }`
+	for run := 0; run < 2; run++ {
+		actual, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `{ generatedAt: std.native('now')() }`)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if removeExcessiveWhitespace(actual) != expected {
+			t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+		}
+	}
+
+	vm.SetClock(nil)
+	if vm.Now().Equal(frozen) {
+		t.Errorf("Expected the current time after restoring the default clock, but got %v", frozen)
+	}
+}
+
+type traceContextFormatter struct {
+	contexts []string
+}
+
+func (f *traceContextFormatter) Format(err error) string {
+	if rtErr, ok := err.(RuntimeError); ok {
+		for _, frame := range rtErr.StackTrace {
+			f.contexts = append(f.contexts, fmt.Sprintf("%d %s", frame.Loc.Begin.Line, frame.Context))
+		}
+	}
+	return err.Error()
+}
+
+func (f *traceContextFormatter) SetMaxStackTraceSize(size int) {}
+
+func (f *traceContextFormatter) SetColorFormatter(color ColorFormatter) {}
+
+func TestRuntimeErrorTraceContext(t *testing.T) {
+	vm := MakeVM()
+	formatter := &traceContextFormatter{}
+	vm.ErrorFormatter = formatter
+	_, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `
+local fail(x) = error 'boom ' + x;
+local obj = { field: fail('a') };
+obj.field
+`)
+	if err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+	// The frames are ordered from the outermost one, which is the manifestation of the result.
+	expected := []string{
+		"0 ",
+		"4 $",
+		"3 object <obj>",
+		"2 function <fail>",
+	}
+	if !reflect.DeepEqual(formatter.contexts, expected) {
+		t.Errorf("Expected %q, but got %q", expected, formatter.contexts)
+	}
+}
+
+func TestMaxManifestDepth(t *testing.T) {
+	vm := MakeVM()
+	vm.SetMaxManifestDepth(3)
+	actual, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `{ a: [{ b: 1 }], c: std.manifestJson([[1]]) }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "a": [ { "b": 1 } ], "c": "[\n [\n 1\n ]\n]" }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	tests := []string{
+		`{ a: [{ b: [] }] }`,
+		`local node = { name: 'n', child: self }; node`,
+		`local tree(n) = { left: tree(n + 1), right: tree(n + 1) }; tree(0)`,
+		`std.manifestJson({ a: { b: [[]] } })`,
+	}
+	for _, snippet := range tests {
+		_, err := vm.EvaluateAnonymousSnippet("test.jsonnet", snippet)
+		if err == nil || !strings.Contains(err.Error(), "maximum manifestation depth exceeded (3)") {
+			t.Errorf("%s: expected the manifestation depth error, but got %v", snippet, err)
+		}
+	}
+}
+
+func TestMaxObjectNesting(t *testing.T) {
+	vm := MakeVM()
+	vm.SetMaxObjectNesting(3)
+	actual, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `
+		local nest(n) = if n == 0 then {} else { a: nest(n - 1) };
+		{ a: { b: 1 }, c: nest(1), d: std.objectHas(nest(10).a, 'a') } + { e: {} }`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "a": { "b": 1 }, "c": { "a": { } }, "d": true, "e": { } }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	tests := []string{
+		`{ a: { b: { c: {} } } }`,
+		`local nest(n) = if n == 0 then {} else { a: nest(n - 1) }; std.objectHas(nest(10).a.a.a, 'a')`,
+		`local tree(n) = { left: tree(n + 1), right: tree(n + 1) }; std.length(tree(0).left.right.left)`,
+	}
+	for _, snippet := range tests {
+		_, err := vm.EvaluateAnonymousSnippet("test.jsonnet", snippet)
+		if err == nil || !strings.Contains(err.Error(), "maximum object nesting exceeded (3)") {
+			t.Errorf("%s: expected the object nesting error, but got %v", snippet, err)
+		}
+	}
+}
+
+func TestMaxArrayLength(t *testing.T) {
+	vm := MakeVM()
+	vm.SetMaxArrayLength(1000)
+	_, err := vm.EvaluateAnonymousSnippet("test.jsonnet", `[std.range(1, 1000), std.repeat('ab', 500), std.repeat([1, 2], 500), std.makeArray(1000, function(i) i)]`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	tests := map[string]string{
+		`std.range(0, 1000)`:                     "std.range: result too large (1001 elements, the maximum is 1000)",
+		`std.range(2000000000, -2000000000, -1)`: "std.range: result too large (4000000001 elements, the maximum is 1000)",
+		`std.repeat('x', 2000000000)`:            "std.repeat: result too large (2000000000 elements, the maximum is 1000)",
+		`std.repeat([1, 2, 3], 334)`:             "std.repeat: result too large (1002 elements, the maximum is 1000)",
+		`std.makeArray(1001, function(i) i)`:     "std.makeArray: result too large (1001 elements, the maximum is 1000)",
+	}
+	for snippet, expected := range tests {
+		_, err = vm.EvaluateAnonymousSnippet("test.jsonnet", snippet)
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%s: expected %q, but got %v", snippet, expected, err)
+		}
+	}
+}
+
+func TestStringOutputTrailingNewline(t *testing.T) {
+	tests := []struct {
+		snippet  string
+		newline  bool
+		expected string
+	}{
+		{`"foo"`, true, "foo\n"},
+		{`"foo"`, false, "foo"},
+		{`"foo\nbar\n"`, true, "foo\nbar\n\n"},
+		{`"foo\nbar\n"`, false, "foo\nbar\n"},
+	}
+	for _, test := range tests {
+		vm := MakeVM()
+		vm.StringOutput = true
+		vm.SetStringOutputTrailingNewline(test.newline)
+		actual, err := vm.EvaluateAnonymousSnippet("string_output.jsonnet", test.snippet)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if actual != test.expected {
+			t.Errorf("%s (newline: %v): expected %q, but got %q", test.snippet, test.newline, test.expected, actual)
+		}
+	}
+
+	// JSON output is not affected.
+	vm := MakeVM()
+	vm.SetStringOutputTrailingNewline(false)
+	actual, err := vm.EvaluateAnonymousSnippet("string_output.jsonnet", `"foo"`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "\"foo\"\n"; actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+}
+
+func TestEvaluateBatch(t *testing.T) {
+	traceOut := &strings.Builder{}
+	vm := MakeVM()
+	vm.SetTraceOut(traceOut)
+	vm.Importer(&MemoryImporter{
+		map[string]Contents{
+			"lib.libsonnet": MakeContents(`std.trace("lib evaluated", { x: 42 })`),
+		},
+	})
+	results, err := vm.EvaluateBatch([]SnippetInput{
+		{Filename: "a.jsonnet", Snippet: `(import "lib.libsonnet").x`},
+		{Filename: "b.jsonnet", Snippet: `error "b failed"`},
+		{Filename: "c.jsonnet", Snippet: `(import "lib.libsonnet").x + 1`},
+		{Filename: "d.jsonnet", Snippet: `{ a: `},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(results))
+	}
+	if results[0].Output != "42\n" || results[0].Err != nil {
+		t.Errorf("Unexpected result of a.jsonnet: %#v", results[0])
+	}
+	if results[1].Err == nil || !strings.Contains(results[1].Err.Error(), "b failed") {
+		t.Errorf("Unexpected result of b.jsonnet: %#v", results[1])
+	}
+	if results[2].Output != "43\n" || results[2].Err != nil {
+		t.Errorf("Unexpected result of c.jsonnet: %#v", results[2])
+	}
+	if results[3].Err == nil || !strings.Contains(results[3].Err.Error(), "d.jsonnet") {
+		t.Errorf("Unexpected result of d.jsonnet: %#v", results[3])
+	}
+	if count := strings.Count(traceOut.String(), "lib evaluated"); count != 1 {
+		t.Errorf("Expected the library to be evaluated once, but it was evaluated %d times", count)
+	}
+}
+
+func TestDeterministicEvaluation(t *testing.T) {
+	const snippet = `
+		local base = { [std.char(97 + i)]: i for i in std.range(0, 9) };
+		local parsed = std.parseJson(std.manifestJsonMinified(base { j+: 100, nested: base }));
+		{
+			merged: base + { b: 'b', y:: 'hidden' },
+			fields: std.objectFieldsAll(base { h:: 1 }),
+			json: std.manifestJsonEx(parsed, '  '),
+			yaml: std.manifestYamlDoc(parsed),
+			toml: std.manifestTomlEx({ section: base, list: [base, base] }, '  '),
+			keysValues: std.objectKeysValues(parsed.nested),
+		}
+	`
+	evalAll := func(name string, eval func(vm *VM) (string, error)) {
+		var first string
+		vm := MakeVM()
+		if err := vm.Freeze(); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for n := 0; n < 100; n++ {
+			output, err := eval(vm)
+			if err != nil {
+				output = err.Error()
+			}
+			if n == 0 {
+				first = output
+			} else if output != first {
+				t.Fatalf("%s: evaluation %d differs from the first one:\n%s\nvs\n%s", name, n, output, first)
+			}
+		}
+	}
+	evalAll("regular", func(vm *VM) (string, error) {
+		return vm.EvaluateAnonymousSnippet("determinism.jsonnet", snippet)
+	})
+	evalAll("tla error", func(vm *VM) (string, error) {
+		for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+			vm.TLAVar(name, name)
+		}
+		return vm.EvaluateAnonymousSnippet("determinism.jsonnet", `function(x) x`)
+	})
+	evalAll("multi string error", func(vm *VM) (string, error) {
+		vm.StringOutput = true
+		output, err := vm.EvaluateAnonymousSnippetMulti("determinism.jsonnet", `{ a: 1, b: 2, c: 3, d: 4, e: 5 }`)
+		return fmt.Sprint(output), err
+	})
+}
+
+func TestManifestJsonnet(t *testing.T) {
+	value := map[string]interface{}{
+		"name":   "it's \"quoted\"\n",
+		"local":  true,
+		"with-x": []interface{}{1.0, 2.5, nil, []interface{}{}},
+		"nested": map[string]interface{}{},
+	}
+	expected := `{
+  'local': true,
+  name: 'it\'s "quoted"\n',
+  nested: {},
+  'with-x': [
+    1,
+    2.5,
+    null,
+    [],
+  ],
+}
+`
+	actual, err := ManifestJsonnet(value)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+
+	if _, err := ManifestJsonnet(map[string]interface{}{"f": func() {}}); err == nil {
+		t.Errorf("Expected error for an unsupported value, got nil")
+	}
+}
+
+func TestManifestJsonnetRoundTrip(t *testing.T) {
+	snippets := []string{
+		`{ a: 1, b: [1, 2, { c: null }], 'd e': 'x\ty', "if": { "self": -0.5 }, "": '\u0001\\' }`,
+		`[[], {}, "", "\"'", 1e100, std.char(955)]`,
+		`'just a string'`,
+	}
+	for _, snippet := range snippets {
+		vm := MakeVM()
+		output, err := vm.EvaluateAnonymousSnippet("roundtrip.jsonnet", snippet)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		var value interface{}
+		if err := json.Unmarshal([]byte(output), &value); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		rendered, err := ManifestJsonnet(value)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		roundTrip, err := vm.EvaluateAnonymousSnippet("rendered.jsonnet", rendered)
+		if err != nil {
+			t.Fatalf("Rendered Jsonnet is invalid: %v\n%s", err, rendered)
+		}
+		if roundTrip != output {
+			t.Errorf("Round trip of %s failed, expected %q, but got %q", snippet, output, roundTrip)
+		}
+	}
+}
+
+func TestEvaluateAssertEqualReport(t *testing.T) {
+	vm := MakeVM()
+	snippet := `{
+  ok: std.assertEqual(1 + 1, 2),
+  wrong: std.assertEqual({ a: [1, 2] }, { a: [1, 3] }),
+  wrongAgain: std.assertEqual('x', null),
+}`
+	actual, failures, err := vm.EvaluateAssertEqualReport("suite.jsonnet", snippet)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "{\n   \"ok\": true,\n   \"wrong\": true,\n   \"wrongAgain\": true\n}\n"
+	if actual != expected {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+	expectedFailures := []struct {
+		actual   interface{}
+		expected interface{}
+		loc      string
+	}{
+		{map[string]interface{}{"a": []interface{}{1.0, 2.0}}, map[string]interface{}{"a": []interface{}{1.0, 3.0}}, "suite.jsonnet:3:10-55"},
+		{"x", nil, "suite.jsonnet:4:15-41"},
+	}
+	if len(failures) != len(expectedFailures) {
+		t.Fatalf("Expected %d failures, got %d: %#v", len(expectedFailures), len(failures), failures)
+	}
+	for n, failure := range failures {
+		if !reflect.DeepEqual(failure.Actual, expectedFailures[n].actual) {
+			t.Errorf("Failure %d: expected actual value %#v, got %#v", n, expectedFailures[n].actual, failure.Actual)
+		}
+		if !reflect.DeepEqual(failure.Expected, expectedFailures[n].expected) {
+			t.Errorf("Failure %d: expected expected value %#v, got %#v", n, expectedFailures[n].expected, failure.Expected)
+		}
+		if loc := failure.Loc.String(); loc != expectedFailures[n].loc {
+			t.Errorf("Failure %d: expected location %s, got %s", n, expectedFailures[n].loc, loc)
+		}
+	}
+
+	// The regular evaluation still fails.
+	_, err = vm.EvaluateAnonymousSnippet("suite.jsonnet", snippet)
+	if err == nil || !strings.Contains(err.Error(), "Assertion failed") {
+		t.Errorf("Expected assertion failure, got %v", err)
+	}
+
+	// The values of the imported files are not shared between the report and the regular evaluation.
+	vm.Importer(&MemoryImporter{Data: map[string]Contents{
+		"checks.libsonnet": MakeContents(`std.assertEqual(1, 2)`),
+	}})
+	for _, frozen := range []bool{false, true} {
+		if frozen {
+			if err := vm.Freeze(); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+		}
+		_, failures, err = vm.EvaluateAssertEqualReport("suite.jsonnet", `import "checks.libsonnet"`)
+		if err != nil || len(failures) != 1 {
+			t.Errorf("Expected one failure, got %v and error %v", failures, err)
+		}
+		_, err = vm.EvaluateAnonymousSnippet("suite.jsonnet", `import "checks.libsonnet"`)
+		if err == nil || !strings.Contains(err.Error(), "Assertion failed") {
+			t.Errorf("Expected assertion failure after the report, got %v", err)
+		}
+		_, failures, err = vm.EvaluateAssertEqualReport("suite.jsonnet", `import "checks.libsonnet"`)
+		if err != nil || len(failures) != 1 {
+			t.Errorf("Expected one failure after the regular evaluation, got %v and error %v", failures, err)
+		}
+	}
+}
+
+func TestImportPolicy(t *testing.T) {
+	importer := &MemoryImporter{
+		Data: map[string]Contents{
+			"lib.libsonnet": MakeContents("{ x: 1 }"),
+			"data.txt":      MakeContents("text"),
+			"data.bin":      MakeContentsRaw([]byte{1, 2}),
+		},
+	}
+	tests := []struct {
+		name    string
+		policy  ImportPolicy
+		snippet string
+		result  string
+		errKind string
+	}{
+		{"allow all import", ImportAllowAll, `(import "lib.libsonnet").x`, "1\n", ""},
+		{"allow all importstr", ImportAllowAll, `importstr "data.txt"`, "\"text\"\n", ""},
+		{"allow all importbin", ImportAllowAll, `std.length(importbin "data.bin")`, "2\n", ""},
+		{"code only import", ImportCodeOnly, `(import "lib.libsonnet").x`, "1\n", ""},
+		{"code only importstr", ImportCodeOnly, `importstr "data.txt"`, "", "importstr"},
+		{"code only importbin", ImportCodeOnly, `importbin "data.bin"`, "", "importbin"},
+		{"deny all import", ImportDenyAll, `(import "lib.libsonnet").x`, "", "import"},
+		{"deny all importstr", ImportDenyAll, `importstr "data.txt"`, "", "importstr"},
+		{"deny all importbin", ImportDenyAll, `importbin "data.bin"`, "", "importbin"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			vm := MakeVM()
+			vm.Importer(importer)
+			vm.SetImportPolicy(test.policy)
+			result, err := vm.EvaluateAnonymousSnippet("main.jsonnet", test.snippet)
+			if test.errKind == "" {
+				if err != nil {
+					t.Fatalf("Unexpected error: %v", err)
+				}
+				if result != test.result {
+					t.Errorf("Expected %q, but got %q", test.result, result)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Expected error, but got %q", result)
+			}
+			expected := "RUNTIME ERROR: imports are disabled: " + test.errKind + " "
+			if !strings.HasPrefix(err.Error(), expected) {
+				t.Errorf("Expected error starting with %q, but got %q", expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestMissingImportPolicy(t *testing.T) {
+	importer := &MemoryImporter{
+		Data: map[string]Contents{
+			"found.libsonnet":  MakeContents("{ site: 'custom' }"),
+			"broken.libsonnet": MakeContents("{ site: "),
+		},
+	}
+	snippet := `
+		local defaults = { site: 'default' };
+		local config(imported) = if imported == null then defaults else imported;
+		{
+			found: config(import 'found.libsonnet').site,
+			missing: config(import 'missing.libsonnet').site,
+			missingStr: importstr 'missing.txt',
+			missingBin: importbin 'missing.bin',
+		}`
+
+	vm := MakeVM()
+	vm.Importer(importer)
+	_, err := vm.EvaluateAnonymousSnippet("main.jsonnet", snippet)
+	if err == nil || !strings.Contains(err.Error(), "import not available missing.libsonnet") {
+		t.Errorf("Expected missing import error, but got %v", err)
+	}
+
+	vm.SetMissingImportPolicy(MissingImportNull)
+	actual, err := vm.EvaluateAnonymousSnippet("main.jsonnet", snippet)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `{ "found": "custom", "missing": "default", "missingBin": null, "missingStr": null }`
+	if removeExcessiveWhitespace(actual) != expected {
+		t.Errorf("Expected %q, but got %q", expected, removeExcessiveWhitespace(actual))
+	}
+
+	// Files which exist but cannot be parsed are still reported.
+	_, err = vm.EvaluateAnonymousSnippet("main.jsonnet", `import 'broken.libsonnet'`)
+	if err == nil || !strings.Contains(err.Error(), "broken.libsonnet") {
+		t.Errorf("Expected parse error, but got %v", err)
+	}
+
+	// Other failures of the importer than a missing file are still reported.
+	vm.Importer(failingImporter{err: errors.New("permission denied")})
+	for _, snippet := range []string{`import 'a.libsonnet'`, `importstr 'a.txt'`, `importbin 'a.bin'`} {
+		_, err = vm.EvaluateAnonymousSnippet("main.jsonnet", snippet)
+		if err == nil || !strings.Contains(err.Error(), "permission denied") {
+			t.Errorf("%s: expected the importer error, but got %v", snippet, err)
+		}
+	}
+}
+
+func TestLiteralFastPath(t *testing.T) {
+	literals := []string{
+		`null`,
+		`true`,
+		`-1.5e3`,
+		`"esc\"aped\n\u00e9"`,
+		`[]`,
+		`{}`,
+		`{ "a": [1, "x", null, false, { "b": {} }], c: [[]], 'd e': -0 }`,
+		`{ a: 1, b:: 2 }`,
+		`{ a: 1, "a": 2 }`,
+		`{ a: 1 + 1 }`,
+		`{ a: $.b, b: 1 }`,
+	}
+	for _, literal := range literals {
+		vm := MakeVM()
+		fast, fastErr := vm.EvaluateAnonymousSnippet("literal.json", literal)
+		full, fullErr := vm.EvaluateAnonymousSnippet("literal.json", "local x = "+literal+"; x")
+		if (fastErr == nil) != (fullErr == nil) {
+			t.Errorf("%s: expected error %v, but got %v", literal, fullErr, fastErr)
+		}
+		if fast != full {
+			t.Errorf("%s: expected %q, but got %q", literal, full, fast)
+		}
+	}
+
+	// The stack limit applies to the deep documents.
+	for _, literal := range []string{strings.Repeat("[", 600) + strings.Repeat("]", 600), strings.Repeat("{a:", 600) + "1" + strings.Repeat("}", 600)} {
+		vm := MakeVM()
+		_, err := vm.EvaluateAnonymousSnippet("literal.json", literal)
+		if err == nil || !strings.Contains(err.Error(), "max stack frames exceeded") {
+			t.Errorf("%s...: expected the stack limit to be exceeded, but got %v", literal[:10], err)
+		}
+	}
+}
+
+func makeLargeLiteral() string {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i := 0; i < 1000; i++ {
+		fmt.Fprintf(&buf, `"key%d": { "id": %d, "name": "item %d", "tags": ["a", "b"], "enabled": true, "ratio": 0.5 },`, i, i, i)
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+func BenchmarkLiteralFastPath(b *testing.B) {
+	literal := makeLargeLiteral()
+	vm := MakeVM()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := vm.EvaluateAnonymousSnippet("literal.json", literal); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkLiteralFullEvaluation(b *testing.B) {
+	// The local prevents the fast path, the result is the same.
+	snippet := "local x = " + makeLargeLiteral() + "; x"
+	vm := MakeVM()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		if _, err := vm.EvaluateAnonymousSnippet("literal.json", snippet); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkLocationTracking compares the evaluation of a computation-heavy program
+// with and without the location tracking.
+func BenchmarkLocationTracking(b *testing.B) {
+	snippet := `
+local fib(n) = if n < 2 then n else fib(n - 1) + fib(n - 2);
+std.foldl(function(acc, x) acc + x * 2 - 1, std.range(1, 20000), 0) + fib(18)
+`
+	for _, enabled := range []bool{true, false} {
+		b.Run(fmt.Sprintf("enabled=%v", enabled), func(b *testing.B) {
+			vm := MakeVM()
+			vm.SetLocationTracking(enabled)
+			for n := 0; n < b.N; n++ {
+				vm.flushValueCache()
+				if _, err := vm.EvaluateAnonymousSnippet("bench.jsonnet", snippet); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestLocationTracking(t *testing.T) {
+	vm := MakeVM()
+	vm.SetLocationTracking(false)
+	snippet := `
+local check(x) =
+  local y = x * 2;
+  if y > 10 then error 'too large: ' + y else y;
+[check(1), check(10)]
+`
+	_, err := vm.EvaluateAnonymousSnippet("test.jsonnet", snippet)
+	if err == nil {
+		t.Fatalf("Expected error, got nil")
+	}
+	// The whole conditional is reported instead of the error expression in it.
+	for _, expected := range []string{"too large: 20", "test.jsonnet:4:3-48\tfunction <check>"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q in the error, but got %q", expected, err.Error())
+		}
+	}
+	if strings.Contains(err.Error(), "test.jsonnet:4:18-41") {
+		t.Errorf("Expected no exact location in the error, but got %q", err.Error())
+	}
+
+	vm.SetLocationTracking(true)
+	_, err = vm.EvaluateAnonymousSnippet("test.jsonnet", snippet)
+	if err == nil || !strings.Contains(err.Error(), "test.jsonnet:4:18-41") {
+		t.Errorf("Expected the exact location in the error, but got %v", err)
+	}
+}
+
+func TestExtractStrings(t *testing.T) {
+	literals, err := ExtractStrings("i18n.jsonnet", `local lib = import 'lib.libsonnet';
+local name = 'World';
+{
+  greeting: 'Hello %s!' % name,
+  'quoted key': "double",
+  list: [|||
+    block
+  |||, @'verbatim'],
+  count: 'Items: %(n)d' % { n: 1 },
+  nested: { label: std.join(', ', ['a', 'b']) },
+}
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var actual []string
+	for _, literal := range literals {
+		actual = append(actual, fmt.Sprintf("%d:%d %q %v", literal.Loc.Begin.Line, literal.Loc.Begin.Column, literal.Value, literal.Dynamic))
+	}
+	expected := []string{
+		`2:14 "World" false`,
+		`4:13 "Hello %s!" true`,
+		`5:3 "quoted key" false`,
+		`5:17 "double" false`,
+		`6:10 "block\n" false`,
+		`8:8 "verbatim" false`,
+		`9:10 "Items: %(n)d" true`,
+		`10:29 ", " false`,
+		`10:36 "a" false`,
+		`10:41 "b" false`,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %q, but got %q", expected, actual)
+	}
+
+	_, err = ExtractStrings("broken.jsonnet", "{ a: ")
+	if err == nil {
+		t.Errorf("Expected parse error, got nil")
+	}
+}
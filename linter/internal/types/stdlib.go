@@ -25,23 +25,37 @@ func prepareStdlib(g *typeGraph) {
 		"extVar": g.newSimpleFuncType(anyType, "x"),
 
 		// Types and reflection
-		"thisFile":        stringType,
-		"type":            g.newSimpleFuncType(stringType, "x"),
-		"length":          g.newSimpleFuncType(numberType, "x"),
-		"objectHas":       g.newSimpleFuncType(boolType, "o", "f"),
-		"objectFields":    g.newSimpleFuncType(arrayOfString, "o"),
-		"objectValues":    g.newSimpleFuncType(anyArrayType, "o"),
-		"objectHasAll":    g.newSimpleFuncType(boolType, "o", "f"),
-		"objectFieldsAll": g.newSimpleFuncType(arrayOfString, "o"),
-		"objectValuesAll": g.newSimpleFuncType(anyArrayType, "o"),
-		"prune":           g.newSimpleFuncType(anyObjectType, "a"),
-		"mapWithKey":      g.newSimpleFuncType(anyObjectType, "func", "obj"),
-		"get":             g.newFuncType(anyType, []ast.Parameter{required("o"), required("f"), optional("default"), optional("inc_hidden")}),
+		"thisFile":            stringType,
+		"type":                g.newSimpleFuncType(stringType, "x"),
+		"length":              g.newSimpleFuncType(numberType, "x"),
+		"objectHas":           g.newSimpleFuncType(boolType, "o", "f"),
+		"objectFields":        g.newSimpleFuncType(arrayOfString, "o"),
+		"objectValues":        g.newSimpleFuncType(anyArrayType, "o"),
+		"objectHasAll":        g.newSimpleFuncType(boolType, "o", "f"),
+		"objectHasNonNull":    g.newSimpleFuncType(boolType, "o", "f"),
+		"objectFieldsAll":     g.newSimpleFuncType(arrayOfString, "o"),
+		"objectValuesAll":     g.newSimpleFuncType(anyArrayType, "o"),
+		"objectKeysValues":    g.newSimpleFuncType(anyArrayType, "o"),
+		"objectKeysValuesAll": g.newSimpleFuncType(anyArrayType, "o"),
+		"objectPairs":         g.newSimpleFuncType(anyArrayType, "o"),
+		"objectPairsAll":      g.newSimpleFuncType(anyArrayType, "o"),
+		"sortObjectByValue":   g.newFuncType(anyArrayType, []ast.Parameter{required("obj"), optional("keyF")}),
+		"objectFromPairs":     g.newFuncType(anyObjectType, []ast.Parameter{required("pairs"), optional("lastWins")}),
+		"prune":               g.newSimpleFuncType(anyObjectType, "a"),
+		"deepFilter":          g.newSimpleFuncType(anyType, "func", "value"),
+		"mapWithKey":          g.newSimpleFuncType(anyObjectType, "func", "obj"),
+		"mapObject":           g.newSimpleFuncType(anyObjectType, "func", "obj"),
+		"mapObjectKeys":       g.newSimpleFuncType(anyObjectType, "func", "obj"),
+		"objectRemoveKeys":    g.newSimpleFuncType(anyObjectType, "obj", "keys"),
+		"get":                 g.newFuncType(anyType, []ast.Parameter{required("o"), required("f"), optional("default"), optional("inc_hidden")}),
+		"getPath":             g.newFuncType(anyType, []ast.Parameter{required("obj"), required("path"), optional("default")}),
+		"objectHasPath":       g.newSimpleFuncType(boolType, "obj", "path"),
 
 		// isSomething
 		"isArray":    g.newSimpleFuncType(boolType, "v"),
 		"isBoolean":  g.newSimpleFuncType(boolType, "v"),
 		"isFunction": g.newSimpleFuncType(boolType, "v"),
+		"isNull":     g.newSimpleFuncType(boolType, "v"),
 		"isNumber":   g.newSimpleFuncType(boolType, "v"),
 		"isObject":   g.newSimpleFuncType(boolType, "v"),
 		"isString":   g.newSimpleFuncType(boolType, "v"),
@@ -72,39 +86,56 @@ func prepareStdlib(g *typeGraph) {
 
 		// String Manipulation
 
-		"toString":    g.newSimpleFuncType(stringType, "a"),
-		"codepoint":   g.newSimpleFuncType(numberType, "str"),
-		"char":        g.newSimpleFuncType(stringType, "n"),
-		"substr":      g.newSimpleFuncType(stringType, "str", "from", "len"),
-		"findSubstr":  g.newSimpleFuncType(numberArrayType, "pat", "str"),
-		"startsWith":  g.newSimpleFuncType(boolType, "a", "b"),
-		"endsWith":    g.newSimpleFuncType(boolType, "a", "b"),
-		"stripChars":  g.newSimpleFuncType(stringType, "str", "chars"),
-		"lstripChars": g.newSimpleFuncType(stringType, "str", "chars"),
-		"rstripChars": g.newSimpleFuncType(stringType, "str", "chars"),
-		"split":       g.newSimpleFuncType(arrayOfString, "str", "c"),
-		"splitLimit":  g.newSimpleFuncType(arrayOfString, "str", "c", "maxsplits"),
-		"strReplace":  g.newSimpleFuncType(stringType, "str", "from", "to"),
-		"asciiUpper":  g.newSimpleFuncType(stringType, "str"),
-		"asciiLower":  g.newSimpleFuncType(stringType, "str"),
-		"stringChars": g.newSimpleFuncType(stringType, "str"),
-		"format":      g.newSimpleFuncType(stringType, "str", "vals"),
-		"isEmpty":     g.newSimpleFuncType(boolType, "str"),
+		"toString":        g.newSimpleFuncType(stringType, "a"),
+		"codepoint":       g.newSimpleFuncType(numberType, "str"),
+		"char":            g.newSimpleFuncType(stringType, "n"),
+		"substr":          g.newSimpleFuncType(stringType, "str", "from", "len"),
+		"findSubstr":      g.newSimpleFuncType(numberArrayType, "pat", "str"),
+		"startsWith":      g.newSimpleFuncType(boolType, "a", "b"),
+		"endsWith":        g.newSimpleFuncType(boolType, "a", "b"),
+		"stripChars":      g.newSimpleFuncType(stringType, "str", "chars"),
+		"lstripChars":     g.newSimpleFuncType(stringType, "str", "chars"),
+		"rstripChars":     g.newSimpleFuncType(stringType, "str", "chars"),
+		"trim":            g.newSimpleFuncType(stringType, "str"),
+		"split":           g.newSimpleFuncType(arrayOfString, "str", "c"),
+		"splitLimit":      g.newSimpleFuncType(arrayOfString, "str", "c", "maxsplits"),
+		"strReplace":      g.newSimpleFuncType(stringType, "str", "from", "to"),
+		"strReplaceFirst": g.newSimpleFuncType(stringType, "str", "from", "to"),
+		"indent":          g.newSimpleFuncType(stringType, "str", "prefix"),
+		"regexMatch":      g.newSimpleFuncType(boolType, "str", "pattern"),
+		"regexReplace":    g.newSimpleFuncType(stringType, "str", "pattern", "replacement"),
+		"asciiUpper":      g.newSimpleFuncType(stringType, "str"),
+		"asciiLower":      g.newSimpleFuncType(stringType, "str"),
+		"stringChars":     g.newSimpleFuncType(stringType, "str"),
+		"format":          g.newSimpleFuncType(stringType, "str", "vals"),
+		"isEmpty":         g.newSimpleFuncType(boolType, "str"),
 		// TODO(sbarzowski) Fix when they match the documentation
 		"escapeStringBash":    g.newSimpleFuncType(stringType, "str_"),
 		"escapeStringDollars": g.newSimpleFuncType(stringType, "str_"),
 		"escapeStringJson":    g.newSimpleFuncType(stringType, "str_"),
 		"escapeStringPython":  g.newSimpleFuncType(stringType, "str"),
+		"escapeStringXML":     g.newSimpleFuncType(stringType, "str_"),
+		"escapeStringXml":     g.newSimpleFuncType(stringType, "str"),
+		"escapeStringUrl":     g.newSimpleFuncType(stringType, "str"),
 
 		// Parsing
 
-		"parseInt":   g.newSimpleFuncType(numberType, "str"),
-		"parseOctal": g.newSimpleFuncType(numberType, "str"),
-		"parseHex":   g.newSimpleFuncType(numberType, "str"),
-		"parseJson":  g.newSimpleFuncType(jsonType, "str"),
-		"parseYaml":  g.newSimpleFuncType(jsonType, "str"),
-		"encodeUTF8": g.newSimpleFuncType(numberArrayType, "str"),
-		"decodeUTF8": g.newSimpleFuncType(stringType, "arr"),
+		"parseInt":        g.newSimpleFuncType(numberType, "str"),
+		"parseOctal":      g.newSimpleFuncType(numberType, "str"),
+		"parseHex":        g.newSimpleFuncType(numberType, "str"),
+		"parseBigInt":     g.newSimpleFuncType(stringType, "str"),
+		"parseJson":       g.newSimpleFuncType(jsonType, "str"),
+		"parseYaml":       g.newSimpleFuncType(jsonType, "str"),
+		"parseYamlStream": g.newSimpleFuncType(anyArrayType, "str"),
+		"parseCsv":        g.newFuncType(anyArrayType, []ast.Parameter{required("str"), optional("hasHeader")}),
+		"encodeUTF8":      g.newSimpleFuncType(numberArrayType, "str"),
+		"decodeUTF8":      g.newSimpleFuncType(stringType, "arr"),
+
+		// Big integers
+
+		"bigAdd": g.newSimpleFuncType(stringType, "a", "b"),
+		"bigSub": g.newSimpleFuncType(stringType, "a", "b"),
+		"bigMul": g.newSimpleFuncType(stringType, "a", "b"),
 
 		// Manifestation
 
@@ -112,34 +143,51 @@ func prepareStdlib(g *typeGraph) {
 		"manifestPython":       g.newSimpleFuncType(stringType, "v"),
 		"manifestPythonVars":   g.newSimpleFuncType(stringType, "conf"),
 		"manifestTomlEx":       g.newSimpleFuncType(stringType, "value", "indent"),
-		"manifestJsonEx":       g.newSimpleFuncType(stringType, "value", "indent"),
+		"manifestJsonEx":       g.newFuncType(stringType, []ast.Parameter{required("value"), required("indent"), optional("newline"), optional("key_val_sep")}),
 		"manifestJsonMinified": g.newSimpleFuncType(stringType, "value"),
-		"manifestYamlDoc":      g.newSimpleFuncType(stringType, "value"),
-		"manifestYamlStream":   g.newSimpleFuncType(stringType, "value"),
+		"manifestYamlDoc":      g.newFuncType(stringType, []ast.Parameter{required("value"), optional("indent_array_in_object"), optional("quote_keys")}),
+		"manifestYamlStream":   g.newFuncType(stringType, []ast.Parameter{required("value"), optional("indent_array_in_object"), optional("c_document_end"), optional("quote_keys")}),
 		"manifestXmlJsonml":    g.newSimpleFuncType(stringType, "value"),
+		"manifestXmlJsonmlEx":  g.newSimpleFuncType(stringType, "value", "indent"),
+		"manifestCsv":          g.newSimpleFuncType(stringType, "arr", "columns"),
 
 		// Arrays
 
 		"makeArray":     g.newSimpleFuncType(anyArrayType, "sz", "func"),
 		"count":         g.newSimpleFuncType(numberType, "arr", "x"),
 		"member":        g.newSimpleFuncType(boolType, "arr", "x"),
+		"contains":      g.newSimpleFuncType(boolType, "arr", "elem"),
+		"indexOf":       g.newSimpleFuncType(numberType, "arr", "elem"),
+		"lastIndexOf":   g.newSimpleFuncType(numberType, "arr", "elem"),
 		"find":          g.newSimpleFuncType(numberArrayType, "value", "arr"),
 		"map":           g.newSimpleFuncType(anyArrayType, "func", "arr"),
 		"mapWithIndex":  g.newSimpleFuncType(anyArrayType, "func", "arr"),
 		"filterMap":     g.newSimpleFuncType(anyArrayType, "filter_func", "map_func", "arr"),
 		"flatMap":       g.newSimpleFuncType(anyArrayType, "func", "arr"),
 		"filter":        g.newSimpleFuncType(anyArrayType, "func", "arr"),
+		"findIndex":     g.newSimpleFuncType(numberType, "func", "arr"),
+		"findValue":     g.newSimpleFuncType(anyType, "func", "arr"),
 		"foldl":         g.newSimpleFuncType(anyType, "func", "arr", "init"),
 		"foldr":         g.newSimpleFuncType(anyType, "func", "arr", "init"),
+		"foldlObject":   g.newSimpleFuncType(anyType, "func", "obj", "init"),
 		"repeat":        g.newSimpleFuncType(anyArrayType, "what", "count"),
 		"slice":         g.newSimpleFuncType(arrayOfString, "indexable", "index", "end", "step"),
-		"range":         g.newSimpleFuncType(numberArrayType, "from", "to"),
+		"range":         g.newFuncType(numberArrayType, []ast.Parameter{required("from"), required("to"), optional("step")}),
+		"rangeStep":     g.newSimpleFuncType(numberArrayType, "from", "to", "step"),
 		"join":          g.newSimpleFuncType(stringOrArray, "sep", "arr"),
-		"lines":         g.newSimpleFuncType(arrayOfString, "arr"),
+		"lines":         g.newSimpleFuncType(stringOrArray, "arr"),
+		"unlines":       g.newSimpleFuncType(stringType, "arr"),
+		"words":         g.newSimpleFuncType(arrayOfString, "str"),
 		"flattenArrays": g.newSimpleFuncType(anyArrayType, "arrs"),
+		"zip":           g.newSimpleFuncType(anyArrayType, "arrs"),
+		"zipWithIndex":  g.newSimpleFuncType(anyArrayType, "arr"),
 		"sort":          g.newFuncType(anyArrayType, []ast.Parameter{required("arr"), optional("keyF")}),
 		"uniq":          g.newFuncType(anyArrayType, []ast.Parameter{required("arr"), optional("keyF")}),
+		"cmp":           g.newSimpleFuncType(numberType, "a", "b"),
 		"sum":           g.newSimpleFuncType(numberType, "arr"),
+		"avg":           g.newSimpleFuncType(numberType, "arr"),
+		"minArray":      g.newFuncType(anyType, []ast.Parameter{required("arr"), optional("keyF"), optional("onEmpty")}),
+		"maxArray":      g.newFuncType(anyType, []ast.Parameter{required("arr"), optional("keyF"), optional("onEmpty")}),
 
 		// Sets
 
@@ -154,7 +202,16 @@ func prepareStdlib(g *typeGraph) {
 		"base64":            g.newSimpleFuncType(stringType, "input"),
 		"base64DecodeBytes": g.newSimpleFuncType(numberType, "str"),
 		"base64Decode":      g.newSimpleFuncType(stringType, "str"),
+		"base32":            g.newSimpleFuncType(stringType, "input"),
+		"base32Decode":      g.newSimpleFuncType(stringType, "str"),
 		"md5":               g.newSimpleFuncType(stringType, "s"),
+		"valueHash":         g.newSimpleFuncType(stringType, "value"),
+
+		// Paths
+
+		"dirname":     g.newSimpleFuncType(stringType, "path"),
+		"basename":    g.newSimpleFuncType(stringType, "path"),
+		"resolvePath": g.newSimpleFuncType(stringType, "f", "r"),
 
 		// JSON Merge Patch
 
@@ -165,7 +222,7 @@ func prepareStdlib(g *typeGraph) {
 		"trace": g.newSimpleFuncType(anyType, "str", "rest"),
 
 		// Undocumented
-		"manifestJson":     g.newSimpleFuncType(stringType, "value"),
+		"manifestJson":     g.newFuncType(stringType, []ast.Parameter{required("value"), optional("sortKeys")}),
 		"objectHasEx":      g.newSimpleFuncType(boolType, "obj", "fname", "hidden"),
 		"objectFieldsEx":   g.newSimpleFuncType(arrayOfString, "obj", "hidden"),
 		"modulo":           g.newSimpleFuncType(numberType, "x", "y"),
@@ -176,8 +233,8 @@ func prepareStdlib(g *typeGraph) {
 
 		// Boolean
 
-		"xor":	g.newSimpleFuncType(boolType, "x", "y"),
-		"xnor":	g.newSimpleFuncType(boolType, "x", "y"),
+		"xor":  g.newSimpleFuncType(boolType, "x", "y"),
+		"xnor": g.newSimpleFuncType(boolType, "x", "y"),
 	}
 
 	fieldContains := map[string][]placeholderID{}
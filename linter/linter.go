@@ -28,7 +28,7 @@ type Snippet struct {
 	Code     string
 }
 
-func (e *ErrorWriter) writeError(vm *jsonnet.VM, err errors.StaticError) {
+func (e *ErrorWriter) writeError(vm *jsonnet.VM, err error) {
 	e.ErrorsFound = true
 	_, writeErr := e.Writer.Write([]byte(vm.ErrorFormatter.Format(err) + "\n"))
 	if writeErr != nil {
@@ -149,7 +149,7 @@ func LintSnippet(vm *jsonnet.VM, output io.Writer, snippets []Snippet) bool {
 		node, err := jsonnet.SnippetToAST(snippet.FileName, snippet.Code)
 
 		if err != nil {
-			errWriter.writeError(vm, err.(errors.StaticError)) // ugly but true
+			errWriter.writeError(vm, err)
 		} else {
 			nodes = append(nodes, nodeWithLocation{node, snippet.FileName})
 		}
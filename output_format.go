@@ -0,0 +1,82 @@
+/*
+Copyright 2024 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"bytes"
+)
+
+// OutputFormatter serializes the result of an evaluation, see VM.EvaluateSnippetAs.
+// The value is passed in the standard Go JSON representation, i.e. nil, bool, float64,
+// string, []interface{} or map[string]interface{}.
+type OutputFormatter interface {
+	Format(value interface{}) ([]byte, error)
+}
+
+// OutputFormatterFunc is a function implementing OutputFormatter.
+type OutputFormatterFunc func(value interface{}) ([]byte, error)
+
+// Format calls f(value).
+func (f OutputFormatterFunc) Format(value interface{}) ([]byte, error) {
+	return f(value)
+}
+
+// jsonOutputFormatter produces the same JSON as the regular evaluation with the output
+// settings of the VM, like the style and the line separator. The comments are not emitted,
+// the formatted value doesn't have them.
+type jsonOutputFormatter struct {
+	vm *VM
+}
+
+func (f jsonOutputFormatter) Format(value interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	serializeJSON(value, true, "", f.vm.outputStyle(), &buf)
+	buf.WriteString("\n")
+	return []byte(separateLines(buf.String(), f.vm.lineSeparator)), nil
+}
+
+// yamlOutputFormatter produces a YAML document like std.manifestYamlDoc, with the keys
+// quoted only when needed and with the line separator of the VM.
+type yamlOutputFormatter struct {
+	vm *VM
+}
+
+func (f yamlOutputFormatter) Format(value interface{}) ([]byte, error) {
+	// The value is already manifested, so the interpreter only reports the errors.
+	i := &interpreter{
+		stack:            makeCallStack(f.vm.MaxStack),
+		maxManifestDepth: f.vm.maxManifestDepth,
+		lineSeparator:    f.vm.lineSeparator,
+	}
+	v, err := jsonToValue(i, value)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := manifestYamlDoc(i, v, nil, "", false, false, &buf); err != nil {
+		return nil, err
+	}
+	buf.WriteString("\n")
+	return []byte(i.separateLines(buf.String())), nil
+}
+
+// builtinOutputFormats are the formats available without registering them.
+// They follow the output settings of the VM they are made for.
+var builtinOutputFormats = map[string]func(vm *VM) OutputFormatter{
+	"json": func(vm *VM) OutputFormatter { return jsonOutputFormatter{vm} },
+	"yaml": func(vm *VM) OutputFormatter { return yamlOutputFormatter{vm} },
+}
@@ -0,0 +1,490 @@
+/*
+Copyright 2024 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SchemaError is a place where the evaluated value does not conform to the JSON Schema.
+type SchemaError struct {
+	// Path is a JSON Pointer to the offending part of the value, e.g. "/spec/ports/0".
+	// It is empty for the whole value.
+	Path string
+	// Keyword is the schema keyword which is violated, e.g. "type" or "required".
+	Keyword string
+	Message string
+}
+
+func (e SchemaError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// schemaValidator validates values in the standard Go JSON representation against
+// a JSON Schema. It supports the commonly used validation keywords of the recent drafts:
+// type, enum, const, the numeric, string, array and object constraints, the allOf,
+// anyOf, oneOf and not combinators, and $ref to the local definitions.
+// The other keywords, like format or the annotations, are ignored.
+type schemaValidator struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+	// The references being followed for the values at the paths, for detecting
+	// the references which lead back to themselves without validating a nested value.
+	following map[schemaRefAt]bool
+}
+
+type schemaRefAt struct {
+	ref  string
+	path string
+}
+
+func makeSchemaValidator(schemaJSON string) (*schemaValidator, error) {
+	var root interface{}
+	if err := json.Unmarshal([]byte(schemaJSON), &root); err != nil {
+		return nil, fmt.Errorf("invalid JSON Schema: %v", err)
+	}
+	return &schemaValidator{root: root, patterns: map[string]*regexp.Regexp{}, following: map[schemaRefAt]bool{}}, nil
+}
+
+func (v *schemaValidator) pattern(p string) (*regexp.Regexp, error) {
+	if re, ok := v.patterns[p]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(p)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON Schema: pattern %q: %v", p, err)
+	}
+	v.patterns[p] = re
+	return re, nil
+}
+
+// resolve finds the schema referenced by $ref, which must be a JSON Pointer
+// within the same document, e.g. "#/$defs/port".
+func (v *schemaValidator) resolve(ref string) (interface{}, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("invalid JSON Schema: only local references are supported, got %q", ref)
+	}
+	current := v.root
+	if ref == "#" {
+		return current, nil
+	}
+	for _, token := range strings.Split(ref[2:], "/") {
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		switch c := current.(type) {
+		case map[string]interface{}:
+			next, ok := c[token]
+			if !ok {
+				return nil, fmt.Errorf("invalid JSON Schema: cannot resolve %q", ref)
+			}
+			current = next
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(c) {
+				return nil, fmt.Errorf("invalid JSON Schema: cannot resolve %q", ref)
+			}
+			current = c[index]
+		default:
+			return nil, fmt.Errorf("invalid JSON Schema: cannot resolve %q", ref)
+		}
+	}
+	return current, nil
+}
+
+func schemaTypeName(x interface{}) string {
+	switch x := x.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if x == math.Trunc(x) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", x)
+}
+
+func schemaHasType(x interface{}, typeName string) bool {
+	actual := schemaTypeName(x)
+	return actual == typeName || (typeName == "number" && actual == "integer")
+}
+
+func schemaNumber(schema map[string]interface{}, keyword string) (float64, bool, error) {
+	raw, ok := schema[keyword]
+	if !ok {
+		return 0, false, nil
+	}
+	n, ok := raw.(float64)
+	if !ok {
+		return 0, false, fmt.Errorf("invalid JSON Schema: %s must be a number, got %s", keyword, schemaTypeName(raw))
+	}
+	return n, true, nil
+}
+
+func pointerToken(token string) string {
+	return "/" + strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// validate checks the value x found at path against the schema and returns the violations.
+// An error is returned only if the schema itself is invalid.
+func (v *schemaValidator) validate(schema interface{}, x interface{}, path string) ([]SchemaError, error) {
+	switch schema := schema.(type) {
+	case bool:
+		if !schema {
+			return []SchemaError{{Path: path, Keyword: "false", Message: "no value is allowed"}}, nil
+		}
+		return nil, nil
+	case map[string]interface{}:
+		return v.validateObject(schema, x, path)
+	default:
+		return nil, fmt.Errorf("invalid JSON Schema: a schema must be an object or a boolean, got %s", schemaTypeName(schema))
+	}
+}
+
+func (v *schemaValidator) validateObject(schema map[string]interface{}, x interface{}, path string) ([]SchemaError, error) {
+	var errs []SchemaError
+	fail := func(path string, keyword string, format string, args ...interface{}) {
+		errs = append(errs, SchemaError{Path: path, Keyword: keyword, Message: fmt.Sprintf(format, args...)})
+	}
+	sub := func(subSchema interface{}, subX interface{}, subPath string) error {
+		subErrs, err := v.validate(subSchema, subX, subPath)
+		errs = append(errs, subErrs...)
+		return err
+	}
+	matches := func(subSchema interface{}) (bool, error) {
+		subErrs, err := v.validate(subSchema, x, path)
+		return len(subErrs) == 0, err
+	}
+
+	if ref, ok := schema["$ref"]; ok {
+		refString, ok := ref.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid JSON Schema: $ref must be a string, got %s", schemaTypeName(ref))
+		}
+		target, err := v.resolve(refString)
+		if err != nil {
+			return nil, err
+		}
+		at := schemaRefAt{ref: refString, path: path}
+		if v.following[at] {
+			return nil, fmt.Errorf("invalid JSON Schema: $ref %q refers to itself", refString)
+		}
+		v.following[at] = true
+		err = sub(target, x, path)
+		delete(v.following, at)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if t, ok := schema["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []interface{}:
+			for _, elem := range t {
+				name, ok := elem.(string)
+				if !ok {
+					return nil, fmt.Errorf("invalid JSON Schema: type must be a string or an array of strings")
+				}
+				types = append(types, name)
+			}
+		default:
+			return nil, fmt.Errorf("invalid JSON Schema: type must be a string or an array of strings")
+		}
+		found := false
+		for _, name := range types {
+			if schemaHasType(x, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail(path, "type", "expected %s, got %s", strings.Join(types, " or "), schemaTypeName(x))
+			// The other keywords would only report the same problem again.
+			return errs, nil
+		}
+	}
+
+	if enum, ok := schema["enum"]; ok {
+		values, ok := enum.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid JSON Schema: enum must be an array")
+		}
+		found := false
+		for _, value := range values {
+			if reflect.DeepEqual(value, x) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			fail(path, "enum", "value is not one of the allowed values")
+		}
+	}
+	if c, ok := schema["const"]; ok && !reflect.DeepEqual(c, x) {
+		fail(path, "const", "value is not equal to the constant")
+	}
+
+	if err := v.validateType(schema, x, path, fail, sub); err != nil {
+		return nil, err
+	}
+
+	if allOf, ok := schema["allOf"]; ok {
+		schemas, ok := allOf.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid JSON Schema: allOf must be an array")
+		}
+		for _, subSchema := range schemas {
+			if err := sub(subSchema, x, path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, keyword := range []string{"anyOf", "oneOf"} {
+		raw, ok := schema[keyword]
+		if !ok {
+			continue
+		}
+		schemas, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid JSON Schema: %s must be an array", keyword)
+		}
+		count := 0
+		for _, subSchema := range schemas {
+			ok, err := matches(subSchema)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				count++
+			}
+		}
+		if count == 0 {
+			fail(path, keyword, "value does not match any of the schemas")
+		} else if keyword == "oneOf" && count > 1 {
+			fail(path, keyword, "value matches %d of the schemas, expected exactly one", count)
+		}
+	}
+	if not, ok := schema["not"]; ok {
+		ok, err := matches(not)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			fail(path, "not", "value must not match the schema")
+		}
+	}
+	return errs, nil
+}
+
+// validateType checks the keywords which apply only to a single type of values.
+func (v *schemaValidator) validateType(
+	schema map[string]interface{}, x interface{}, path string,
+	fail func(path string, keyword string, format string, args ...interface{}),
+	sub func(subSchema interface{}, subX interface{}, subPath string) error) error {
+
+	switch x := x.(type) {
+	case float64:
+		if n, ok, err := schemaNumber(schema, "minimum"); err != nil {
+			return err
+		} else if ok && x < n {
+			fail(path, "minimum", "%v is less than the minimum %v", x, n)
+		}
+		if n, ok, err := schemaNumber(schema, "maximum"); err != nil {
+			return err
+		} else if ok && x > n {
+			fail(path, "maximum", "%v is greater than the maximum %v", x, n)
+		}
+		if n, ok, err := schemaNumber(schema, "exclusiveMinimum"); err != nil {
+			return err
+		} else if ok && x <= n {
+			fail(path, "exclusiveMinimum", "%v must be greater than %v", x, n)
+		}
+		if n, ok, err := schemaNumber(schema, "exclusiveMaximum"); err != nil {
+			return err
+		} else if ok && x >= n {
+			fail(path, "exclusiveMaximum", "%v must be less than %v", x, n)
+		}
+		if n, ok, err := schemaNumber(schema, "multipleOf"); err != nil {
+			return err
+		} else if ok && n > 0 {
+			if q := x / n; q != math.Trunc(q) {
+				fail(path, "multipleOf", "%v is not a multiple of %v", x, n)
+			}
+		}
+
+	case string:
+		length := float64(utf8.RuneCountInString(x))
+		if n, ok, err := schemaNumber(schema, "minLength"); err != nil {
+			return err
+		} else if ok && length < n {
+			fail(path, "minLength", "string is shorter than %v characters", n)
+		}
+		if n, ok, err := schemaNumber(schema, "maxLength"); err != nil {
+			return err
+		} else if ok && length > n {
+			fail(path, "maxLength", "string is longer than %v characters", n)
+		}
+		if raw, ok := schema["pattern"]; ok {
+			p, ok := raw.(string)
+			if !ok {
+				return fmt.Errorf("invalid JSON Schema: pattern must be a string")
+			}
+			re, err := v.pattern(p)
+			if err != nil {
+				return err
+			}
+			if !re.MatchString(x) {
+				fail(path, "pattern", "string does not match the pattern %q", p)
+			}
+		}
+
+	case []interface{}:
+		length := float64(len(x))
+		if n, ok, err := schemaNumber(schema, "minItems"); err != nil {
+			return err
+		} else if ok && length < n {
+			fail(path, "minItems", "array has fewer than %v items", n)
+		}
+		if n, ok, err := schemaNumber(schema, "maxItems"); err != nil {
+			return err
+		} else if ok && length > n {
+			fail(path, "maxItems", "array has more than %v items", n)
+		}
+		if unique, ok := schema["uniqueItems"].(bool); ok && unique {
+		outer:
+			for a := range x {
+				for b := a + 1; b < len(x); b++ {
+					if reflect.DeepEqual(x[a], x[b]) {
+						fail(path, "uniqueItems", "items %d and %d are equal", a, b)
+						break outer
+					}
+				}
+			}
+		}
+		if items, ok := schema["items"]; ok {
+			tuple, isTuple := items.([]interface{})
+			for index, elem := range x {
+				itemSchema := items
+				if isTuple {
+					if index >= len(tuple) {
+						break
+					}
+					itemSchema = tuple[index]
+				}
+				if err := sub(itemSchema, elem, path+"/"+strconv.Itoa(index)); err != nil {
+					return err
+				}
+			}
+		}
+
+	case map[string]interface{}:
+		length := float64(len(x))
+		if n, ok, err := schemaNumber(schema, "minProperties"); err != nil {
+			return err
+		} else if ok && length < n {
+			fail(path, "minProperties", "object has fewer than %v properties", n)
+		}
+		if n, ok, err := schemaNumber(schema, "maxProperties"); err != nil {
+			return err
+		} else if ok && length > n {
+			fail(path, "maxProperties", "object has more than %v properties", n)
+		}
+		if raw, ok := schema["required"]; ok {
+			required, ok := raw.([]interface{})
+			if !ok {
+				return fmt.Errorf("invalid JSON Schema: required must be an array")
+			}
+			for _, name := range required {
+				nameString, ok := name.(string)
+				if !ok {
+					return fmt.Errorf("invalid JSON Schema: required must contain strings")
+				}
+				if _, ok := x[nameString]; !ok {
+					fail(path, "required", "missing required property %q", nameString)
+				}
+			}
+		}
+
+		properties, _ := schema["properties"].(map[string]interface{})
+		patternProperties, _ := schema["patternProperties"].(map[string]interface{})
+		patterns := make([]string, 0, len(patternProperties))
+		for p := range patternProperties {
+			patterns = append(patterns, p)
+		}
+		sort.Strings(patterns)
+		additional, hasAdditional := schema["additionalProperties"]
+		names := make([]string, 0, len(x))
+		for name := range x {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			elemPath := path + pointerToken(name)
+			matched := false
+			if propertySchema, ok := properties[name]; ok {
+				matched = true
+				if err := sub(propertySchema, x[name], elemPath); err != nil {
+					return err
+				}
+			}
+			for _, p := range patterns {
+				re, err := v.pattern(p)
+				if err != nil {
+					return err
+				}
+				if re.MatchString(name) {
+					matched = true
+					if err := sub(patternProperties[p], x[name], elemPath); err != nil {
+						return err
+					}
+				}
+			}
+			if matched || !hasAdditional {
+				continue
+			}
+			if allowed, ok := additional.(bool); ok && !allowed {
+				fail(elemPath, "additionalProperties", "property %q is not allowed", name)
+				continue
+			}
+			if err := sub(additional, x[name], elemPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
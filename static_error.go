@@ -0,0 +1,48 @@
+/*
+Copyright 2024 Google Inc. All rights reserved.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package jsonnet
+
+import (
+	"fmt"
+
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/internal/errors"
+)
+
+// StaticError is an error discovered before the evaluation of the program,
+// i.e. when lexing, parsing, desugaring or during the static analysis.
+type StaticError struct {
+	Message string
+	Loc     ast.LocationRange
+}
+
+func (err StaticError) Error() string {
+	loc := ""
+	if err.Loc.IsSet() {
+		loc = err.Loc.String()
+	}
+	return fmt.Sprintf("%v %v", loc, err.Message)
+}
+
+// makeStaticError converts the static errors of the parser and the static analyzer
+// to StaticError. Other errors are returned unchanged.
+func makeStaticError(err error) error {
+	if staticErr, ok := err.(errors.StaticError); ok {
+		return StaticError{Message: staticErr.Message(), Loc: staticErr.Loc()}
+	}
+	return err
+}
@@ -125,6 +125,21 @@ func (f *bindingsUnboundField) loc() *ast.LocationRange {
 	return f.inner.loc()
 }
 
+// commentedUnboundField is a field with the comment preceding it in the source,
+// kept for emitting it in the output.
+type commentedUnboundField struct {
+	inner   unboundField
+	comment []string
+}
+
+func (f *commentedUnboundField) evaluate(i *interpreter, sb selfBinding, origBinding bindingFrame, fieldName string) (value, error) {
+	return f.inner.evaluate(i, sb, origBinding, fieldName)
+}
+
+func (f *commentedUnboundField) loc() *ast.LocationRange {
+	return f.inner.loc()
+}
+
 // plusSuperUnboundField represents a `field+: ...` that hasn't been bound to an object.
 type plusSuperUnboundField struct {
 	inner unboundField
@@ -254,12 +269,20 @@ func makeClosure(env environment, function *ast.Function) *closure {
 }
 
 // NativeFunction represents a function implemented in Go.
+//
+// The arguments are passed in the standard Go JSON representation, i.e. nil, bool, float64,
+// string, []interface{} or map[string]interface{}. The result can be returned in the same
+// representation, or as json.RawMessage, which is parsed directly into the Jsonnet value.
 type NativeFunction struct {
 	Name   string
 	Func   func([]interface{}) (interface{}, error)
 	Params ast.Identifiers
 }
 
+// NativeResolver provides a native function which is not registered in the VM.
+// It returns false if there is no function with the given name.
+type NativeResolver func(name string) (*NativeFunction, bool)
+
 // evalCall evaluates a call to a NativeFunction and returns the result.
 func (native *NativeFunction) evalCall(arguments callArguments, i *interpreter) (value, error) {
 	flatArgs := flattenArgs(arguments, native.parameters(), []value{})
@@ -275,6 +298,11 @@ func (native *NativeFunction) evalCall(arguments callArguments, i *interpreter)
 		}
 		nativeArgs = append(nativeArgs, json)
 	}
+	if i.nativeCallHook != nil {
+		if err := i.nativeCallHook(native.Name, nativeArgs); err != nil {
+			return nil, i.Error(err.Error())
+		}
+	}
 	call := func() (resultJSON interface{}, err error) {
 		defer func() {
 			if r := recover(); r != nil {
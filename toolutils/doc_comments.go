@@ -0,0 +1,89 @@
+package toolutils
+
+import (
+	"strings"
+
+	"github.com/google/go-jsonnet/ast"
+)
+
+// DocComment returns the text of the last documentation comment (/** ... */)
+// in the fodder. The comment markers and the leading asterisks of the lines are removed.
+func DocComment(fodder ast.Fodder) (string, bool) {
+	for i := len(fodder) - 1; i >= 0; i-- {
+		comment := fodder[i].Comment
+		if len(comment) == 0 || !strings.HasPrefix(comment[0], "/**") {
+			continue
+		}
+		text := strings.Join(comment, "\n")
+		if !strings.HasSuffix(text, "*/") || len(text) < len("/**/") {
+			continue
+		}
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "/**"), "*/")
+		lines := strings.Split(text, "\n")
+		for j, line := range lines {
+			line = strings.TrimSpace(line)
+			if j > 0 {
+				line = strings.TrimSpace(strings.TrimPrefix(line, "*"))
+			}
+			lines[j] = line
+		}
+		return strings.TrimSpace(strings.Join(lines, "\n")), true
+	}
+	return "", false
+}
+
+// DocComments extracts documentation comments of the declarations in a raw
+// (not desugared) AST, e.g. one returned by parser.SnippetToRawAST.
+// Top-level locals are keyed by their names, object fields by their dotted
+// paths from the root object (e.g. "lib.add").
+func DocComments(node ast.Node) map[string]string {
+	docs := make(map[string]string)
+	collectDocComments(node, "", docs)
+	return docs
+}
+
+func collectDocComments(node ast.Node, prefix string, docs map[string]string) {
+	switch node := node.(type) {
+	case *ast.Local:
+		// Only the top-level locals are documented.
+		if prefix == "" {
+			for i, bind := range node.Binds {
+				fodder := bind.VarFodder
+				if i == 0 {
+					// The comment is placed before the local keyword.
+					fodder = ast.FodderConcat(node.NodeBase.Fodder, fodder)
+				}
+				if doc, ok := DocComment(fodder); ok {
+					docs[string(bind.Variable)] = doc
+				}
+			}
+		}
+		collectDocComments(node.Body, prefix, docs)
+
+	case *ast.Object:
+		for _, field := range node.Fields {
+			var name string
+			var fodder ast.Fodder
+			switch field.Kind {
+			case ast.ObjectFieldID:
+				name = string(*field.Id)
+				fodder = field.Fodder1
+			case ast.ObjectFieldStr:
+				str, ok := field.Expr1.(*ast.LiteralString)
+				if !ok {
+					continue
+				}
+				name = str.Value
+				fodder = *str.OpenFodder()
+			default:
+				continue
+			}
+			if doc, ok := DocComment(fodder); ok {
+				docs[prefix+name] = doc
+			}
+			if field.Method == nil {
+				collectDocComments(field.Expr2, prefix+name+".", docs)
+			}
+		}
+	}
+}
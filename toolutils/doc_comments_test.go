@@ -0,0 +1,48 @@
+package toolutils
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/google/go-jsonnet/internal/parser"
+)
+
+func TestDocComments(t *testing.T) {
+	node, _, err := parser.SnippetToRawAST("lib.libsonnet", "lib.libsonnet", `/** Top-level helper. */
+local helper = 1,
+  /**
+   * The second local.
+   *
+   * Spans multiple lines.
+   */
+  second = 2;
+{
+  /** Adds one to x. */
+  add(x):: x + 1,
+  // Not a doc comment.
+  plain: 1,
+  /* Not a doc comment either. */
+  other: 2,
+  /** A quoted field. */
+  'quoted-name': {
+    local ignored = 1,
+    /** A nested field. */
+    inner: ignored,
+  },
+}
+`)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"helper":            "Top-level helper.",
+		"second":            "The second local.\n\nSpans multiple lines.",
+		"add":               "Adds one to x.",
+		"quoted-name":       "A quoted field.",
+		"quoted-name.inner": "A nested field.",
+	}
+	docs := DocComments(node)
+	if !reflect.DeepEqual(docs, expected) {
+		t.Errorf("Expected %q, but got %q", expected, docs)
+	}
+}
@@ -19,6 +19,7 @@ package jsonnet
 import (
 	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/google/go-jsonnet/ast"
 )
@@ -450,6 +451,11 @@ type valueObject struct {
 	assertionError error
 	cache          map[objectCacheKey]value
 	uncached       uncachedObject
+
+	// nesting is the number of objects whose fields were evaluated when the object
+	// was created, including itself, or 0 for the objects created by the builtins.
+	// It is used for enforcing VM.SetMaxObjectNesting.
+	nesting int
 }
 
 // Hack - we need to distinguish not-checked-yet and no error situations
@@ -566,6 +572,9 @@ type simpleObject struct {
 	fields   simpleObjectFieldMap
 	asserts  []unboundField
 	locals   []objectLocal
+	// The names of the fields in the order of their declaration in the source code,
+	// nil if the object was not created from an object literal.
+	fieldOrder []string
 }
 
 func checkAssertionsHelper(i *interpreter, obj *valueObject, curr uncachedObject, superDepth int) error {
@@ -672,6 +681,10 @@ func makeValueExtendedObject(left, right *valueObject) *valueObject {
 			right:                right.uncached,
 			totalInheritanceSize: left.uncached.inheritanceSize() + right.uncached.inheritanceSize(),
 		},
+		nesting: left.nesting,
+	}
+	if right.nesting > v.nesting {
+		v.nesting = right.nesting
 	}
 
 	// Copy the value history, mark records as partial because objects are merged.
@@ -754,7 +767,12 @@ func objectIndex(i *interpreter, sb selfBinding, fieldName string) (value, error
 	fieldSelfBinding := selfBinding{self: sb.self, superDepth: foundAt}
 	fieldUpValues := prepareFieldUpvalues(fieldSelfBinding, upValues, locals)
 
+	outerNesting := i.objectNesting
+	if sb.self.nesting > 0 {
+		i.objectNesting = sb.self.nesting
+	}
 	val, err := field.field.evaluate(i, fieldSelfBinding, fieldUpValues, fieldName)
+	i.objectNesting = outerNesting
 
 	if err == nil {
 		sb.self.cache[objectCacheKey{field: fieldName, depth: foundAt}] = val
@@ -802,7 +820,12 @@ func objectFieldsVisibility(obj *valueObject) fieldHideMap {
 	return uncachedObjectFieldsVisibility(obj.uncached)
 }
 
-// Returns field names of an object. Gotcha: the order of fields is unpredictable.
+// objectFields returns the names of the fields in the sorted order,
+// so that the iteration order does not depend on how the object was built.
+// A field defined on several levels of inheritance is returned once, its visibility
+// is resolved as in uncachedObjectFieldsVisibility and indexing it gives the final,
+// overridden value. std.objectFields, std.objectValues, std.objectKeysValues and
+// std.objectPairs all rely on this.
 func objectFields(obj *valueObject, h hidden) []string {
 	var r []string
 	for fieldName, hide := range objectFieldsVisibility(obj) {
@@ -810,9 +833,54 @@ func objectFields(obj *valueObject, h hidden) []string {
 			r = append(r, fieldName)
 		}
 	}
+	sort.Strings(r)
 	return r
 }
 
+// objectFieldsInDeclarationOrder returns the field names of an object in the order
+// in which they were declared. The fields of the left side of an inheritance come first
+// and the overridden fields keep their original position. The fields of the objects
+// which were not created from an object literal, e.g. by std functions, are sorted.
+func objectFieldsInDeclarationOrder(obj *valueObject, h hidden) []string {
+	visibility := objectFieldsVisibility(obj)
+	var r []string
+	for _, fieldName := range uncachedObjectFieldOrder(obj.uncached) {
+		if h == withHidden || visibility[fieldName] != ast.ObjectFieldHidden {
+			r = append(r, fieldName)
+		}
+	}
+	return r
+}
+
+func uncachedObjectFieldOrder(obj uncachedObject) []string {
+	switch obj := obj.(type) {
+	case *extendedObject:
+		r := uncachedObjectFieldOrder(obj.left)
+		seen := make(map[string]bool, len(r))
+		for _, fieldName := range r {
+			seen[fieldName] = true
+		}
+		for _, fieldName := range uncachedObjectFieldOrder(obj.right) {
+			if !seen[fieldName] {
+				r = append(r, fieldName)
+			}
+		}
+		return r
+
+	case *simpleObject:
+		if obj.fieldOrder != nil {
+			return append([]string(nil), obj.fieldOrder...)
+		}
+		r := make([]string, 0, len(obj.fields))
+		for fieldName := range obj.fields {
+			r = append(r, fieldName)
+		}
+		sort.Strings(r)
+		return r
+	}
+	return nil
+}
+
 func duplicateFieldNameErrMsg(fieldName string) string {
 	return fmt.Sprintf("Duplicate field name: %s", unparseString(fieldName))
 }
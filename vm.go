@@ -17,14 +17,17 @@ limitations under the License.
 package jsonnet
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime/debug"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/google/go-jsonnet/ast"
 	"github.com/google/go-jsonnet/internal/parser"
@@ -41,6 +44,8 @@ type VM struct { //nolint:govet
 	ext            vmExtMap
 	tla            vmExtMap
 	nativeFuncs    map[string]*NativeFunction
+	stdFuncs       map[string]*NativeFunction
+	deprecatedStd  map[string]string
 	globalBinding  globalBindingMap
 	importer       Importer
 	ErrorFormatter ErrorFormatter
@@ -49,6 +54,82 @@ type VM struct { //nolint:govet
 	traceOut       io.Writer
 	notifier       Notifier
 	interpreter    *interpreter
+
+	// Transforms the leaf values of the output, nil when not set
+	fieldTransformHook FieldTransformHook
+
+	// Called before each native function call, nil when not set
+	nativeCallHook NativeCallHook
+
+	// Locals in the scope of which the snippets are evaluated, nil when not set
+	preamble *program.Preamble
+
+	// Whether a newline is appended to the result in the string output mode
+	stringOutputNewline bool
+
+	// Kinds of imports which are allowed during evaluation
+	importPolicy ImportPolicy
+
+	// What imports do when the file is not found
+	missingImportPolicy MissingImportPolicy
+
+	// What std.extVar does when the variable is not defined
+	missingExtVarPolicy MissingExtVarPolicy
+
+	// Provides native functions which are not registered
+	nativeResolver NativeResolver
+
+	// Maximum number of distinct files imported in a single evaluation, 0 means no limit
+	maxImports int
+
+	// Maximum number of elements or fields produced by a single comprehension, 0 means no limit
+	maxComprehensionSize int
+
+	// Maximum length of the arrays and strings built by std.makeArray, std.range and std.repeat, 0 means no limit
+	maxArrayLength int
+
+	// Maximum nesting of the arrays and objects in the manifested values, 0 means no limit
+	maxManifestDepth int
+
+	// Maximum nesting of the objects created during the evaluation, 0 means no limit
+	maxObjectNesting int
+
+	// Whether the location of every evaluated node is skipped, making the stack traces coarse
+	noLocationTracking bool
+
+	// Whether the std variable is unavailable to the evaluated code
+	stdlibDisabled bool
+
+	// Whether the comments preceding the object fields are added to the JSON output
+	emitComments bool
+
+	// Separator of the lines in the output, replacing every newline
+	lineSeparator string
+
+	// Whether the numeric-looking keys are sorted by their value in the output
+	naturalKeyOrder bool
+
+	// Whether the empty arrays are written as "[]" instead of "[ ]" in the output
+	noArrayPadding bool
+
+	// Whether the empty objects are written as "{}" instead of "{ }" in the output
+	noObjectPadding bool
+
+	// Whether a null anywhere in the output is an error
+	rejectNullOutput bool
+
+	// Source of the current time for the native functions, time.Now when nil
+	clock func() time.Time
+
+	// Output formats registered for EvaluateSnippetAs, in addition to the builtin ones
+	outputFormats map[string]OutputFormatter
+
+	// Convert the values of custom Go types returned by native functions
+	valueMarshalers map[reflect.Type]ValueMarshaler
+
+	// Locations where the evaluation pauses and calls the step hook
+	breakpoints map[ast.Location]struct{}
+	stepHook    StepHook
 }
 
 type Notifier interface {
@@ -59,6 +140,18 @@ type Notifier interface {
 	OnGeneratedValue(fnName string, args []interface{}, partial bool, partialValue, finalValue interface{}, steps []interface{})
 }
 
+// FieldTransformHook is called with every leaf value of the output, i.e. every value
+// which is not an array or an object, and returns the value written instead of it.
+// The path consists of the ObjectFieldStep and ArrayIndexStep values leading
+// from the root of the output to the leaf, like the steps of Notifier.
+// The value and the result are in the standard Go JSON representation.
+type FieldTransformHook func(path []interface{}, value interface{}) interface{}
+
+// NativeCallHook is called before a native function is executed, with the name
+// of the function and the arguments in the standard Go JSON representation.
+// If it returns an error, the function is not called and the call fails with the error.
+type NativeCallHook func(name string, args []interface{}) error
+
 // extKind indicates the kind of external variable that is being initialized for the VM
 type extKind int
 
@@ -80,6 +173,17 @@ type vmExt struct {
 
 type vmExtMap map[string]vmExt
 
+// MissingExtVarPolicy controls what happens when std.extVar is called
+// with a name of an undefined external variable.
+type MissingExtVarPolicy int
+
+const (
+	// MissingExtVarError makes std.extVar fail with a runtime error (the default).
+	MissingExtVarError MissingExtVarPolicy = iota
+	// MissingExtVarNull makes std.extVar return null.
+	MissingExtVarNull
+)
+
 type globalBindingMap bindingFrame
 
 func (m globalBindingMap) Identifiers() (out []ast.Identifier) {
@@ -98,11 +202,16 @@ func MakeVM() *VM {
 		ext:            make(vmExtMap),
 		tla:            make(vmExtMap),
 		nativeFuncs:    make(map[string]*NativeFunction),
+		stdFuncs:       make(map[string]*NativeFunction),
+		deprecatedStd:  make(map[string]string),
 		globalBinding:  globalBinding,
 		ErrorFormatter: &termErrorFormatter{pretty: false, maxStackTraceSize: 20},
 		importer:       &FileImporter{},
 		importCache:    makeImportCache(defaultImporter, globalBinding),
 		traceOut:       os.Stderr,
+
+		stringOutputNewline: true,
+		lineSeparator:       "\n",
 	}
 }
 
@@ -123,6 +232,13 @@ func (vm *VM) SetTraceOut(traceOut io.Writer) {
 	vm.traceOut = traceOut
 }
 
+// SetStringOutputTrailingNewline sets whether a newline is appended to the result
+// when StringOutput is enabled. It is appended by default.
+// The JSON output always ends with a newline.
+func (vm *VM) SetStringOutputTrailingNewline(newline bool) {
+	vm.stringOutputNewline = newline
+}
+
 // ExtVar binds a Jsonnet external var to the given value.
 func (vm *VM) ExtVar(key string, val string) {
 	vm.ext[key] = vmExt{value: val, kind: extKindVar}
@@ -141,12 +257,34 @@ func (vm *VM) ExtNode(key string, node ast.Node) {
 	vm.flushValueCache()
 }
 
+// ExtVarGo binds a Jsonnet external code var to the given Go value.
+// Structs, maps, slices and scalars are converted directly to Jsonnet values,
+// following the encoding/json rules including the `json` struct tags.
+// Integers are not rounded like in a round-trip through JSON: those which cannot
+// be represented exactly as a Jsonnet number, i.e. a float64, are an error.
+// Cyclic values are an error as well, like in encoding/json.
+func (vm *VM) ExtVarGo(key string, val interface{}) error {
+	node, err := goValueToNode(reflect.ValueOf(val), key)
+	if err != nil {
+		return fmt.Errorf("ext var %q: %v", key, err)
+	}
+	vm.ExtNode(key, node)
+	return nil
+}
+
 // ExtReset rests all external variables registered for this VM.
 func (vm *VM) ExtReset() {
 	vm.ext = make(vmExtMap)
 	vm.flushValueCache()
 }
 
+// SetMissingExtVarPolicy sets what std.extVar does for undefined external variables.
+// By default, it is a runtime error.
+func (vm *VM) SetMissingExtVarPolicy(policy MissingExtVarPolicy) {
+	vm.missingExtVarPolicy = policy
+	vm.flushValueCache()
+}
+
 // TLAVar binds a Jsonnet top level argument to the given value.
 func (vm *VM) TLAVar(key string, val string) {
 	vm.tla[key] = vmExt{value: val, kind: extKindVar}
@@ -173,9 +311,262 @@ func (vm *VM) TLAReset() {
 }
 
 // Importer sets Importer to use during evaluation (import callback).
+//
+// The imported files are parsed again only when the new importer returns different
+// contents for them, which makes setting a new importer with the edited files cheap,
+// e.g. in an editor. Within one importer, every file is only imported once, as the importer
+// must return the same contents for it, and the main snippet is parsed on every evaluation,
+// so editing only the main snippet doesn't call the importer for the unchanged dependencies.
 func (vm *VM) Importer(i Importer) {
 	vm.importer = i
+	vm.ResetImportCache()
+}
+
+// ResetImportCache clears the imported files and their values, so that the next
+// evaluation imports them through the importer again, e.g. after they were changed.
+// Unlike creating a new VM, it keeps the rest of the state, including the frozen
+// interpreter with the evaluated stdlib. Like with a new importer, the files are
+// parsed again only when their contents have changed.
+func (vm *VM) ResetImportCache() {
+	previousASTs := vm.importCache.astCache
 	vm.flushCache()
+	vm.importCache.previousASTs = previousASTs
+	if vm.interpreter != nil {
+		vm.interpreter.importCache = vm.importCache
+	}
+}
+
+// SetImportPolicy sets which kinds of imports are allowed during evaluation,
+// e.g. ImportDenyAll prevents evaluated code from accessing any files.
+// By default, all imports are allowed.
+func (vm *VM) SetImportPolicy(policy ImportPolicy) {
+	vm.importPolicy = policy
+}
+
+// SetMaxImports limits the number of distinct files which can be imported
+// (using import, importstr or importbin) in a single evaluation.
+// Exceeding the limit is a runtime error. By default, there is no limit, which can be
+// restored by setting it to 0.
+//
+// With a limit, the values of the imported files are not reused between the evaluations,
+// as the imports made while computing them would not be counted again, so every evaluation
+// evaluates its imports from scratch. The files are still read and parsed only once.
+// This makes the repeated evaluations, e.g. by EvaluateBatch, slower.
+func (vm *VM) SetMaxImports(n int) {
+	vm.maxImports = n
+}
+
+// SetMaxComprehensionSize limits the number of elements of an array comprehension
+// and the number of fields of an object comprehension. The limit also applies to std.flatMap,
+// which the array comprehensions are built on, including the length of its string results.
+// It is checked as the elements are produced, so an oversized comprehension fails without
+// building the whole result. Exceeding the limit is a runtime error. By default, there is
+// no limit, which can be restored by setting it to 0.
+func (vm *VM) SetMaxComprehensionSize(n int) {
+	vm.maxComprehensionSize = n
+	vm.flushValueCache()
+}
+
+// SetMaxArrayLength limits the length of the arrays built by std.makeArray, std.range
+// and std.repeat, and of the strings built by std.repeat. The length is checked before
+// the result is allocated, so a huge size fails with a "result too large" runtime error
+// instead of exhausting the memory. By default, there is no limit, which can be restored
+// by setting it to 0.
+func (vm *VM) SetMaxArrayLength(n int) {
+	vm.maxArrayLength = n
+	vm.flushValueCache()
+}
+
+// SetMaxManifestDepth limits the nesting of the arrays and objects when a value is manifested,
+// both for the output and in std.manifestJson and std.manifestJsonEx. Exceeding the limit
+// is a runtime error, which stops the manifestation of infinite structures, e.g.
+// `{ a: self }`, sooner and with a clearer message than the stack limit. The limit should
+// therefore be lower than MaxStack. By default, there is no limit, which can be restored
+// by setting it to 0.
+func (vm *VM) SetMaxManifestDepth(n int) {
+	vm.maxManifestDepth = n
+	vm.flushValueCache()
+}
+
+// SetMaxObjectNesting limits the nesting of the objects created during the evaluation.
+// An object created while a field of another object is evaluated is nested one level
+// deeper than that object, so e.g. `{ a: { b: {} } }` has three levels. The elements
+// of arrays are evaluated lazily, when they are used, so an object in an array
+// does not count the objects around the array. The nesting is counted when
+// the objects are created, so deeply nested compositions, like
+// a recursive function returning `{ a: f(n - 1), b: f(n - 1) }`, fail before they are
+// manifested. Exceeding the limit is a runtime error. By default, there is no limit,
+// which can be restored by setting it to 0.
+func (vm *VM) SetMaxObjectNesting(n int) {
+	vm.maxObjectNesting = n
+	vm.flushValueCache()
+}
+
+// SetLocationTracking sets whether the location of every evaluated expression is tracked
+// for the stack traces of the runtime errors. Disabling it makes the evaluation faster,
+// but the errors only report the location where the function or the thunk which failed
+// started to be evaluated, instead of the exact expression. It has no effect when
+// debugging with breakpoints or a step hook. By default, it is enabled.
+func (vm *VM) SetLocationTracking(enabled bool) {
+	vm.noLocationTracking = !enabled
+}
+
+// SetStdlibEnabled sets whether the standard library is available. When it is disabled,
+// using the std variable is a runtime error ("std is disabled"), while the operators
+// implemented with the standard library, such as %, keep working.
+// By default, it is enabled.
+func (vm *VM) SetStdlibEnabled(enabled bool) {
+	vm.stdlibDisabled = !enabled
+	vm.flushValueCache()
+}
+
+// SetEmitComments sets whether the comments preceding the object fields in the source
+// are added to the JSON output, which makes it JSONC. Comments starting with # are
+// changed to // comments. When a field overrides another one without a comment,
+// the comment of the overridden field is used. Only the regular JSON output
+// is affected, not the string, multi-file or stream output.
+// By default, no comments are emitted.
+func (vm *VM) SetEmitComments(emit bool) {
+	vm.emitComments = emit
+	vm.flushValueCache()
+}
+
+// SetLineSeparator sets the separator of the lines in the output, e.g. "\r\n" for
+// Windows-style line endings. Every newline in the output is replaced, including
+// the trailing one and the ones inside the strings of the string output. The "\r\n"
+// line endings in the strings are replaced as a single newline. The default is "\n".
+func (vm *VM) SetLineSeparator(sep string) {
+	vm.lineSeparator = sep
+}
+
+// SetNaturalKeyOrder sets whether the object fields are sorted by their numeric value
+// in the output when the names of all the fields of an object consist only of ASCII digits,
+// e.g. "2" comes before "10". The fields with the same value, like "1" and "01", are sorted
+// lexically. The objects with any other field names keep the default lexical order,
+// and so do the values of std functions like std.objectFields or std.manifestJson.
+// By default, the lexical order is used for all the objects.
+func (vm *VM) SetNaturalKeyOrder(natural bool) {
+	vm.naturalKeyOrder = natural
+}
+
+// SetArrayPadding sets whether the empty arrays are written with a space between
+// the brackets, "[ ]", in the output. When disabled, they are written as "[]".
+// The padding is enabled by default, like in the C++ implementation. It only affects
+// the output, not the values of std functions like std.toString or std.manifestJson.
+func (vm *VM) SetArrayPadding(padding bool) {
+	vm.noArrayPadding = !padding
+}
+
+// SetObjectPadding sets whether the empty objects are written with a space between
+// the braces, "{ }", in the output. When disabled, they are written as "{}".
+// The padding is enabled by default, like in the C++ implementation. It only affects
+// the output, not the values of std functions like std.toString or std.manifestJson.
+func (vm *VM) SetObjectPadding(padding bool) {
+	vm.noObjectPadding = !padding
+}
+
+// SetRejectNullOutput sets whether a null anywhere in the output, e.g. as a field value
+// or an array element, is a runtime error reporting its JSON pointer, like "/spec/replicas".
+// Unlike std.prune, which removes the nulls, it surfaces the mistakes for the schemas which
+// forbid them. The nulls in the values of std functions, like std.manifestJson, are not checked.
+// By default, the nulls are allowed.
+func (vm *VM) SetRejectNullOutput(reject bool) {
+	vm.rejectNullOutput = reject
+}
+
+// outputStyle returns the style of the JSON output given by the VM settings.
+func (vm *VM) outputStyle() outputStyle {
+	return outputStyle{
+		naturalKeyOrder: vm.naturalKeyOrder,
+		noArrayPadding:  vm.noArrayPadding,
+		noObjectPadding: vm.noObjectPadding,
+	}
+}
+
+// SetClock sets the source of the current time returned by vm.Now. The native functions
+// which depend on the current time should read it from vm.Now instead of time.Now,
+// so that the time can be frozen, e.g. in tests or reproducible builds.
+// Setting it to nil restores the default, time.Now.
+func (vm *VM) SetClock(clock func() time.Time) {
+	vm.clock = clock
+	vm.flushValueCache()
+}
+
+// Now returns the current time according to the clock set by SetClock.
+func (vm *VM) Now() time.Time {
+	if vm.clock == nil {
+		return time.Now()
+	}
+	return vm.clock()
+}
+
+// SetTabSize sets the width of a tab in the code shown in the error messages. If it is positive,
+// the tabs are expanded to spaces and the erroneous part of a line is underlined with carets,
+// which are aligned with the code even when it is indented with tabs. By default, it is 0,
+// the code is shown as it is and not underlined. It only affects the default ErrorFormatter.
+func (vm *VM) SetTabSize(n int) {
+	if ef, ok := vm.ErrorFormatter.(*termErrorFormatter); ok {
+		ef.tabSize = n
+	}
+}
+
+// SetBreakpoint makes the evaluation pause before evaluating an expression beginning
+// at the location, in any file except the standard library. If the column is 0, it pauses
+// at the outermost expression beginning on the line, and not again on the line, e.g. at its
+// lazily evaluated parts, until the evaluation pauses elsewhere. The step hook is called
+// when paused, see SetStepHook.
+func (vm *VM) SetBreakpoint(loc ast.Location) {
+	if vm.breakpoints == nil {
+		vm.breakpoints = make(map[ast.Location]struct{})
+	}
+	vm.breakpoints[loc] = struct{}{}
+}
+
+// ClearBreakpoints removes all breakpoints.
+func (vm *VM) ClearBreakpoints() {
+	vm.breakpoints = nil
+}
+
+// SetStepHook sets a function which is called whenever the evaluation is paused, at a breakpoint
+// or after stepping. It can inspect the expression about to be evaluated and it returns
+// where to pause next. The breakpoints have no effect without a step hook.
+func (vm *VM) SetStepHook(hook StepHook) {
+	vm.stepHook = hook
+}
+
+func (vm *VM) debugger() *debugger {
+	if vm.stepHook == nil {
+		return nil
+	}
+	breakpoints := make(map[ast.Location]struct{}, len(vm.breakpoints))
+	for loc := range vm.breakpoints {
+		breakpoints[loc] = struct{}{}
+	}
+	return &debugger{breakpoints: breakpoints, hook: vm.stepHook}
+}
+
+// SetMissingImportPolicy sets what imports do when the imported file cannot be found.
+// By default, it is a runtime error.
+func (vm *VM) SetMissingImportPolicy(policy MissingImportPolicy) {
+	vm.missingImportPolicy = policy
+	vm.flushValueCache()
+}
+
+// ValueMarshaler converts a Go value of a custom type to a value which can be
+// converted to Jsonnet, i.e. nil, bool, a number, string, []interface{},
+// map[string]interface{} or a value of another type with a registered marshaler.
+type ValueMarshaler func(interface{}) (interface{}, error)
+
+// RegisterValueMarshaler sets how the values of the given type returned by native functions,
+// e.g. time.Time, are converted to Jsonnet values. The type must match exactly, including
+// whether it is a pointer. The values can be nested in the arrays and objects returned
+// by the native functions.
+func (vm *VM) RegisterValueMarshaler(t reflect.Type, marshal func(interface{}) (interface{}, error)) {
+	if vm.valueMarshalers == nil {
+		vm.valueMarshalers = make(map[reflect.Type]ValueMarshaler)
+	}
+	vm.valueMarshalers[t] = marshal
+	vm.flushValueCache()
 }
 
 // NativeFunction registers a native function.
@@ -184,16 +575,137 @@ func (vm *VM) NativeFunction(f *NativeFunction) {
 	vm.flushValueCache()
 }
 
-// Bind registers a global identifier.
+// SetNativeResolver sets a resolver which is called when std.native is used
+// with a name of a function which is not registered. It allows the native functions
+// to be provided on demand. The resolved functions are not registered in the VM, the resolver
+// is called again in the next evaluation, but only once per name within an evaluation.
+func (vm *VM) SetNativeResolver(resolver NativeResolver) {
+	vm.nativeResolver = resolver
+	vm.flushValueCache()
+}
+
+// AddStdFunc registers a function implemented in Go as a member of the std object,
+// so that it can be called directly as std.name(...) instead of through std.native.
+// The arguments and the result are passed the same way as for native functions.
+// It is an error to register a function with the name of an existing std member.
+func (vm *VM) AddStdFunc(name string, params ast.Identifiers, fn func(args []interface{}) (interface{}, error)) error {
+	if isStdMember(name) {
+		return fmt.Errorf("std.%s is already defined", name)
+	}
+	vm.stdFuncs[name] = &NativeFunction{Name: name, Params: params, Func: fn}
+	vm.flushValueCache()
+	return nil
+}
+
+// DeprecateStdMember makes using the std member with the given name a runtime error
+// with the message "std.<name> is deprecated: <message>", e.g. to enforce a policy
+// banning some functions. The members of the standard library which use the deprecated
+// member fail as well. Names which are not std members are ignored.
+func (vm *VM) DeprecateStdMember(name string, message string) {
+	vm.deprecatedStd[name] = message
+	vm.flushValueCache()
+}
+
+// Bind registers a global identifier. It is visible in the evaluated code
+// and in all the imported files.
 func (vm *VM) Bind(identifier ast.Identifier, body ast.Node) {
+	_, rebound := vm.globalBinding[identifier]
 	vm.globalBinding[identifier] = &cachedThunk{body: body}
-	vm.flushValueCache()
+	if rebound {
+		vm.flushValueCache()
+	} else {
+		vm.importCache.flushASTCache()
+	}
+}
+
+// BindAll registers several global identifiers at once, replacing the ones already bound
+// with the same names.
+func (vm *VM) BindAll(bindings map[string]ast.Node) {
+	added := false
+	for name, body := range bindings {
+		if _, ok := vm.globalBinding[ast.Identifier(name)]; !ok {
+			added = true
+		}
+		vm.globalBinding[ast.Identifier(name)] = &cachedThunk{body: body}
+	}
+	if added {
+		vm.importCache.flushASTCache()
+	} else {
+		vm.flushValueCache()
+	}
+}
+
+// Unbind removes a global identifier registered by Bind or BindAll.
+// It does nothing if the identifier is not bound.
+func (vm *VM) Unbind(name string) {
+	if _, ok := vm.globalBinding[ast.Identifier(name)]; !ok {
+		return
+	}
+	delete(vm.globalBinding, ast.Identifier(name))
+	// The imported files were checked with the identifier bound, so they are parsed again.
+	vm.importCache.flushASTCache()
+}
+
+// BoundGlobals returns the names of the bound global identifiers in the sorted order.
+func (vm *VM) BoundGlobals() []string {
+	names := make([]string, 0, len(vm.globalBinding))
+	for identifier := range vm.globalBinding {
+		names = append(names, string(identifier))
+	}
+	sort.Strings(names)
+	return names
 }
 
 func (vm *VM) Notifier(v Notifier) {
 	vm.notifier = v
 }
 
+// SetFieldTransformHook sets the hook applied to every leaf value of the output when
+// it is manifested, e.g. for redacting the secrets. Unlike the Notifier, which is only
+// called for the values generated by the native functions, the hook is called for all
+// the values. The values manifested by the std functions, like std.manifestJson,
+// are not part of the output structure and are not transformed. Setting nil removes the hook.
+func (vm *VM) SetFieldTransformHook(hook FieldTransformHook) {
+	vm.fieldTransformHook = hook
+}
+
+// SetNativeCallHook sets the hook called before each native function call, e.g. for
+// rate limiting, auditing or validation of the arguments. It is called for the functions
+// registered by NativeFunction, resolved by the NativeResolver and added by AddStdFunc.
+// Setting nil removes the hook.
+func (vm *VM) SetNativeCallHook(hook NativeCallHook) {
+	vm.nativeCallHook = hook
+}
+
+// SetPreamble sets the local declarations, e.g. "local double(x) = 2 * x;", which are
+// in the scope of every evaluated snippet, as if the snippet started with them. The imported
+// files are not affected. The code is checked when it is set and the locations in the errors
+// of the snippets are not shifted by it. Setting an empty code removes the preamble.
+func (vm *VM) SetPreamble(code string) error {
+	if code == "" {
+		vm.preamble = nil
+		return nil
+	}
+	preamble, err := program.ParsePreamble("<preamble>", code, vm.GlobalVars()...)
+	if err != nil {
+		return makeStaticError(err)
+	}
+	vm.preamble = preamble
+	return nil
+}
+
+// snippetToAST converts a snippet to a desugared and analyzed AST in the scope
+// of the preamble and the global variables.
+func (vm *VM) snippetToAST(diagnosticFileName ast.DiagnosticFileName, filename string, snippet string) (ast.Node, error) {
+	node, _, err := vm.snippetToASTTimed(diagnosticFileName, filename, snippet)
+	return node, err
+}
+
+// snippetToASTTimed is like snippetToAST, but it also measures how long each step took.
+func (vm *VM) snippetToASTTimed(diagnosticFileName ast.DiagnosticFileName, filename string, snippet string) (ast.Node, program.Timings, error) {
+	return program.SnippetToASTTimed(diagnosticFileName, filename, snippet, vm.preamble, vm.GlobalVars()...)
+}
+
 func (vm *VM) GlobalVars() (out []ast.Identifier) {
 	for identifier := range vm.globalBinding {
 		out = append(out, identifier)
@@ -204,11 +716,36 @@ func (vm *VM) GlobalVars() (out []ast.Identifier) {
 type evalKind int
 
 const (
-	evalKindRegular evalKind = iota
-	evalKindMulti            = iota
-	evalKindStream           = iota
+	evalKindRegular  evalKind = iota
+	evalKindMulti             = iota
+	evalKindStream            = iota
+	evalKindJSON              = iota
+	evalKindWithJSON          = iota
 )
 
+// outputWithJSON is the result of evalKindWithJSON.
+type outputWithJSON struct {
+	output string
+	json   interface{}
+}
+
+// PartialEval returns a simplified copy of a desugared program (e.g. one returned by SnippetToAST),
+// with the subexpressions which depend only on literals and the given external variables
+// evaluated in advance. The parts depending on anything else are left for the evaluation.
+// The node itself is not modified.
+//
+// The result can be evaluated many times using Evaluate. It gives the same result as the original
+// program, as long as the VM defines the known external variables with the same values.
+// Only the string external variables can be known in advance, and only the calls of std.extVar
+// in the program itself are replaced, not the ones in the imported files.
+func (vm *VM) PartialEval(node ast.Node, knownExtVars map[string]string) (ast.Node, error) {
+	folded := ast.Clone(node)
+	if err := program.FoldConstants(&folded, knownExtVars, vm.GlobalVars()...); err != nil {
+		return nil, err
+	}
+	return folded, nil
+}
+
 // version is the current gojsonnet's version
 const version = "v0.20.0"
 
@@ -227,7 +764,7 @@ func (vm *VM) Evaluate(node ast.Node) (val string, err error) {
 		return "", err
 	}
 
-	return evaluate(i, node, vm.tla, vm.StringOutput)
+	return evaluate(i, node, vm.tla, vm.StringOutput, vm.stringOutputNewline)
 }
 
 // EvaluateStream evaluates a Jsonnet program given by an Abstract Syntax Tree
@@ -265,6 +802,22 @@ func (vm *VM) EvaluateMulti(node ast.Node) (output map[string]string, err error)
 	return evaluateMulti(i, node, vm.tla, vm.StringOutput)
 }
 
+// EvaluateAST evaluates a Jsonnet program given by an Abstract Syntax Tree which was not
+// desugared and analyzed yet, e.g. one built in Go or returned by the parser, and returns
+// serialized JSON as string. The program can refer to the global variables of the VM.
+// The given node is not modified.
+func (vm *VM) EvaluateAST(node ast.Node) (json string, formattedErr error) {
+	prepared := ast.Clone(node)
+	if err := program.PreprocessAst(&prepared, vm.GlobalVars()...); err != nil {
+		return "", errors.New(vm.ErrorFormatter.Format(err))
+	}
+	json, err := vm.Evaluate(prepared)
+	if err != nil {
+		return "", errors.New(vm.ErrorFormatter.Format(err))
+	}
+	return json, nil
+}
+
 // Freeze builds the interpreter and makes it used by all subsequent evaluation calls.
 // This makes evaluation faster, however any future adjustments to native functions, global bindings etc. have no effect.
 // Also since the interpreter is no longer created separately for every evaluation, you shouldn't call more than one evaluation at the same time on such VM.
@@ -273,7 +826,7 @@ func (vm *VM) Freeze() error {
 		return fmt.Errorf("interpreter is already frozen")
 	}
 
-	i, err := buildInterpreter(vm.ext, vm.nativeFuncs, vm.globalBinding, vm.MaxStack, vm.importCache, vm.traceOut, vm.notifier)
+	i, err := buildInterpreter(vm)
 	if err != nil {
 		return err
 	}
@@ -287,7 +840,7 @@ func (vm *VM) buildInterpreter() (*interpreter, error) {
 		return vm.interpreter, nil
 	}
 
-	i, err := buildInterpreter(vm.ext, vm.nativeFuncs, vm.globalBinding, vm.MaxStack, vm.importCache, vm.traceOut, vm.notifier)
+	i, err := buildInterpreter(vm)
 	if err != nil {
 		return nil, err
 	}
@@ -296,33 +849,65 @@ func (vm *VM) buildInterpreter() (*interpreter, error) {
 }
 
 func (vm *VM) evaluateSnippet(diagnosticFileName ast.DiagnosticFileName, filename string, snippet string, kind evalKind) (output interface{}, err error) {
+	output, _, err = vm.evaluateSnippetTimed(diagnosticFileName, filename, snippet, kind)
+	return output, err
+}
+
+// evaluateSnippetTimed is like evaluateSnippet, but it also measures how long each phase took.
+func (vm *VM) evaluateSnippetTimed(diagnosticFileName ast.DiagnosticFileName, filename string, snippet string, kind evalKind) (output interface{}, timings Timings, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = fmt.Errorf("(CRASH) %v\n%s", r, debug.Stack())
 		}
 	}()
-	node, err := program.SnippetToAST(diagnosticFileName, filename, snippet, vm.GlobalVars()...)
+	node, astTimings, err := vm.snippetToASTTimed(diagnosticFileName, filename, snippet)
+	timings = Timings{
+		Lexing:         astTimings.Lexing,
+		Parsing:        astTimings.Parsing,
+		Desugaring:     astTimings.Desugaring,
+		StaticAnalysis: astTimings.StaticAnalysis,
+	}
 	if err != nil {
-		return "", err
+		return "", timings, err
 	}
 
+	start := time.Now()
+	defer func() {
+		timings.Evaluation = time.Since(start)
+	}()
 	i, err := vm.buildInterpreter()
 	if err != nil {
-		return "", err
+		return "", timings, err
 	}
 
 	switch kind {
 	case evalKindRegular:
-		output, err = evaluate(i, node, vm.tla, vm.StringOutput)
+		output, err = evaluate(i, node, vm.tla, vm.StringOutput, vm.stringOutputNewline)
 	case evalKindMulti:
 		output, err = evaluateMulti(i, node, vm.tla, vm.StringOutput)
 	case evalKindStream:
 		output, err = evaluateStream(i, node, vm.tla)
+	case evalKindJSON:
+		output, err = evaluateJSON(i, node, vm.tla)
+	case evalKindWithJSON:
+		var result outputWithJSON
+		result.output, result.json, err = evaluateWithJSON(i, node, vm.tla, vm.StringOutput, vm.stringOutputNewline)
+		output = result
 	}
 	if err != nil {
-		return "", err
+		return "", timings, err
 	}
-	return output, nil
+	return output, timings, nil
+}
+
+// Timings holds the time spent in each phase of evaluating a snippet.
+// Evaluation includes building the interpreter and manifesting the result.
+type Timings struct {
+	Lexing         time.Duration
+	Parsing        time.Duration
+	Desugaring     time.Duration
+	StaticAnalysis time.Duration
+	Evaluation     time.Duration
 }
 
 func getAbsPath(path string) (string, error) {
@@ -343,20 +928,20 @@ func getAbsPath(path string) (string, error) {
 	return cleanedAbsPath, nil
 }
 
-func (vm *VM) findDependencies(filePath string, node *ast.Node, dependencies map[string]struct{}, stackTrace *[]traceFrame) (err error) {
+func (vm *VM) findDependencies(filePath string, node *ast.Node, dependencies map[string]struct{}, stackTrace *[]TraceFrame) (err error) {
 	var cleanedAbsPath string
 	switch i := (*node).(type) {
 	case *ast.Import:
 		node, foundAt, err := vm.ImportAST(filePath, i.File.Value)
 		if err != nil {
-			*stackTrace = append([]traceFrame{{Loc: *i.Loc()}}, *stackTrace...)
+			*stackTrace = append([]TraceFrame{{Loc: *i.Loc()}}, *stackTrace...)
 			return err
 		}
 		cleanedAbsPath = foundAt
 		if _, isFileImporter := vm.importer.(*FileImporter); isFileImporter {
 			cleanedAbsPath, err = getAbsPath(foundAt)
 			if err != nil {
-				*stackTrace = append([]traceFrame{{Loc: *i.Loc()}}, *stackTrace...)
+				*stackTrace = append([]TraceFrame{{Loc: *i.Loc()}}, *stackTrace...)
 				return err
 			}
 		}
@@ -367,20 +952,20 @@ func (vm *VM) findDependencies(filePath string, node *ast.Node, dependencies map
 		dependencies[cleanedAbsPath] = struct{}{}
 		err = vm.findDependencies(foundAt, &node, dependencies, stackTrace)
 		if err != nil {
-			*stackTrace = append([]traceFrame{{Loc: *i.Loc()}}, *stackTrace...)
+			*stackTrace = append([]TraceFrame{{Loc: *i.Loc()}}, *stackTrace...)
 			return err
 		}
 	case *ast.ImportStr:
 		foundAt, err := vm.ResolveImport(filePath, i.File.Value)
 		if err != nil {
-			*stackTrace = append([]traceFrame{{Loc: *i.Loc()}}, *stackTrace...)
+			*stackTrace = append([]TraceFrame{{Loc: *i.Loc()}}, *stackTrace...)
 			return err
 		}
 		cleanedAbsPath = foundAt
 		if _, isFileImporter := vm.importer.(*FileImporter); isFileImporter {
 			cleanedAbsPath, err = getAbsPath(foundAt)
 			if err != nil {
-				*stackTrace = append([]traceFrame{{Loc: *i.Loc()}}, *stackTrace...)
+				*stackTrace = append([]TraceFrame{{Loc: *i.Loc()}}, *stackTrace...)
 				return err
 			}
 		}
@@ -388,14 +973,14 @@ func (vm *VM) findDependencies(filePath string, node *ast.Node, dependencies map
 	case *ast.ImportBin:
 		foundAt, err := vm.ResolveImport(filePath, i.File.Value)
 		if err != nil {
-			*stackTrace = append([]traceFrame{{Loc: *i.Loc()}}, *stackTrace...)
+			*stackTrace = append([]TraceFrame{{Loc: *i.Loc()}}, *stackTrace...)
 			return err
 		}
 		cleanedAbsPath = foundAt
 		if _, isFileImporter := vm.importer.(*FileImporter); isFileImporter {
 			cleanedAbsPath, err = getAbsPath(foundAt)
 			if err != nil {
-				*stackTrace = append([]traceFrame{{Loc: *i.Loc()}}, *stackTrace...)
+				*stackTrace = append([]TraceFrame{{Loc: *i.Loc()}}, *stackTrace...)
 				return err
 			}
 		}
@@ -426,6 +1011,42 @@ func (vm *VM) EvaluateSnippet(filename string, snippet string) (json string, for
 	return
 }
 
+// EvaluateSnippetField evaluates a string containing Jsonnet code just like EvaluateSnippet,
+// but only the value at fieldPath in the result is manifested, like `(snippet).path.to.field`.
+// The path consists of the field names, or the indexes of the array elements, separated
+// by slashes or dots, e.g. "path/to/field" or "path.to.0". The empty path selects
+// the whole result. The field names containing slashes or dots cannot be selected.
+// Selecting a field which doesn't exist is a runtime error mentioning the path.
+//
+// The filename parameter is used for resolving relative imports and for errors messages.
+func (vm *VM) EvaluateSnippetField(filename string, snippet string, fieldPath string) (json string, formattedErr error) {
+	path := strings.FieldsFunc(fieldPath, func(r rune) bool {
+		return r == '/' || r == '.'
+	})
+	json, err := vm.evaluateSnippetField(filename, snippet, path)
+	if err != nil {
+		return "", errors.New(vm.ErrorFormatter.Format(err))
+	}
+	return json, nil
+}
+
+func (vm *VM) evaluateSnippetField(filename string, snippet string, path []string) (output string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("(CRASH) %v\n%s", r, debug.Stack())
+		}
+	}()
+	node, err := vm.snippetToAST(ast.DiagnosticFileName(filename), filename, snippet)
+	if err != nil {
+		return "", err
+	}
+	i, err := vm.buildInterpreter()
+	if err != nil {
+		return "", err
+	}
+	return evaluateField(i, node, vm.tla, path, vm.StringOutput, vm.stringOutputNewline)
+}
+
 // EvaluateSnippetStream evaluates a string containing Jsonnet code to an array.
 // The array is returned as an array of JSON strings.
 //
@@ -456,6 +1077,184 @@ func (vm *VM) EvaluateSnippetMulti(filename string, snippet string) (files map[s
 	return
 }
 
+// EvaluateSnippetTimed evaluates a string containing Jsonnet code just like EvaluateSnippet,
+// and additionally reports how much time was spent in each phase of the evaluation.
+//
+// The filename parameter is used for resolving relative imports and for errors messages.
+func (vm *VM) EvaluateSnippetTimed(filename string, snippet string) (json string, timings Timings, formattedErr error) {
+	output, timings, err := vm.evaluateSnippetTimed(ast.DiagnosticFileName(filename), filename, snippet, evalKindRegular)
+	if err != nil {
+		return "", timings, errors.New(vm.ErrorFormatter.Format(err))
+	}
+	return output.(string), timings, nil
+}
+
+// Result bundles the output of an evaluation with the information collected while evaluating.
+// There are no warnings in it, as the evaluation doesn't produce any: the std members
+// deprecated by DeprecateStdMember fail with an error, and the linter is a separate package.
+type Result struct {
+	// Output is the manifested JSON (or string, with StringOutput enabled).
+	Output string
+	// Traces holds the messages printed by std.trace, in the order of evaluation.
+	Traces []string
+	// ImportedFiles holds the paths of all files imported during evaluation, sorted.
+	ImportedFiles []string
+	Timings       Timings
+}
+
+// traceRecorder collects the messages of std.trace instead of writing them out.
+type traceRecorder struct {
+	traces []string
+}
+
+func (r *traceRecorder) Write(p []byte) (int, error) {
+	r.traces = append(r.traces, strings.TrimSuffix(string(p), "\n"))
+	return len(p), nil
+}
+
+// EvaluateDetailed evaluates a string containing Jsonnet code just like EvaluateSnippetTimed,
+// and additionally collects the traces and the imported files. The traces are not written
+// to the trace output set by SetTraceOut.
+//
+// On error, the returned Result still contains everything collected before the failure.
+//
+// The filename parameter is used for resolving relative imports and for errors messages.
+func (vm *VM) EvaluateDetailed(filename string, snippet string) (result Result, formattedErr error) {
+	traces := &traceRecorder{}
+	importedFiles := make(map[string]struct{})
+
+	traceOut := vm.traceOut
+	vm.traceOut = traces
+	vm.importCache.recordImport = func(foundAt string) {
+		importedFiles[foundAt] = struct{}{}
+	}
+	if vm.interpreter != nil {
+		vm.interpreter.traceOut = traces
+	}
+	defer func() {
+		vm.traceOut = traceOut
+		vm.importCache.recordImport = nil
+		if vm.interpreter != nil {
+			vm.interpreter.traceOut = traceOut
+		}
+	}()
+
+	output, timings, err := vm.evaluateSnippetTimed(ast.DiagnosticFileName(filename), filename, snippet, evalKindRegular)
+	// The output is missing when the evaluation fails.
+	outputString, _ := output.(string)
+	result = Result{
+		Output:  outputString,
+		Traces:  traces.traces,
+		Timings: timings,
+	}
+	for foundAt := range importedFiles {
+		result.ImportedFiles = append(result.ImportedFiles, foundAt)
+	}
+	sort.Strings(result.ImportedFiles)
+	if err != nil {
+		return result, errors.New(vm.ErrorFormatter.Format(err))
+	}
+	return result, nil
+}
+
+// EvaluateInto evaluates a string containing Jsonnet code and stores the result
+// in the value pointed to by out, without serializing it to a JSON string first.
+// The result is converted following the encoding/json rules, including the `json` struct tags.
+//
+// The filename parameter is used for resolving relative imports and for errors messages.
+func (vm *VM) EvaluateInto(filename string, snippet string, out interface{}) (formattedErr error) {
+	output, err := vm.evaluateSnippet(ast.DiagnosticFileName(filename), filename, snippet, evalKindJSON)
+	if err != nil {
+		return errors.New(vm.ErrorFormatter.Format(err))
+	}
+	return jsonToGoValue(output, out)
+}
+
+// RegisterOutputFormat registers a format for EvaluateSnippetAs. The formats "json" and "yaml"
+// are available without registering them, but they can be replaced. They follow the output
+// settings of the VM, "json" is the regular JSON output and "yaml" is like std.manifestYamlDoc.
+func (vm *VM) RegisterOutputFormat(name string, f OutputFormatter) {
+	if vm.outputFormats == nil {
+		vm.outputFormats = make(map[string]OutputFormatter)
+	}
+	vm.outputFormats[name] = f
+}
+
+// OutputFormat returns the output format of the given name, the registered one or the builtin one,
+// e.g. for wrapping it. It returns false if there is no such format.
+func (vm *VM) OutputFormat(name string) (OutputFormatter, bool) {
+	if f, ok := vm.outputFormats[name]; ok {
+		return f, true
+	}
+	if makeFormatter, ok := builtinOutputFormats[name]; ok {
+		return makeFormatter(vm), true
+	}
+	return nil, false
+}
+
+// EvaluateSnippetAs evaluates a string containing Jsonnet code and serializes the result
+// with the output format of the given name, see RegisterOutputFormat.
+//
+// The filename parameter is used for resolving relative imports and for errors messages.
+func (vm *VM) EvaluateSnippetAs(format string, filename string, snippet string) (output []byte, formattedErr error) {
+	f, ok := vm.OutputFormat(format)
+	if !ok {
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+	result, err := vm.evaluateSnippet(ast.DiagnosticFileName(filename), filename, snippet, evalKindJSON)
+	if err != nil {
+		return nil, errors.New(vm.ErrorFormatter.Format(err))
+	}
+	output, err = f.Format(result)
+	if err != nil {
+		return nil, fmt.Errorf("output format %q: %v", format, err)
+	}
+	return output, nil
+}
+
+// EvaluateToAST evaluates a string containing Jsonnet code and returns the result
+// as a literal AST made of objects, arrays and literals, instead of a JSON string.
+// The object fields are sorted by name. The AST can be e.g. compared structurally
+// or formatted back to Jsonnet code.
+//
+// The filename parameter is used for resolving relative imports and for errors messages.
+func (vm *VM) EvaluateToAST(filename string, snippet string) (node ast.Node, formattedErr error) {
+	output, err := vm.evaluateSnippet(ast.DiagnosticFileName(filename), filename, snippet, evalKindJSON)
+	if err != nil {
+		return nil, errors.New(vm.ErrorFormatter.Format(err))
+	}
+	return jsonToNode(output), nil
+}
+
+// EvaluateAndValidate evaluates a string containing Jsonnet code like EvaluateSnippet
+// and validates the result against a JSON Schema. The output is returned even if
+// the result does not conform to the schema, the violations are returned as SchemaErrors.
+// An error is returned if the evaluation fails or the schema is invalid.
+//
+// The supported keywords are type, enum, const, minimum, maximum, exclusiveMinimum,
+// exclusiveMaximum, multipleOf, minLength, maxLength, pattern, items, minItems, maxItems,
+// uniqueItems, properties, patternProperties, additionalProperties, required,
+// minProperties, maxProperties, allOf, anyOf, oneOf, not and $ref to the same document.
+// The other keywords are ignored.
+//
+// The filename parameter is used for resolving relative imports and for errors messages.
+func (vm *VM) EvaluateAndValidate(filename string, snippet string, schemaJSON string) (json string, schemaErrors []SchemaError, formattedErr error) {
+	validator, err := makeSchemaValidator(schemaJSON)
+	if err != nil {
+		return "", nil, err
+	}
+	output, err := vm.evaluateSnippet(ast.DiagnosticFileName(filename), filename, snippet, evalKindWithJSON)
+	if err != nil {
+		return "", nil, errors.New(vm.ErrorFormatter.Format(err))
+	}
+	result := output.(outputWithJSON)
+	schemaErrors, err = validator.validate(validator.root, result.json, "")
+	if err != nil {
+		return "", nil, err
+	}
+	return result.output, schemaErrors, nil
+}
+
 // EvaluateAnonymousSnippet evaluates a string containing Jsonnet code, return a JSON
 // string.
 //
@@ -543,12 +1342,111 @@ func (vm *VM) EvaluateFileMulti(filename string) (files map[string]string, forma
 	return output, nil
 }
 
+// SnippetInput is a single snippet evaluated by EvaluateBatch.
+type SnippetInput struct {
+	// Filename is used for resolving relative imports and for error messages.
+	Filename string
+	Snippet  string
+}
+
+// BatchResult is the result of evaluating a single SnippetInput.
+// Either Output or Err is set.
+type BatchResult struct {
+	Output string
+	Err    error
+}
+
+func (vm *VM) evaluateBatchSnippet(i *interpreter, input SnippetInput) (output string, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("(CRASH) %v\n%s", r, debug.Stack())
+		}
+	}()
+	node, err := vm.snippetToAST(ast.DiagnosticFileName(input.Filename), input.Filename, input.Snippet)
+	if err != nil {
+		return "", err
+	}
+	return evaluate(i, node, vm.tla, vm.StringOutput, vm.stringOutputNewline)
+}
+
+// EvaluateBatch evaluates several snippets, each of them just like EvaluateSnippet would.
+// The interpreter, the import cache and the parsed imported files are shared by all the snippets,
+// which makes it much faster than evaluating them one by one, if they import common libraries.
+// The values of the imported files are not shared when the imports are limited, see SetMaxImports.
+//
+// The results are returned in the same order as the inputs. A failure of one snippet is reported
+// in its result and does not affect the others. The returned error is set only if the evaluation
+// could not be started at all.
+func (vm *VM) EvaluateBatch(inputs []SnippetInput) ([]BatchResult, error) {
+	i, err := vm.buildInterpreter()
+	if err != nil {
+		return nil, errors.New(vm.ErrorFormatter.Format(err))
+	}
+	results := make([]BatchResult, len(inputs))
+	for index, input := range inputs {
+		output, err := vm.evaluateBatchSnippet(i, input)
+		if err != nil {
+			results[index].Err = errors.New(vm.ErrorFormatter.Format(err))
+			continue
+		}
+		results[index].Output = output
+	}
+	return results, nil
+}
+
+// AssertEqualFailure describes a failed std.assertEqual(a, b) check.
+// Actual is the manifested value of a and Expected of b.
+type AssertEqualFailure struct {
+	Actual   interface{}
+	Expected interface{}
+	Loc      ast.LocationRange
+}
+
+func (vm *VM) evaluateAssertEqualReport(filename string, snippet string) (output string, failures []AssertEqualFailure, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("(CRASH) %v\n%s", r, debug.Stack())
+		}
+	}()
+	node, err := vm.snippetToAST(ast.DiagnosticFileName(filename), filename, snippet)
+	if err != nil {
+		return "", nil, err
+	}
+	// A dedicated interpreter is always built. The values of the imported files are
+	// cached separately, since std.assertEqual behaves differently in them.
+	i, err := buildInterpreter(vm)
+	if err != nil {
+		return "", nil, err
+	}
+	i.collectAssertEqualFailures = true
+	i.importCache = vm.importCache.withOwnValueCache()
+
+	output, err = evaluate(i, node, vm.tla, vm.StringOutput, vm.stringOutputNewline)
+	return output, i.assertEqualFailures, err
+}
+
+// EvaluateAssertEqualReport evaluates a string containing Jsonnet code just like EvaluateSnippet,
+// but the failed std.assertEqual checks do not stop the evaluation. Such std.assertEqual calls
+// return true and the failures are collected and returned instead.
+// This allows running a Jsonnet file with embedded assertions as a test suite.
+//
+// The failures collected before an evaluation error are returned together with the error.
+//
+// The filename parameter is used for resolving relative imports and for errors messages.
+func (vm *VM) EvaluateAssertEqualReport(filename string, snippet string) (json string, failures []AssertEqualFailure, formattedErr error) {
+	json, failures, err := vm.evaluateAssertEqualReport(filename, snippet)
+	if err != nil {
+		return "", failures, errors.New(vm.ErrorFormatter.Format(err))
+	}
+	return json, failures, nil
+}
+
 // FindDependencies returns a sorted array of unique transitive dependencies (via import/importstr/importbin)
 // from all the given `importedPaths` which are themselves excluded from the returned array.
 // The `importedPaths` are parsed as if they were imported from a Jsonnet file located at `importedFrom`.
 func (vm *VM) FindDependencies(importedFrom string, importedPaths []string) ([]string, error) {
 	var nodes []*ast.Node
-	var stackTrace []traceFrame
+	var stackTrace []TraceFrame
 	filePaths := make([]string, len(importedPaths))
 	depsToExclude := make([]string, len(importedPaths))
 	deps := make(map[string]struct{})
@@ -622,8 +1520,156 @@ func (vm *VM) ImportAST(importedFrom, importedPath string) (contents ast.Node, f
 }
 
 // SnippetToAST parses a snippet and returns the resulting AST.
+// The problems found in the snippet are reported as StaticError.
 func SnippetToAST(filename string, snippet string, globalVars ...ast.Identifier) (ast.Node, error) {
-	return program.SnippetToAST(ast.DiagnosticFileName(filename), filename, snippet, globalVars...)
+	node, err := program.SnippetToAST(ast.DiagnosticFileName(filename), filename, snippet, globalVars...)
+	if err != nil {
+		return nil, makeStaticError(err)
+	}
+	return node, nil
+}
+
+// Validate checks that a snippet is a correct Jsonnet program without evaluating it,
+// i.e. that it can be parsed and refers only to the defined variables, including
+// the global variables and the preamble locals of the VM. The imported files are not checked.
+// The problems found in the snippet are reported as StaticError.
+func (vm *VM) Validate(filename string, snippet string) error {
+	_, err := vm.snippetToAST(ast.DiagnosticFileName(filename), filename, snippet)
+	if err != nil {
+		return makeStaticError(err)
+	}
+	return nil
+}
+
+// RequiredExtVars returns the sorted names of the external variables which a snippet
+// reads with std.extVar, without evaluating it, e.g. to ask for their values beforehand.
+// Only the calls with a literal name are found. Dynamic is true if the snippet also uses
+// std.extVar in another way, e.g. with a computed name, so that the names may be incomplete.
+// The imported files are not checked. The snippet can refer to the global variables of the VM
+// and the preamble, whose uses of std.extVar are included.
+func (vm *VM) RequiredExtVars(filename string, snippet string) (names []string, dynamic bool, err error) {
+	node, err := vm.snippetToAST(ast.DiagnosticFileName(filename), filename, snippet)
+	if err != nil {
+		return nil, false, makeStaticError(err)
+	}
+	names, dynamic = program.ExtVarNames(node)
+	return names, dynamic, nil
+}
+
+// ValidateExtAndTLA checks that all the external variables and top-level arguments
+// given as code can be parsed, and returns all the problems instead of only the first one.
+// The problems are reported as StaticError, located in the pseudo-files like
+// <extvar:name> and <top-level-arg:name>, first for the external variables
+// and then for the top-level arguments, each sorted by the name. The code can refer
+// only to std, so the global variables of the VM are not allowed.
+func (vm *VM) ValidateExtAndTLA() []error {
+	var errs []error
+	check := func(ext vmExtMap, kind string) {
+		names := make([]string, 0, len(ext))
+		for name, content := range ext {
+			if content.kind == extKindCode {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			diagnosticFile := "<" + kind + ":" + name + ">"
+			_, err := program.SnippetToAST(ast.DiagnosticFileName(diagnosticFile), diagnosticFile, ext[name].value)
+			if err != nil {
+				errs = append(errs, makeStaticError(err))
+			}
+		}
+	}
+	check(vm.ext, "extvar")
+	check(vm.tla, "top-level-arg")
+	return errs
+}
+
+// Token is a single lexeme of Jsonnet code, as returned by Lex.
+type Token = parser.Token
+
+// TokenKind is a coarse classification of tokens, meant for syntax highlighting.
+type TokenKind = parser.TokenKind
+
+// The kinds of tokens returned by Lex.
+const (
+	TokenPunctuation = parser.TokenPunctuation
+	TokenIdentifier  = parser.TokenIdentifier
+	TokenNumber      = parser.TokenNumber
+	TokenOperator    = parser.TokenOperator
+	TokenString      = parser.TokenString
+	TokenKeyword     = parser.TokenKeyword
+	TokenEndOfFile   = parser.TokenEndOfFile
+)
+
+// Lex splits the snippet into tokens, ending with a TokenEndOfFile token.
+// Comments and whitespace are skipped. The problems found in the snippet
+// are reported as StaticError.
+func Lex(filename string, snippet string) ([]Token, error) {
+	tokens, err := parser.LexTokens(ast.DiagnosticFileName(filename), filename, snippet)
+	if err != nil {
+		return nil, makeStaticError(err)
+	}
+	return tokens, nil
+}
+
+// StringLiteral is a string literal found in Jsonnet code.
+type StringLiteral struct {
+	Value string
+	Loc   ast.LocationRange
+	// Dynamic is true if the string is used as a format string (the left operand
+	// of the % operator), i.e. the result has interpolated parts which are not known statically.
+	Dynamic bool
+}
+
+// ExtractStrings returns all string literals in the given Jsonnet code, in the order
+// of their location. Import paths are not included.
+func ExtractStrings(filename string, snippet string) ([]StringLiteral, error) {
+	node, _, err := parser.SnippetToRawAST(ast.DiagnosticFileName(filename), filename, snippet)
+	if err != nil {
+		return nil, makeStaticError(err)
+	}
+	var literals []StringLiteral
+	var walk func(node ast.Node, dynamic bool)
+	walk = func(node ast.Node, dynamic bool) {
+		switch node := node.(type) {
+		case *ast.LiteralString:
+			literals = append(literals, StringLiteral{Value: node.Value, Loc: node.LocRange, Dynamic: dynamic})
+			return
+		case *ast.Binary:
+			if node.Op == ast.BopPercent {
+				walk(node.Left, true)
+				walk(node.Right, false)
+				return
+			}
+		}
+		for _, child := range parser.Children(node) {
+			walk(child, false)
+		}
+	}
+	walk(node, false)
+	sort.SliceStable(literals, func(a, b int) bool {
+		beginA, beginB := literals[a].Loc.Begin, literals[b].Loc.Begin
+		if beginA.Line != beginB.Line {
+			return beginA.Line < beginB.Line
+		}
+		return beginA.Column < beginB.Column
+	})
+	return literals, nil
+}
+
+// ManifestJsonnet renders a value as Jsonnet source code, which evaluates back to the same value.
+// The value must be in the standard Go JSON representation, i.e. nil, bool, float64, string,
+// []interface{} or map[string]interface{}, the same as the values passed to native functions.
+//
+// Unlike JSON, the output uses unquoted field names where possible and single-quoted strings.
+func ManifestJsonnet(value interface{}) (string, error) {
+	var buf bytes.Buffer
+	if err := serializeJsonnet(value, "", &buf); err != nil {
+		return "", err
+	}
+	buf.WriteString("\n")
+	return buf.String(), nil
 }
 
 // Version returns the Jsonnet version number.
@@ -102,6 +102,8 @@ func (r *YAMLReader) Read() ([]byte, error) {
 				if err == io.EOF {
 					return nil, err
 				}
+				// Skip the separator of an empty document.
+				continue
 			}
 		}
 		if err == io.EOF {